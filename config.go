@@ -0,0 +1,538 @@
+package CTObject
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n-ct/ct-monitor/signature"
+	mtrUtils "github.com/n-ct/ct-monitor/utils"
+)
+
+// EnvPrefix starts the name of every environment variable that overrides the configuration.
+// The rest of the name is the JSON path in upper case joined by underscores, for example
+// GOSSIPER_LISTEN_ADDRESS or GOSSIPER_TIMEOUTS_PEER_REQUEST_MS. Lists are comma separated.
+const EnvPrefix = "GOSSIPER"
+
+//structs for JSON files
+type GossipConfig struct{
+	Monitors_ids []string `json:"monitor_ids"`
+	Monitor_id string `json:"monitor_id"`
+	Listen_address string `json:"listen_address"` //host:port to bind, all interfaces on the port of advertised_url if empty
+	Advertised_url string `json:"advertised_url"` //URL peers reach us on, gossiper_url from the monitor list if empty
+	Unix_socket string `json:"unix_socket"` //also serve plain HTTP on this socket, for a monitor on the same host
+	Timeouts TimeoutConfig `json:"timeouts"`
+	Blob_threshold int `json:"blob_threshold"` //blobs larger than this are only sent when the peer asks for them
+	Queues QueueConfig `json:"queues"`
+	Storage StorageConfig `json:"storage"`
+	TLS TLSConfig `json:"tls"`
+	Retention RetentionConfig `json:"retention"`
+	Admission AdmissionConfig `json:"admission"`
+	Peer_scoring PeerScoringConfig `json:"peer_scoring"`
+	Membership MembershipConfig `json:"membership"`
+	Admin AdminConfig `json:"admin"`
+	Notifiers []NotifierConfig `json:"notifiers"`
+	Audit AuditConfig `json:"audit"`
+	Auditor AuditorConfig `json:"auditor"`
+	Fetcher FetcherConfig `json:"fetcher"`
+	Pollination PollinationConfig `json:"pollination"`
+	SCT_feedback SCTFeedbackConfig `json:"sct_feedback"`
+	Witness WitnessConfig `json:"witness"`
+}
+
+//timeouts in milliseconds
+type TimeoutConfig struct{
+	Peer_request_ms int `json:"peer_request_ms"` //time allowed for a request to a peer or the monitor
+	Monitor_dial_ms int `json:"monitor_dial_ms"` //time allowed to check that the monitor is reachable
+	Read_header_ms int `json:"read_header_ms"`
+	Read_ms int `json:"read_ms"`
+	Write_ms int `json:"write_ms"`
+	Idle_ms int `json:"idle_ms"`
+	Shutdown_grace_ms int `json:"shutdown_grace_ms"` //time allowed for handlers and outbound queues to finish on shutdown
+}
+
+//sizes of the outbound queues, objects are dropped when a queue is full
+type QueueConfig struct{
+	Peer_queue_size int `json:"peer_queue_size"` //objects waiting to be sent to each peer
+	Monitor_queue_size int `json:"monitor_queue_size"`
+}
+
+//where stored objects are kept between restarts, only in memory if path is empty
+type StorageConfig struct{
+	Path string `json:"path"`
+	Flush_interval_seconds int `json:"flush_interval_seconds"`
+}
+
+//TLS is used for the server when cert_file and key_file are set, the same pair is presented to peers
+type TLSConfig struct{
+	Cert_file string `json:"cert_file"`
+	Key_file string `json:"key_file"`
+	Client_ca_file string `json:"client_ca_file"` //when set, senders must present a certificate signed by this CA
+	Ca_file string `json:"ca_file"` //CA used to verify peers, the system roots if empty
+}
+
+//how long stored objects are kept
+type RetentionConfig struct{
+	Max_age_seconds int `json:"max_age_seconds"` //objects with an older timestamp are removed, 0 keeps everything
+	Prune_interval_seconds int `json:"prune_interval_seconds"`
+}
+
+//limits applied to incoming gossip requests, a zero value means no limit
+type AdmissionConfig struct{
+	Peer_rate float64 `json:"peer_rate"` //requests per second allowed from each peer identified by its client certificate
+	Peer_burst int `json:"peer_burst"`
+	Source_rate float64 `json:"source_rate"` //requests per second allowed from each source IP
+	Source_burst int `json:"source_burst"`
+	Max_request_bytes int64 `json:"max_request_bytes"`
+	Max_in_flight int `json:"max_in_flight"` //handlers allowed to run at the same time
+}
+
+//thresholds used to stop accepting from or forwarding to misbehaving peers, unset thresholds take their default
+type PeerScoringConfig struct{
+	Enabled bool `json:"enabled"`
+	Reject_threshold *int `json:"reject_threshold"` //peers at or below this score are banned
+	Forward_threshold *int `json:"forward_threshold"` //peers below this score do not receive gossip
+	Ban_seconds int `json:"ban_seconds"` //how long a ban lasts before the score is reset
+}
+
+//the admin API is disabled unless a token is set
+type AdminConfig struct{
+	Token string `json:"token"` //expected in the Authorization header as "Bearer <token>"
+}
+
+//every gossip request is recorded in the audit log when path is set. Records are chained with
+//HMAC-SHA256 under hmac_key, so the log can only be rewritten by someone who has the key
+type AuditConfig struct{
+	Path string `json:"path"`
+	Hmac_key string `json:"hmac_key"` //required with path, changing it breaks the chain of the records already written
+	Max_size_mb int `json:"max_size_mb"` //the file is rotated to path.1 when it grows past this size
+	Max_files int `json:"max_files"` //rotated files kept, the oldest is removed
+}
+
+//the auditor asks the logs in the log list for consistency proofs between the STHs we store
+type AuditorConfig struct{
+	Enabled bool `json:"enabled"`
+	Interval_ms int `json:"interval_ms"` //time between two audits of every log
+	Timeout_ms int `json:"timeout_ms"` //time allowed for a request to a log
+}
+
+//the fetcher polls get-sth on the logs in the log list and handles the STHs like gossiped ones
+type FetcherConfig struct{
+	Enabled bool `json:"enabled"`
+	Interval_ms int `json:"interval_ms"` //time between two polls of a log
+	Log_intervals_ms map[string]int `json:"log_intervals_ms"` //[LogID] interval for the logs polled at another rate
+	Timeout_ms int `json:"timeout_ms"` //time allowed for a request to a log
+	Max_backoff_ms int `json:"max_backoff_ms"` //the wait doubles after each failed poll of a log, up to this
+}
+
+//clients exchange STHs with the gossiper through STH pollination when enabled
+type PollinationConfig struct{
+	Enabled bool `json:"enabled"`
+	Freshness_window_seconds int `json:"freshness_window_seconds"` //only STHs issued within this window are accepted or returned
+	Max_sths int `json:"max_sths"` //STHs accepted from and returned to a client in one request
+}
+
+//clients submit the SCTs they received through SCT feedback when enabled, their inclusion is checked once the MMD of the log has passed
+type SCTFeedbackConfig struct{
+	Enabled bool `json:"enabled"`
+	Max_scts int `json:"max_scts"` //SCTs kept, the oldest is dropped first
+	Audit_interval_ms int `json:"audit_interval_ms"` //time between two inclusion checks of the SCTs past their MMD
+	Timeout_ms int `json:"timeout_ms"` //time allowed for a request to a log
+	Priv_key string `json:"priv_key"` //base64 DER EC key of the monitor_id, the alerts about certificates a log did not include are signed with it
+}
+
+//as a witness the gossiper cosigns the STHs it checked to be consistent with the last one it cosigned for the log
+type WitnessConfig struct{
+	Enabled bool `json:"enabled"`
+	Priv_key string `json:"priv_key"` //base64 DER EC key of the monitor_id, whose public key is in the monitor list
+	Timeout_ms int `json:"timeout_ms"` //time allowed for a consistency proof request to a log
+	State_path string `json:"state_path"` //file the last STH cosigned for each log is kept in, it is never pruned
+	Queue_size int `json:"queue_size"` //STHs of each log waiting to be checked, newer ones are dropped when it is full
+}
+
+//kinds of notifier and the events they can be sent
+const (
+	NotifierWebhook = "webhook"
+	NotifierCommand = "command"
+	NotifierJSONL = "jsonl"
+
+	EventPoMCreated = "pom_created" //this gossiper detected conflicting STHs
+	EventPoMReceived = "pom_received" //a peer sent us a PoM
+	EventAlertReceived = "alert_received"
+	EventSCTNotIncluded = "sct_not_included" //a log did not include a certificate it issued an SCT for within its MMD
+	EventInconsistentProof = "inconsistent_proof" //a log answered this gossiper with a consistency proof that does not verify
+)
+
+//a notifier is told about misbehavior, empty filters match everything
+type NotifierConfig struct{
+	Type string `json:"type"` //webhook, command or jsonl
+	URL string `json:"url"` //webhook: where the payload is posted
+	Secret string `json:"secret"` //webhook: payload signed with HMAC-SHA256 in the X-Gossiper-Signature header
+	Max_retries int `json:"max_retries"` //webhook and command: attempts after the first one fails
+	Timeout_ms int `json:"timeout_ms"`
+	Command []string `json:"command"` //command: program and arguments, the payload is written to its stdin
+	Path string `json:"path"` //jsonl: file every payload is appended to
+	Events []string `json:"events"` //pom_created, pom_received, alert_received, sct_not_included or inconsistent_proof
+	Log_ids []string `json:"log_ids"`
+	Type_ids []string `json:"type_ids"`
+}
+
+//settings of the SWIM style membership protocol, monitor_ids are used as seeds when enabled
+type MembershipConfig struct{
+	Enabled bool `json:"enabled"`
+	Probe_interval_ms int `json:"probe_interval_ms"`
+	Probe_timeout_ms int `json:"probe_timeout_ms"`
+	Indirect_probes int `json:"indirect_probes"` //members asked to probe a peer that did not answer
+	Suspect_timeout_ms int `json:"suspect_timeout_ms"` //time a suspect member has to answer before it is removed
+	Max_clock_skew_ms int `json:"max_clock_skew_ms"` //messages with a timestamp further from our clock are refused
+	Priv_key string `json:"priv_key"` //base64 DER EC private key of our monitor, membership messages are signed with it
+}
+
+// ConfigError lists every problem found in a configuration.
+type ConfigError struct{
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid gossip configuration: %s", strings.Join(e.Problems, "; "))
+}
+
+func (e *ConfigError) add(format string, args ...interface{}){
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// NewGossipConfig reads the gossiper configuration from filename, applies the
+// environment overrides and the defaults, and validates the result.
+// Unknown fields are rejected so that typos do not go unnoticed.
+func NewGossipConfig (filename string) (*GossipConfig, error) {
+	var gossipConfig GossipConfig;
+	byteData, err := mtrUtils.FiletoBytes(filename)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(byteData))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&gossipConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse gossip configuration %s: %v", filename, err)
+	}
+
+	configErr := &ConfigError{}
+	applyEnv(reflect.ValueOf(&gossipConfig).Elem(), EnvPrefix, configErr)
+	gossipConfig.applyDefaults()
+	gossipConfig.validate(configErr)
+	if len(configErr.Problems) != 0 {
+		return nil, configErr
+	}
+	return &gossipConfig, nil
+}
+
+//applyDefaults fills in every setting that was left unset
+func (c *GossipConfig) applyDefaults(){
+	setDefault(&c.Timeouts.Peer_request_ms, 10000)
+	setDefault(&c.Timeouts.Monitor_dial_ms, 1000)
+	setDefault(&c.Timeouts.Read_header_ms, 10000)
+	setDefault(&c.Timeouts.Read_ms, 30000)
+	setDefault(&c.Timeouts.Write_ms, 30000)
+	setDefault(&c.Timeouts.Idle_ms, 120000)
+	setDefault(&c.Timeouts.Shutdown_grace_ms, 30000)
+	setDefault(&c.Blob_threshold, Threshold)
+	setDefault(&c.Queues.Peer_queue_size, 1000)
+	setDefault(&c.Queues.Monitor_queue_size, 1000)
+	setDefault(&c.Storage.Flush_interval_seconds, 60)
+	setDefault(&c.Retention.Prune_interval_seconds, 3600)
+	setDefault(&c.Audit.Max_size_mb, 100)
+	setDefault(&c.Audit.Max_files, 10)
+	setDefault(&c.Auditor.Interval_ms, 60000)
+	setDefault(&c.Auditor.Timeout_ms, 10000)
+	setDefault(&c.Fetcher.Interval_ms, 60000)
+	setDefault(&c.Fetcher.Timeout_ms, 10000)
+	setDefault(&c.Fetcher.Max_backoff_ms, 3600000)
+	setDefault(&c.Pollination.Freshness_window_seconds, 14 * 24 * 3600)
+	setDefault(&c.Pollination.Max_sths, 100)
+	setDefault(&c.SCT_feedback.Max_scts, 10000)
+	setDefault(&c.SCT_feedback.Audit_interval_ms, 600000)
+	setDefault(&c.SCT_feedback.Timeout_ms, 10000)
+	setDefault(&c.Witness.Timeout_ms, 10000)
+	setDefault(&c.Witness.Queue_size, 100)
+	for i := range c.Notifiers {
+		setDefault(&c.Notifiers[i].Timeout_ms, 5000)
+		if c.Notifiers[i].Max_retries == 0 && c.Notifiers[i].Type != NotifierJSONL {
+			c.Notifiers[i].Max_retries = 3
+		}
+	}
+}
+
+func setDefault(field *int, value int){
+	if *field == 0 {
+		*field = value
+	}
+}
+
+//validate adds every problem in the configuration to configErr
+func (c *GossipConfig) validate(configErr *ConfigError){
+	if len(c.Monitor_id) == 0 {
+		configErr.add("monitor_id is required")
+	}
+	seen := make(map[string]bool)
+	for _, monitorID := range c.Monitors_ids {
+		if monitorID == c.Monitor_id {
+			configErr.add("monitor_ids contains this gossiper's monitor_id %v", monitorID)
+		}
+		if seen[monitorID] {
+			configErr.add("monitor_ids contains %v more than once", monitorID)
+		}
+		seen[monitorID] = true
+	}
+
+	if len(c.Listen_address) != 0 {
+		if _, _, err := net.SplitHostPort(c.Listen_address); err != nil {
+			configErr.add("listen_address %q: %v", c.Listen_address, err)
+		}
+	}
+	parentDirs := []struct{ name, filename string }{
+		{"unix_socket", c.Unix_socket},
+		{"audit.path", c.Audit.Path},
+		{"witness.state_path", c.Witness.State_path},
+	}
+	for _, parent := range parentDirs {
+		if len(parent.filename) == 0 {
+			continue
+		}
+		if info, err := os.Stat(filepath.Dir(parent.filename)); err != nil {
+			configErr.add("%s %q: %v", parent.name, parent.filename, err)
+		} else if !info.IsDir() {
+			configErr.add("%s %q: %v is not a directory", parent.name, parent.filename, filepath.Dir(parent.filename))
+		}
+	}
+	if len(c.Advertised_url) != 0 {
+		if u, err := url.Parse(c.Advertised_url); err != nil {
+			configErr.add("advertised_url %q: %v", c.Advertised_url, err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			configErr.add("advertised_url %q must be an absolute http or https URL", c.Advertised_url)
+		}
+	}
+
+	nonNegative := map[string]int{
+		"timeouts.peer_request_ms": c.Timeouts.Peer_request_ms,
+		"timeouts.monitor_dial_ms": c.Timeouts.Monitor_dial_ms,
+		"timeouts.read_header_ms": c.Timeouts.Read_header_ms,
+		"timeouts.read_ms": c.Timeouts.Read_ms,
+		"timeouts.write_ms": c.Timeouts.Write_ms,
+		"timeouts.idle_ms": c.Timeouts.Idle_ms,
+		"timeouts.shutdown_grace_ms": c.Timeouts.Shutdown_grace_ms,
+		"blob_threshold": c.Blob_threshold,
+		"queues.peer_queue_size": c.Queues.Peer_queue_size,
+		"queues.monitor_queue_size": c.Queues.Monitor_queue_size,
+		"storage.flush_interval_seconds": c.Storage.Flush_interval_seconds,
+		"retention.max_age_seconds": c.Retention.Max_age_seconds,
+		"retention.prune_interval_seconds": c.Retention.Prune_interval_seconds,
+		"audit.max_size_mb": c.Audit.Max_size_mb,
+		"audit.max_files": c.Audit.Max_files,
+		"auditor.interval_ms": c.Auditor.Interval_ms,
+		"auditor.timeout_ms": c.Auditor.Timeout_ms,
+		"fetcher.interval_ms": c.Fetcher.Interval_ms,
+		"fetcher.timeout_ms": c.Fetcher.Timeout_ms,
+		"fetcher.max_backoff_ms": c.Fetcher.Max_backoff_ms,
+		"pollination.freshness_window_seconds": c.Pollination.Freshness_window_seconds,
+		"pollination.max_sths": c.Pollination.Max_sths,
+		"sct_feedback.max_scts": c.SCT_feedback.Max_scts,
+		"sct_feedback.audit_interval_ms": c.SCT_feedback.Audit_interval_ms,
+		"sct_feedback.timeout_ms": c.SCT_feedback.Timeout_ms,
+		"witness.timeout_ms": c.Witness.Timeout_ms,
+		"witness.queue_size": c.Witness.Queue_size,
+		"admission.peer_burst": c.Admission.Peer_burst,
+		"admission.source_burst": c.Admission.Source_burst,
+		"admission.max_in_flight": c.Admission.Max_in_flight,
+		"peer_scoring.ban_seconds": c.Peer_scoring.Ban_seconds,
+		"membership.probe_interval_ms": c.Membership.Probe_interval_ms,
+		"membership.probe_timeout_ms": c.Membership.Probe_timeout_ms,
+		"membership.indirect_probes": c.Membership.Indirect_probes,
+		"membership.suspect_timeout_ms": c.Membership.Suspect_timeout_ms,
+		"membership.max_clock_skew_ms": c.Membership.Max_clock_skew_ms,
+	}
+	for logID, interval := range c.Fetcher.Log_intervals_ms {
+		nonNegative["fetcher.log_intervals_ms."+logID] = interval
+	}
+	for _, name := range sortedKeys(nonNegative) {
+		if nonNegative[name] < 0 {
+			configErr.add("%s must not be negative, got %v", name, nonNegative[name])
+		}
+	}
+	if c.Admission.Peer_rate < 0 || c.Admission.Source_rate < 0 || c.Admission.Max_request_bytes < 0 {
+		configErr.add("admission limits must not be negative")
+	}
+	if c.Peer_scoring.Enabled && c.Peer_scoring.Reject_threshold != nil && c.Peer_scoring.Forward_threshold != nil &&
+		*c.Peer_scoring.Forward_threshold <= *c.Peer_scoring.Reject_threshold {
+		configErr.add("peer_scoring.forward_threshold must be above reject_threshold")
+	}
+
+	if (len(c.TLS.Cert_file) == 0) != (len(c.TLS.Key_file) == 0) {
+		configErr.add("tls.cert_file and tls.key_file must be set together")
+	}
+	if len(c.TLS.Client_ca_file) != 0 && len(c.TLS.Cert_file) == 0 {
+		configErr.add("tls.client_ca_file requires tls.cert_file and tls.key_file")
+	}
+	if len(c.Audit.Path) != 0 && len(c.Audit.Hmac_key) == 0 {
+		configErr.add("audit.hmac_key is required when audit.path is set")
+	}
+	if c.Membership.Enabled && len(c.Membership.Priv_key) == 0 {
+		configErr.add("membership.priv_key is required when membership is enabled")
+	} else if len(c.Membership.Priv_key) != 0 {
+		if _, err := signature.NewSigner(c.Membership.Priv_key); err != nil {
+			configErr.add("membership.priv_key: %v", err)
+		}
+	}
+	if c.SCT_feedback.Enabled && len(c.SCT_feedback.Priv_key) == 0 {
+		configErr.add("sct_feedback.priv_key is required when sct_feedback is enabled")
+	} else if len(c.SCT_feedback.Priv_key) != 0 {
+		if _, err := signature.NewSigner(c.SCT_feedback.Priv_key); err != nil {
+			configErr.add("sct_feedback.priv_key: %v", err)
+		}
+	}
+	if c.Witness.Enabled && len(c.Witness.Priv_key) == 0 {
+		configErr.add("witness.priv_key is required when the witness is enabled")
+	} else if len(c.Witness.Priv_key) != 0 {
+		if _, err := signature.NewSigner(c.Witness.Priv_key); err != nil {
+			configErr.add("witness.priv_key: %v", err)
+		}
+	}
+	if c.Witness.Enabled && len(c.Witness.State_path) == 0 {
+		configErr.add("witness.state_path is required when the witness is enabled")
+	}
+	for i, notifier := range c.Notifiers {
+		c.validateNotifier(fmt.Sprintf("notifiers[%d]", i), notifier, configErr)
+	}
+
+	tlsFiles := []struct{ name, filename string }{
+		{"tls.cert_file", c.TLS.Cert_file},
+		{"tls.key_file", c.TLS.Key_file},
+		{"tls.client_ca_file", c.TLS.Client_ca_file},
+		{"tls.ca_file", c.TLS.Ca_file},
+	}
+	for _, file := range tlsFiles {
+		if len(file.filename) == 0 {
+			continue
+		}
+		if _, err := os.Stat(file.filename); err != nil {
+			configErr.add("%s: %v", file.name, err)
+		}
+	}
+}
+
+//validateNotifier adds the problems of one notifier to configErr
+func (c *GossipConfig) validateNotifier(name string, n NotifierConfig, configErr *ConfigError){
+	switch n.Type {
+	case NotifierWebhook:
+		if u, err := url.Parse(n.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			configErr.add("%s.url %q must be an absolute http or https URL", name, n.URL)
+		}
+	case NotifierCommand:
+		if len(n.Command) == 0 || len(n.Command[0]) == 0 {
+			configErr.add("%s.command is required", name)
+		}
+	case NotifierJSONL:
+		if len(n.Path) == 0 {
+			configErr.add("%s.path is required", name)
+		}
+	default:
+		configErr.add("%s.type %q must be webhook, command or jsonl", name, n.Type)
+	}
+	for _, event := range n.Events {
+		if event != EventPoMCreated && event != EventPoMReceived && event != EventAlertReceived && event != EventSCTNotIncluded && event != EventInconsistentProof {
+			configErr.add("%s.events: unknown event %q", name, event)
+		}
+	}
+	if n.Max_retries < 0 || n.Timeout_ms < 0 {
+		configErr.add("%s: max_retries and timeout_ms must not be negative", name)
+	}
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//applyEnv sets every field of v that has an environment variable, reporting values that cannot be parsed
+func applyEnv(v reflect.Value, prefix string, configErr *ConfigError){
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if len(tag) == 0 || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			applyEnv(field, name, configErr)
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if field.Kind() == reflect.Ptr { //optional setting, set from the environment like the value it points to
+			if field.IsNil() {
+				field.Set(reflect.New(field.Type().Elem()))
+			}
+			field = field.Elem()
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				configErr.add("%s: %v", name, err)
+				continue
+			}
+			field.SetInt(n)
+		case reflect.Float64:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				configErr.add("%s: %v", name, err)
+				continue
+			}
+			field.SetFloat(f)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				configErr.add("%s: %v", name, err)
+				continue
+			}
+			field.SetBool(b)
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.String {
+				configErr.add("%s: lists of objects cannot be set from the environment", name)
+				continue
+			}
+			var items []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); len(item) != 0 {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+		case reflect.Map:
+			configErr.add("%s: maps cannot be set from the environment", name)
+		}
+	}
+}
+
+//Milliseconds converts a millisecond setting to a duration
+func Milliseconds(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
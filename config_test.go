@@ -0,0 +1,107 @@
+package CTObject
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+  filename := filepath.Join(t.TempDir(), "config.json")
+  if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+    t.Fatal(err)
+  }
+  return filename
+}
+
+func TestNewGossipConfigDefaults(t *testing.T){
+  config, err := NewGossipConfig("testing/config/config1.json")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if config.Monitor_id != "monitor1" || len(config.Monitors_ids) != 1 {
+    t.Errorf("Unexpected ids %v %v", config.Monitor_id, config.Monitors_ids)
+  }
+  if config.Timeouts.Peer_request_ms != 10000 || config.Blob_threshold != Threshold || config.Queues.Peer_queue_size != 1000 {
+    t.Errorf("Defaults not applied: %+v", config)
+  }
+}
+
+func TestNewGossipConfigEnv(t *testing.T){
+  env := map[string]string{
+    "GOSSIPER_LISTEN_ADDRESS": "127.0.0.1:9000",
+    "GOSSIPER_TIMEOUTS_PEER_REQUEST_MS": "500",
+    "GOSSIPER_MONITOR_IDS": "monitor2, monitor3",
+    "GOSSIPER_PEER_SCORING_FORWARD_THRESHOLD": "0",
+  }
+  for name, value := range env {
+    os.Setenv(name, value)
+    defer os.Unsetenv(name)
+  }
+  config, err := NewGossipConfig("testing/config/config1.json")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if config.Listen_address != "127.0.0.1:9000" || config.Timeouts.Peer_request_ms != 500 || len(config.Monitors_ids) != 2 ||
+    config.Peer_scoring.Forward_threshold == nil || *config.Peer_scoring.Forward_threshold != 0 {
+    t.Errorf("Environment overrides not applied: %+v", config)
+  }
+}
+
+func TestNewGossipConfigEnvInvalid(t *testing.T){
+  env := map[string]string{
+    "GOSSIPER_TIMEOUTS_PEER_REQUEST_MS": "soon",
+    "GOSSIPER_NOTIFIERS": "webhook",
+    "GOSSIPER_FETCHER_LOG_INTERVALS_MS": "log=1000",
+  }
+  for name, value := range env {
+    os.Setenv(name, value)
+    defer os.Unsetenv(name)
+  }
+  _, err := NewGossipConfig("testing/config/config1.json")
+  if err == nil {
+    t.Fatal("Expected invalid environment overrides to be rejected")
+  }
+  for name := range env {
+    if !strings.Contains(err.Error(), name) {
+      t.Errorf("Expected %v to be reported, got %v", name, err)
+    }
+  }
+}
+
+func TestNewGossipConfigInvalid(t *testing.T){
+  testTables := []struct {
+    config string
+    problems []string
+  }{
+    {`not json`, []string{"failed to parse"}},
+    {`{"monitor_id": "monitor1", "unknown": 1}`, []string{"unknown field"}},
+    {`{"monitor_ids": ["monitor1", "monitor2", "monitor2"], "monitor_id": "monitor1"}`, []string{"this gossiper's monitor_id", "more than once"}},
+    {`{"listen_address": "9000", "advertised_url": "example.com"}`, []string{"monitor_id is required", "listen_address", "advertised_url"}},
+    {`{"monitor_id": "monitor1", "unix_socket": "/nonexistent/gossiper.sock"}`, []string{"unix_socket"}},
+    {`{"monitor_id": "monitor1", "audit": {"path": "/nonexistent/audit.jsonl", "max_files": -1}}`, []string{"audit.path", "audit.max_files", "audit.hmac_key is required"}},
+    {`{"monitor_id": "monitor1", "timeouts": {"read_ms": -1}, "tls": {"cert_file": "missing.pem"}}`, []string{"timeouts.read_ms", "set together", "tls.cert_file"}},
+    {`{"monitor_id": "monitor1", "auditor": {"enabled": true, "interval_ms": -1}}`, []string{"auditor.interval_ms"}},
+    {`{"monitor_id": "monitor1", "fetcher": {"max_backoff_ms": -1, "log_intervals_ms": {"log1": -5}}}`, []string{"fetcher.log_intervals_ms.log1", "fetcher.max_backoff_ms"}},
+    {`{"monitor_id": "monitor1", "pollination": {"enabled": true, "max_sths": -1}}`, []string{"pollination.max_sths"}},
+    {`{"monitor_id": "monitor1", "sct_feedback": {"max_scts": -1, "audit_interval_ms": -1}}`, []string{"sct_feedback.max_scts", "sct_feedback.audit_interval_ms"}},
+    {`{"monitor_id": "monitor1", "sct_feedback": {"enabled": true}}`, []string{"sct_feedback.priv_key is required"}},
+    {`{"monitor_id": "monitor1", "witness": {"enabled": true}}`, []string{"witness.priv_key is required", "witness.state_path is required"}},
+    {`{"monitor_id": "monitor1", "witness": {"state_path": "/nonexistent/witness.json", "queue_size": -1}}`, []string{"witness.state_path", "witness.queue_size"}},
+    {`{"monitor_id": "monitor1", "witness": {"enabled": true, "priv_key": "bm90IGEga2V5"}}`, []string{"witness.priv_key"}},
+  }
+  for _, testTable := range testTables{
+    _, err := NewGossipConfig(writeConfig(t, testTable.config))
+    if err == nil {
+      t.Errorf("Expected %s to be rejected", testTable.config)
+      continue
+    }
+    for _, problem := range testTable.problems {
+      if !strings.Contains(err.Error(), problem) {
+        t.Errorf("Error for %s does not mention %q: %v", testTable.config, problem, err)
+      }
+    }
+  }
+}
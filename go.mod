@@ -1,10 +1,28 @@
 module github.com/n-ct/ct-gossiper
 
-go 1.15
+go 1.20
 
 require (
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
 	github.com/google/certificate-transparency-go v1.1.1
 	github.com/n-ct/ct-certificate-authority v0.0.0-20210408003514-086e14235d37
 	github.com/n-ct/ct-monitor v0.0.0-20210407172231-18516bf4180d
+	github.com/prometheus/client_golang v1.7.1
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
+)
+
+require (
+	github.com/Workiva/go-datastructures v1.0.53 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.1 // indirect
+	github.com/golang/protobuf v1.4.2 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.10.0 // indirect
+	github.com/prometheus/procfs v0.1.3 // indirect
+	github.com/ulikunitz/xz v0.5.10 // indirect
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9 // indirect
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974 // indirect
+	golang.org/x/sys v0.0.0-20200930185726-fdedc70b468f // indirect
+	google.golang.org/protobuf v1.25.0 // indirect
 )
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+)
+
+//defaultPorts are used when a URL does not name a port
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+//hostPort returns the host:port of an absolute http or https URL, using the default port of the scheme if none is given
+func hostPort(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	port, ok := defaultPorts[u.Scheme]
+	if !ok || len(u.Hostname()) == 0 {
+		return "", fmt.Errorf("%q is not an absolute http or https URL", rawurl)
+	}
+	if len(u.Port()) != 0 {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+//sameURL reports whether two gossiper URLs point at the same server, ignoring case, default ports and trailing slashes
+func sameURL(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil || len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	hostA, errA := hostPort(a)
+	hostB, errB := hostPort(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(hostA, hostB) &&
+		strings.TrimSuffix(ua.Path, "/") == strings.TrimSuffix(ub.Path, "/")
+}
+
+//listenAddressFor returns the address the server binds, listen_address or every interface on the port of the advertised URL
+func listenAddressFor(config *cto.GossipConfig, advertised string) (string, error) {
+	if len(config.Listen_address) != 0 {
+		return config.Listen_address, nil
+	}
+	address, err := hostPort(advertised)
+	if err != nil {
+		return "", fmt.Errorf("unable to derive the listen address from %q, set listen_address: %v", advertised, err)
+	}
+	_, port, _ := net.SplitHostPort(address)
+	return net.JoinHostPort("", port), nil
+}
+
+//listenUnix listens on the unix socket at path, removing a socket left behind by an earlier run
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%v exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	glog.Infof("Listening on unix socket %v\n", path)
+	return listener, nil
+}
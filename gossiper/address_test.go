@@ -0,0 +1,104 @@
+package main
+
+import (
+  "context"
+  "io/ioutil"
+  "net"
+  "net/http"
+  "path/filepath"
+  "testing"
+
+  cto "github.com/n-ct/ct-gossiper"
+)
+
+func TestHostPort(t *testing.T){
+  testTables := []struct {
+    url string
+    expected string
+    valid bool
+  }{
+    {"http://localhost:4500", "localhost:4500", true},
+    {"https://gossip.example.com", "gossip.example.com:443", true},
+    {"http://gossip.example.com/ct", "gossip.example.com:80", true},
+    {"http://[2001:db8::1]:8080", "[2001:db8::1]:8080", true},
+    {"https://[::1]", "[::1]:443", true},
+    {"localhost:4500", "", false},
+    {"ftp://example.com", "", false},
+  }
+  for _, testTable := range testTables{
+    address, err := hostPort(testTable.url)
+    if (err == nil) != testTable.valid || address != testTable.expected {
+      t.Errorf("hostPort(%q) = %q, %v, want %q", testTable.url, address, err, testTable.expected)
+    }
+  }
+}
+
+func TestSameURL(t *testing.T){
+  testTables := []struct {
+    a string
+    b string
+    expected bool
+  }{
+    {"http://localhost:4500", "http://localhost:4500", true},
+    {"http://localhost:4500/", "HTTP://LOCALHOST:4500", true},
+    {"https://example.com", "https://example.com:443", true},
+    {"http://example.com", "https://example.com", false},
+    {"http://localhost:4500", "http://localhost:5500", false},
+    {"", "http://localhost:4500", false},
+  }
+  for _, testTable := range testTables{
+    if result := sameURL(testTable.a, testTable.b); result != testTable.expected {
+      t.Errorf("sameURL(%q, %q) = %v, want %v", testTable.a, testTable.b, result, testTable.expected)
+    }
+  }
+}
+
+func TestListenAddressFor(t *testing.T){
+  testTables := []struct {
+    listen string
+    advertised string
+    expected string
+  }{
+    {"", "http://localhost:4500", ":4500"},
+    {"", "https://gossip.example.com", ":443"},
+    {"", "http://[2001:db8::1]:8080", ":8080"},
+    {"[::1]:9000", "https://gossip.example.com", "[::1]:9000"},
+  }
+  for _, testTable := range testTables{
+    address, err := listenAddressFor(&cto.GossipConfig{Listen_address: testTable.listen}, testTable.advertised)
+    if err != nil || address != testTable.expected {
+      t.Errorf("listenAddressFor(%q, %q) = %q, %v, want %q", testTable.listen, testTable.advertised, address, err, testTable.expected)
+    }
+  }
+  if _, err := listenAddressFor(&cto.GossipConfig{}, "gossiper"); err == nil {
+    t.Errorf("Expected an error for an advertised URL without scheme")
+  }
+}
+
+func TestListenUnix(t *testing.T){
+  path := filepath.Join(t.TempDir(), "gossiper.sock")
+  for i := 0; i < 2; i++ { //the second listen replaces the socket left by the first
+    listener, err := listenUnix(path)
+    if err != nil {
+      t.Fatal(err)
+    }
+    server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request){
+      w.Write([]byte("ok"))
+    })}
+    go server.Serve(listener)
+
+    client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+      return (&net.Dialer{}).DialContext(ctx, "unix", path)
+    }}}
+    resp, err := client.Get("http://gossiper" + cto.GossipPath)
+    if err != nil {
+      t.Fatal(err)
+    }
+    body, _ := ioutil.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(body) != "ok" {
+      t.Errorf("Unexpected response over unix socket: %q", body)
+    }
+    server.Close()
+  }
+}
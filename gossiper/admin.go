@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	mtrList "github.com/n-ct/ct-monitor/entitylist"
+)
+
+var pausedPeers = make(map[string]bool); //[MonitorID] peers we stopped gossiping to
+var pausedLock sync.RWMutex;
+var adminAdded = make(map[string]bool); //[MonitorID] peers added through the admin API, guarded by peersLock
+var adminRemoved = make(map[string]bool); //[MonitorID] peers removed through the admin API, guarded by peersLock
+
+// AdminPeer is the admin view of a peer.
+type AdminPeer struct{
+	MonitorID string `json:"monitor_id"`
+	MonitorURL string `json:"monitor_url"`
+	GossiperURL string `json:"gossiper_url"`
+	Paused bool `json:"paused"`
+	Score int `json:"score"`
+}
+
+// AdminPeerRequest is the body of the requests that change a peer.
+type AdminPeerRequest struct{
+	MonitorID string `json:"monitor_id"`
+}
+
+// AdminAuth only lets requests through if they carry the admin token.
+// The admin API is disabled when no token is configured.
+func AdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request){
+		var token string
+		if config := getConfig(); config != nil {
+			token = config.Admin.Token
+		}
+		if len(token) == 0 {
+			http.Error(w, "admin API disabled", http.StatusNotFound)
+			return
+		}
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			glog.Infof("Unauthorized admin request from %v\n", req.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, req)
+	}
+}
+
+//isPaused returns true if gossip to the peer has been paused
+func isPaused(monitorID string) bool {
+	pausedLock.RLock()
+	defer pausedLock.RUnlock()
+	return pausedPeers[monitorID]
+}
+
+//setPaused pauses or resumes gossip to the peer
+func setPaused(monitorID string, paused bool){
+	pausedLock.Lock()
+	defer pausedLock.Unlock()
+	if paused {
+		pausedPeers[monitorID] = true
+	} else {
+		delete(pausedPeers, monitorID)
+	}
+}
+
+// AddPeer starts gossiping with a monitor from the monitor list. The peer is kept when the
+// configuration is reloaded, and with membership it can join again if it was removed.
+func AddPeer(monitorID string) error {
+	if config := getConfig(); config != nil && monitorID == config.Monitor_id {
+		return fmt.Errorf("%v is this gossiper", monitorID)
+	}
+	info := trustedMonitor(monitorID)
+	if info == nil {
+		return fmt.Errorf("%v is not in the monitor list", monitorID)
+	}
+	if membership != nil {
+		return membership.Restore(monitorID)
+	}
+	peersLock.Lock()
+	defer peersLock.Unlock()
+	adminAdded[monitorID] = true
+	delete(adminRemoved, monitorID)
+	for _, peer := range peers {
+		if peer.MonitorID == monitorID {
+			return nil
+		}
+	}
+	peers = append(peers, info)
+	return nil
+}
+
+// RemovePeer stops gossiping with a peer until it is added again through the admin API.
+// Reloading the configuration does not bring it back, and with membership neither do its
+// join and ping messages.
+func RemovePeer(monitorID string) error {
+	if membership != nil {
+		return membership.Remove(monitorID)
+	}
+	peersLock.Lock()
+	defer peersLock.Unlock()
+	for i, peer := range peers {
+		if peer.MonitorID == monitorID {
+			peers = append(peers[:i:i], peers[i+1:]...)
+			adminRemoved[monitorID] = true
+			delete(adminAdded, monitorID)
+			return nil
+		}
+	}
+	return fmt.Errorf("%v is not a peer", monitorID)
+}
+
+//ReloadLogList reads the log list again and replaces the current one, waiting for any other reload to finish
+func ReloadLogList(filename string) (*mtrList.LogList, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	logs, err := mtrList.NewLogList(filename)
+	if err != nil {
+		return nil, err
+	}
+	setLogs(logs)
+	glog.Infof("Reloaded log list %v: %v logs\n", filename, countLogs(logs))
+	RevalidateQuarantine()
+	return logs, nil
+}
+
+// ReloadMonitorList reads the monitor list again and replaces the current one.
+// Peers that are no longer in the list are dropped, the others get their new URLs.
+// Like Reload it holds reloadLock, so the two never swap lists at the same time.
+func ReloadMonitorList(filename string) (*mtrList.MonitorList, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	monitors, err := mtrList.NewMonitorList(filename)
+	if err != nil {
+		return nil, err
+	}
+	if config := getConfig(); config != nil && monitors.FindMonitorByMonitorID(config.Monitor_id) == nil {
+		return nil, fmt.Errorf("monitor %v of this gossiper is not in %v", config.Monitor_id, filename)
+	}
+	setMonitors(monitors)
+
+	peersLock.Lock()
+	var newPeers []*mtrList.MonitorInfo
+	for _, peer := range peers {
+		if info := monitors.FindMonitorByMonitorID(peer.MonitorID); info != nil {
+			newPeers = append(newPeers, info)
+		} else {
+			glog.Infof("Peer %v removed from the monitor list\n", peer.MonitorID)
+		}
+	}
+	peers = newPeers
+	peersLock.Unlock()
+	if membership != nil {
+		membership.updatePeers()
+	}
+
+	glog.Infof("Reloaded monitor list %v: %v monitors\n", filename, countMonitors(monitors))
+	RevalidateQuarantine()
+	return monitors, nil
+}
+
+func countLogs(logs *mtrList.LogList) int {
+	count := 0
+	for _, op := range logs.Operators {
+		count += len(op.Logs)
+	}
+	return count
+}
+
+func countMonitors(monitors *mtrList.MonitorList) int {
+	count := 0
+	for _, op := range monitors.MonitorOperators {
+		count += len(op.Monitors)
+	}
+	return count
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}){
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+//AdminPeersHandler lists peers on GET, adds a peer on POST and removes one on DELETE
+func AdminPeersHandler(w http.ResponseWriter, req *http.Request){
+	switch req.Method {
+	case http.MethodGet:
+		peerScores := scores.Snapshot()
+		var adminPeers []AdminPeer
+		for _, peer := range getPeers() {
+			adminPeers = append(adminPeers, AdminPeer{
+				MonitorID: peer.MonitorID,
+				MonitorURL: peer.MonitorURL,
+				GossiperURL: peer.GossiperURL,
+				Paused: isPaused(peer.MonitorID),
+				Score: peerScores[peer.MonitorID].Score,
+			})
+		}
+		writeJSON(w, adminPeers)
+
+	case http.MethodPost, http.MethodDelete:
+		var peerReq AdminPeerRequest
+		if err := json.NewDecoder(req.Body).Decode(&peerReq); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		if req.Method == http.MethodPost {
+			err = AddPeer(peerReq.MonitorID)
+		} else {
+			err = RemovePeer(peerReq.MonitorID)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		glog.Infof("Admin %v peer %v\n", req.Method, peerReq.MonitorID)
+		writeJSON(w, peerReq)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+//pauseHandler returns a handler that pauses or resumes gossip to the peer in the request
+func pauseHandler(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request){
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var peerReq AdminPeerRequest
+		if err := json.NewDecoder(req.Body).Decode(&peerReq); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if trustedMonitor(peerReq.MonitorID) == nil {
+			http.Error(w, "unknown monitor", http.StatusBadRequest)
+			return
+		}
+		setPaused(peerReq.MonitorID, paused)
+		glog.Infof("Admin set paused=%v for peer %v\n", paused, peerReq.MonitorID)
+		writeJSON(w, peerReq)
+	}
+}
+
+//AdminPausePeerHandler stops gossip to a peer until it is resumed
+var AdminPausePeerHandler = pauseHandler(true)
+
+//AdminResumePeerHandler resumes gossip to a paused peer
+var AdminResumePeerHandler = pauseHandler(false)
+
+//AdminReloadLogsHandler reloads the log list from the file given at startup
+func AdminReloadLogsHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	logs, err := ReloadLogList(logsFile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]int{"logs": countLogs(logs)})
+}
+
+//AdminReloadMonitorsHandler reloads the monitor list from the file given at startup
+func AdminReloadMonitorsHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	monitors, err := ReloadMonitorList(monitorsFile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]int{"monitors": countMonitors(monitors), "peers": len(getPeers())})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+  "bytes"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "path/filepath"
+  "testing"
+  "time"
+
+  cto "github.com/n-ct/ct-gossiper"
+)
+
+func adminRequest(handler http.HandlerFunc, method string, path string, body string, token string) *httptest.ResponseRecorder {
+  recorder := httptest.NewRecorder()
+  req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+  if len(token) != 0 {
+    req.Header.Set("Authorization", "Bearer " + token)
+  }
+  AdminAuth(handler).ServeHTTP(recorder, req)
+  return recorder
+}
+
+func TestAdminAuth(t *testing.T){
+  mustGossiperSetup(t)
+
+  gossipConfig.Admin.Token = ""
+  if code := adminRequest(AdminPeersHandler, "GET", cto.AdminPeersPath, "", "secret").Code; code != http.StatusNotFound {
+    t.Errorf("Admin API without token returned %v want %v", code, http.StatusNotFound)
+  }
+
+  gossipConfig.Admin.Token = "secret"
+  testTables := []struct {
+    token string
+    expected int
+  }{
+    {"", http.StatusUnauthorized},
+    {"wrong", http.StatusUnauthorized},
+    {"secret", http.StatusOK},
+  }
+  for _, testTable := range testTables{
+    if code := adminRequest(AdminPeersHandler, "GET", cto.AdminPeersPath, "", testTable.token).Code; code != testTable.expected {
+      t.Errorf("Admin request with token %q returned %v want %v", testTable.token, code, testTable.expected)
+    }
+  }
+}
+
+func TestAdminPeers(t *testing.T){
+  mustGossiperSetup(t)
+  gossipConfig.Admin.Token = "secret"
+  defer setPaused("monitor2", false)
+
+  testTables := []struct {
+    handler http.HandlerFunc
+    method string
+    body string
+    code int
+    peers int
+    paused bool
+  }{
+    {AdminPeersHandler, "DELETE", `{"monitor_id":"monitor2"}`, http.StatusOK, 0, false},
+    {AdminPeersHandler, "DELETE", `{"monitor_id":"monitor2"}`, http.StatusBadRequest, 0, false},
+    {AdminPeersHandler, "POST", `{"monitor_id":"unknown"}`, http.StatusBadRequest, 0, false},
+    {AdminPeersHandler, "POST", `{"monitor_id":"monitor1"}`, http.StatusBadRequest, 0, false},
+    {AdminPeersHandler, "POST", `{"monitor_id":"monitor2"}`, http.StatusOK, 1, false},
+    {AdminPeersHandler, "POST", `{"monitor_id":"monitor2"}`, http.StatusOK, 1, false},
+    {AdminPausePeerHandler, "POST", `{"monitor_id":"monitor2"}`, http.StatusOK, 1, true},
+    {AdminResumePeerHandler, "POST", `{"monitor_id":"monitor2"}`, http.StatusOK, 1, false},
+    {AdminReloadMonitorsHandler, "POST", "", http.StatusOK, 1, false},
+    {AdminReloadLogsHandler, "POST", "", http.StatusOK, 1, false},
+  }
+
+  for i, testTable := range testTables{
+    recorder := adminRequest(testTable.handler, testTable.method, cto.AdminPeersPath, testTable.body, "secret")
+    if recorder.Code != testTable.code {
+      t.Errorf("Request %d returned %v want %v: %s", i, recorder.Code, testTable.code, recorder.Body.String())
+    }
+    if len(getPeers()) != testTable.peers {
+      t.Errorf("Request %d left peers %v want %d peers", i, peerIDs(), testTable.peers)
+    }
+    if isPaused("monitor2") != testTable.paused {
+      t.Errorf("Request %d left monitor2 paused=%v", i, !testTable.paused)
+    }
+  }
+}
+
+func TestAdminPeersSurviveReload(t *testing.T){
+  noPeers := filepath.Join(t.TempDir(), "config.json")
+  if err := ioutil.WriteFile(noPeers, []byte(`{"monitor_ids": [], "monitor_id": "monitor1"}`), 0644); err != nil {
+    t.Fatal(err)
+  }
+
+  testTables := []struct {
+    name string
+    config string
+    change func(string) error
+    peers int
+  }{
+    {"added peer", noPeers, AddPeer, 1},
+    {"removed peer", configFilename, RemovePeer, 0},
+  }
+  for _, testTable := range testTables{
+    messages = nil
+    if err := GossiperSetup(testTable.config, monitorFilename, logFilename); err != nil {
+      t.Fatal(err)
+    }
+    if err := testTable.change("monitor2"); err != nil {
+      t.Fatalf("%s: %v", testTable.name, err)
+    }
+    if err := Reload(); err != nil {
+      t.Fatal(err)
+    }
+    if len(getPeers()) != testTable.peers {
+      t.Errorf("%s: reload left peers %v want %d peers", testTable.name, peerIDs(), testTable.peers)
+    }
+  }
+}
+
+func TestAdminReloadWaitsForReload(t *testing.T){
+  mustGossiperSetup(t)
+  testTables := []struct {
+    name string
+    reload func() error
+  }{
+    {"log list", func() error { _, err := ReloadLogList(logFilename); return err }},
+    {"monitor list", func() error { _, err := ReloadMonitorList(monitorFilename); return err }},
+  }
+  for _, testTable := range testTables{
+    reloadLock.Lock()
+    done := make(chan error, 1)
+    go func(){ done <- testTable.reload() }()
+    select {
+    case <-done:
+      t.Errorf("%s: reloaded while another reload was running", testTable.name)
+    case <-time.After(50 * time.Millisecond):
+    }
+    reloadLock.Unlock()
+    if err := <-done; err != nil {
+      t.Errorf("%s: %v", testTable.name, err)
+    }
+  }
+}
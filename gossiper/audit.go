@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+)
+
+const OutcomeRejected = "rejected" //refused by admission control, recorded in the audit log only
+
+// AuditRecord is one line of the audit log. Each record carries the hash of the previous one,
+// and its own hash is an HMAC under the configured key of the record and that previous hash,
+// so editing, removing or reordering lines breaks the chain and only the key holder can rebuild it.
+type AuditRecord struct{
+	Time time.Time `json:"time"`
+	Sender string `json:"sender"` //peer monitor ID, or source IP when the sender is not a peer
+	RemoteAddr string `json:"remote_addr"`
+	RequesterAddress string `json:"requester_address,omitempty"`
+	TypeID string `json:"type_id,omitempty"`
+	Identifier string `json:"identifier,omitempty"`
+	Digest []byte `json:"digest,omitempty"` //digest claimed by the object
+	RequestSHA256 string `json:"request_sha256,omitempty"` //hash of the request body as received
+	Outcome string `json:"outcome"`
+	Error string `json:"error,omitempty"` //why the object was rejected or quarantined
+	Destinations []string `json:"destinations,omitempty"` //queues the object or the resulting PoM was sent to
+	Prev string `json:"prev"`
+	Hash string `json:"hash,omitempty"`
+}
+
+//observe counts the outcome in the metrics and records it
+func (r *AuditRecord) observe(typeID string, outcome string){
+	observeReceived(typeID, outcome)
+	r.Outcome = outcome
+}
+
+// AuditLog appends records to a JSON-lines file, rotating it to path.1, path.2, ...
+// once it grows past the configured size. The chain continues across rotated files.
+type AuditLog struct{
+	mu sync.Mutex
+	config cto.AuditConfig
+	file *os.File
+	size int64
+	last string //hash of the last record written
+}
+
+var auditLog *AuditLog;
+var auditLock sync.RWMutex; //guards auditLog
+
+//chainHash returns the HMAC of a record under key, body being the record encoded without its hash
+func chainHash(key string, prev string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(prev))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//rotatedPath returns the name of the nth rotated file, path itself for 0
+func rotatedPath(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+//lastHash returns the hash of the last record in the file, empty if it has none
+func lastHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var last []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) != 0 {
+			last = append(last[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if last == nil {
+		return "", nil
+	}
+	var record AuditRecord
+	if err := json.Unmarshal(last, &record); err != nil {
+		return "", fmt.Errorf("%v: last record: %v", path, err)
+	}
+	return record.Hash, nil
+}
+
+//OpenAuditLog opens the audit file for appending, continuing the chain of the existing records
+func OpenAuditLog(config cto.AuditConfig) (*AuditLog, error) {
+	a := &AuditLog{config: config}
+	for n := 0; n <= config.Max_files; n++ {
+		last, err := lastHash(rotatedPath(config.Path, n))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(last) != 0 {
+			a.last = last
+			break
+		}
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AuditLog) open() error {
+	f, err := os.OpenFile(a.config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file, a.size = f, info.Size()
+	return nil
+}
+
+//rotate shifts the rotated files up by one, dropping the oldest, and starts a new file
+func (a *AuditLog) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(rotatedPath(a.config.Path, a.config.Max_files))
+	for n := a.config.Max_files - 1; n >= 0; n-- {
+		if err := os.Rename(rotatedPath(a.config.Path, n), rotatedPath(a.config.Path, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return a.open()
+}
+
+//Append chains record to the previous one and writes it
+func (a *AuditLog) Append(record *AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	record.Prev, record.Hash = a.last, ""
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	record.Hash = chainHash(a.config.Hmac_key, record.Prev, body)
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	maxSize := int64(a.config.Max_size_mb) * 1024 * 1024
+	if a.size != 0 && a.size+int64(len(line)) > maxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		return err
+	}
+	a.last = record.Hash
+	return nil
+}
+
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+//SetAuditLog opens the audit log described by config, closing the current one. An empty path disables it
+func SetAuditLog(config cto.AuditConfig) error {
+	auditLock.Lock()
+	defer auditLock.Unlock()
+	if auditLog != nil && auditLog.config == config {
+		return nil
+	}
+	var opened *AuditLog
+	if len(config.Path) != 0 {
+		var err error
+		if opened, err = OpenAuditLog(config); err != nil {
+			return err
+		}
+	}
+	if auditLog != nil {
+		auditLog.Close()
+	}
+	auditLog = opened
+	return nil
+}
+
+//CloseAuditLog stops recording, used on shutdown
+func CloseAuditLog(){
+	auditLock.Lock()
+	defer auditLock.Unlock()
+	if auditLog != nil {
+		auditLog.Close()
+		auditLog = nil
+	}
+}
+
+//writeAudit appends record to the audit log if one is configured
+func writeAudit(record *AuditRecord){
+	auditLock.RLock()
+	defer auditLock.RUnlock()
+	if auditLog == nil {
+		return
+	}
+	if err := auditLog.Append(record); err != nil {
+		glog.Errorf("Unable to write audit record: %v\n", err)
+	}
+}
+
+//newAuditRecord starts the record of a gossip request
+func newAuditRecord(req *http.Request) *AuditRecord {
+	return &AuditRecord{Time: time.Now().UTC(), Sender: requestSender(req), RemoteAddr: req.RemoteAddr, RequesterAddress: req.Header.Get("requesterAddress")}
+}
+
+//auditFiles returns the audit file and the rotated files that exist, oldest first
+func auditFiles(path string) []string {
+	var files []string
+	for n := 1; ; n++ {
+		if _, err := os.Stat(rotatedPath(path, n)); err != nil {
+			break
+		}
+		files = append([]string{rotatedPath(path, n)}, files...)
+	}
+	return append(files, path)
+}
+
+// VerifyAuditLog checks the hash chain of the audit file at path and its rotated files with
+// the HMAC key the log was written with, returning the number of records checked. The first
+// record kept may follow records that were rotated away, its previous hash is taken as given.
+func VerifyAuditLog(path string, key string) (int, error) {
+	var prev string
+	count := 0
+	for _, filename := range auditFiles(path) {
+		f, err := os.Open(filename)
+		if err != nil {
+			return count, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var record AuditRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				f.Close()
+				return count, fmt.Errorf("%v:%d: %v", filename, line, err)
+			}
+			if count != 0 && record.Prev != prev {
+				f.Close()
+				return count, fmt.Errorf("%v:%d: chain broken, previous hash is %v, expected %v", filename, line, record.Prev, prev)
+			}
+			hash := record.Hash
+			record.Hash = ""
+			body, _ := json.Marshal(&record)
+			if !hmac.Equal([]byte(chainHash(key, record.Prev, body)), []byte(hash)) {
+				f.Close()
+				return count, fmt.Errorf("%v:%d: record does not match its hash", filename, line)
+			}
+			prev = hash
+			count++
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return count, fmt.Errorf("%v: %v", filename, err)
+		}
+	}
+	return count, nil
+}
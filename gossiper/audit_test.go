@@ -0,0 +1,148 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "io/ioutil"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+
+  cto "github.com/n-ct/ct-gossiper"
+)
+
+func readAuditRecords(t *testing.T, path string) []AuditRecord {
+  content, err := ioutil.ReadFile(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var records []AuditRecord
+  for _, line := range bytes.Split(bytes.TrimSpace(content), []byte("\n")) {
+    var record AuditRecord
+    if err := json.Unmarshal(line, &record); err != nil {
+      t.Fatal(err)
+    }
+    records = append(records, record)
+  }
+  return records
+}
+
+const testAuditKey = "audit key"
+
+func TestGossipHandlerAudit(t *testing.T){
+  mustGossiperSetup(t)
+  path := filepath.Join(t.TempDir(), "audit.jsonl")
+  if err := SetAuditLog(cto.AuditConfig{Path: path, Hmac_key: testAuditKey, Max_size_mb: 1, Max_files: 2}); err != nil {
+    t.Fatal(err)
+  }
+  defer CloseAuditLog()
+
+  jsonStr, _ := json.Marshal(sthCTObject)
+  for _, body := range [][]byte{jsonStr, jsonStr, []byte("not json")} {
+    GossipHandler(httptest.NewRecorder(), httptest.NewRequest("POST", GossipPath, bytes.NewBuffer(body)))
+  }
+  records := readAuditRecords(t, path)
+  if len(records) != 3 {
+    t.Fatalf("Expected 3 audit records, got %v", len(records))
+  }
+  for i, record := range records[:2] {
+    if record.Identifier != cto.IdentifierToString(sthCTObject.Identifier()) || !bytes.Equal(record.Digest, sthDigest) || len(record.RequestSHA256) == 0 || len(record.Error) != 0 {
+      t.Errorf("Record %v: unexpected %+v", i, record)
+    }
+  }
+  if records[0].Outcome != OutcomeNew || records[1].Outcome != OutcomeDuplicate || len(records[1].Destinations) != 0 {
+    t.Errorf("Unexpected outcomes %+v", records[:2])
+  }
+  if records[2].Outcome != OutcomeInvalid || len(records[2].Error) == 0 {
+    t.Errorf("Expected the malformed request to be invalid with an error: %+v", records[2])
+  }
+  if records[1].Prev != records[0].Hash || records[2].Prev != records[1].Hash {
+    t.Errorf("Records are not chained: %+v", records)
+  }
+}
+
+func TestVerifyAuditLog(t *testing.T){
+  testTables := []struct {
+    name string
+    tamper func(lines []string) []string
+    key string
+    valid bool
+  }{
+    {"untouched", func(lines []string) []string { return lines }, testAuditKey, true},
+    {"edited", func(lines []string) []string {
+      lines[1] = strings.Replace(lines[1], OutcomeNew, OutcomeDuplicate, 1)
+      return lines
+    }, testAuditKey, false},
+    {"removed", func(lines []string) []string { return append(lines[:1], lines[2:]...) }, testAuditKey, false},
+    {"reordered", func(lines []string) []string {
+      lines[1], lines[2] = lines[2], lines[1]
+      return lines
+    }, testAuditKey, false},
+    {"oldest dropped", func(lines []string) []string { return lines[1:] }, testAuditKey, true},
+    {"rehashed without the key", func(lines []string) []string {
+      var record AuditRecord
+      json.Unmarshal([]byte(lines[2]), &record)
+      record.Outcome, record.Hash = OutcomeDuplicate, ""
+      body, _ := json.Marshal(&record)
+      record.Hash = chainHash("", record.Prev, body)
+      line, _ := json.Marshal(&record)
+      lines[2] = string(line)
+      return lines
+    }, testAuditKey, false},
+    {"wrong key", func(lines []string) []string { return lines }, "another key", false},
+  }
+  for _, testTable := range testTables{
+    path := filepath.Join(t.TempDir(), "audit.jsonl")
+    a, err := OpenAuditLog(cto.AuditConfig{Path: path, Hmac_key: testAuditKey, Max_size_mb: 1, Max_files: 2})
+    if err != nil {
+      t.Fatal(err)
+    }
+    for i := 0; i < 3; i++ {
+      a.Append(&AuditRecord{Time: time.Now().UTC(), Sender: "monitor2", Outcome: OutcomeNew})
+    }
+    a.Close()
+
+    content, _ := ioutil.ReadFile(path)
+    lines := testTable.tamper(strings.Split(strings.TrimSpace(string(content)), "\n"))
+    ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+    if _, err := VerifyAuditLog(path, testTable.key); (err == nil) != testTable.valid {
+      t.Errorf("%v: VerifyAuditLog returned %v", testTable.name, err)
+    }
+  }
+}
+
+func TestAuditLogRotation(t *testing.T){
+  path := filepath.Join(t.TempDir(), "audit.jsonl")
+  config := cto.AuditConfig{Path: path, Hmac_key: testAuditKey, Max_size_mb: 1, Max_files: 1}
+  a, err := OpenAuditLog(config)
+  if err != nil {
+    t.Fatal(err)
+  }
+  record := &AuditRecord{Sender: "monitor2", Outcome: OutcomeNew, Error: strings.Repeat("x", 4096)}
+  written := 0
+  for ; written < 300; written++ {
+    if err := a.Append(record); err != nil {
+      t.Fatal(err)
+    }
+  }
+  a.Close()
+  if _, err := os.Stat(path + ".1"); err != nil {
+    t.Fatalf("Expected a rotated file: %v", err)
+  }
+
+  //the chain continues after reopening
+  a, err = OpenAuditLog(config)
+  if err != nil {
+    t.Fatal(err)
+  }
+  a.Append(record)
+  written++
+  a.Close()
+  count, err := VerifyAuditLog(path, testAuditKey)
+  if err != nil || count != written {
+    t.Errorf("Verified %v of %v records: %v", count, written, err)
+  }
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	ct "github.com/google/certificate-transparency-go"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+	mtrList "github.com/n-ct/ct-monitor/entitylist"
+)
+
+//results of a consistency audit, used as the result label
+const (
+	AuditConsistent = "consistent"
+	AuditInconsistent = "inconsistent"
+	AuditError = "error"
+)
+
+var auditor *Auditor;
+
+// Auditor asks the logs of the log list for consistency proofs between the STHs of different
+// tree sizes that we store. Each proof is verified and stored as an STH_POC, which is gossiped
+// like any new object. A proof that fails to verify is only reported locally, see reportInconsistentProof.
+type Auditor struct{
+	interval time.Duration
+	timeout time.Duration
+
+	mu sync.Mutex
+	audited map[string]map[[2]uint64]bool //[LogID][tree sizes] pairs of stored STHs a proof was received for
+	stop chan struct{}
+	running sync.WaitGroup
+}
+
+//NewAuditor creates an auditor from the auditor section of the configuration
+func NewAuditor(config cto.AuditorConfig) *Auditor {
+	return &Auditor{
+		interval: cto.Milliseconds(config.Interval_ms),
+		timeout: cto.Milliseconds(config.Timeout_ms),
+		audited: make(map[string]map[[2]uint64]bool),
+		stop: make(chan struct{}),
+	}
+}
+
+//Start audits every log at each interval in the background
+func (a *Auditor) Start(){
+	a.running.Add(1)
+	go func(){
+		defer a.running.Done()
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.AuditAll()
+			case <-a.stop:
+				return
+			}
+		}
+	}()
+}
+
+//Stop ends the background audits and waits for the audit in progress, which ends after the current log
+func (a *Auditor) Stop(){
+	close(a.stop)
+	a.running.Wait()
+}
+
+//AuditAll audits every log of the log list that has a URL, forgetting the logs no longer in the list
+func (a *Auditor) AuditAll(){
+	a.mu.Lock()
+	for logID := range a.audited {
+		if getLogs().FindLogByLogID(logID) == nil {
+			delete(a.audited, logID)
+		}
+	}
+	a.mu.Unlock()
+	for _, op := range getLogs().Operators {
+		for _, info := range op.Logs {
+			if len(info.URL) == 0 {
+				continue
+			}
+			select {
+			case <-a.stop:
+				return
+			default:
+			}
+			a.AuditLog(info) //failed pairs are logged by AuditLog
+		}
+	}
+}
+
+// AuditLog requests a consistency proof between each stored STH of the log and the stored STH
+// with the next larger tree size, skipping the pairs already audited. A request that fails is
+// logged and tried again on the next audit, the other pairs are still audited. It returns the
+// last error. The audited pairs whose STHs are no longer both stored are forgotten, so they are
+// pruned along with the store.
+func (a *Auditor) AuditLog(info *mtrList.LogInfo) error {
+	storeLock.RLock()
+	sths := storedSTHsBySize(info.LogID)
+	storeLock.RUnlock()
+	a.pruneAudited(info.LogID, sths)
+	if len(sths) < 2 {
+		return nil
+	}
+	client, err := mtr.NewLogClient(info)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for i := 1; i < len(sths); i++ {
+		older, newer := sths[i-1], sths[i]
+		sizes := [2]uint64{older.TreeHeadData.TreeSize, newer.TreeHeadData.TreeSize}
+		if sizes[0] == 0 || a.isAudited(info.LogID, sizes) { //every tree extends the empty tree
+			continue
+		}
+		proof, err := getConsistencyProof(client, sizes, a.timeout)
+		if err != nil {
+			consistencyAudits.WithLabelValues(AuditError).Inc()
+			lastErr = fmt.Errorf("proof from size %v to %v: %v", sizes[0], sizes[1], err)
+			glog.Errorf("Consistency audit of log %v: %v\n", info.LogID, lastErr)
+			continue
+		}
+		a.markAudited(info.LogID, sizes)
+		storeProof(older, newer, proof)
+	}
+	return lastErr
+}
+
+//getConsistencyProof requests the consistency proof between the tree sizes from the log
+func getConsistencyProof(client *mtr.LogClient, sizes [2]uint64, timeout time.Duration) ([][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	params := map[string]string{
+		"first": strconv.FormatUint(sizes[0], 10),
+		"second": strconv.FormatUint(sizes[1], 10),
+	}
+	var resp ct.GetSTHConsistencyResponse
+	if _, _, err := client.GetAndParse(ctx, ct.GetSTHConsistencyPath, params, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Consistency, nil
+}
+
+//storeProof verifies the proof of the log from older to newer and stores and gossips it as an STH_POC, or reports it if it fails. It returns why the proof failed
+func storeProof(older *mtr.SignedTreeHeadData, newer *mtr.SignedTreeHeadData, proof [][]byte) error {
+	sizes := fmt.Sprintf("from size %v to %v", older.TreeHeadData.TreeSize, newer.TreeHeadData.TreeSize)
+	poc, err := mtr.ConstructCTObject(&mtr.SignedTreeHeadWithConsistencyProof{
+		SignedTreeHead: *newer,
+		ConsistencyProof: mtr.ConsistencyProofData{LogID: newer.LogID, TreeSize1: older.TreeHeadData.TreeSize, TreeSize2: newer.TreeHeadData.TreeSize, ConsistencyPath: proof},
+	})
+	if err != nil {
+		glog.Errorf("Unable to construct the STH_POC of log %v %v: %v\n", newer.LogID, sizes, err)
+		return err
+	}
+	identifierStr := cto.IdentifierToString(poc.Identifier())
+
+	verifyErr := VerifyConsistencyProof(older.TreeHeadData.TreeSize, newer.TreeHeadData.TreeSize,
+		older.TreeHeadData.SHA256RootHash[:], newer.TreeHeadData.SHA256RootHash[:], proof)
+	if verifyErr != nil {
+		consistencyAudits.WithLabelValues(AuditInconsistent).Inc()
+		glog.Infof("%s Consistency proof %v failed: %v\n", identifierStr, sizes, verifyErr)
+		reportInconsistentProof(older, poc)
+		return verifyErr
+	}
+
+	consistencyAudits.WithLabelValues(AuditConsistent).Inc()
+	if storeEntry(messages, *poc, poc.Identifier()) {
+		glog.Infof("%s Stored consistency proof %v\n", identifierStr, sizes)
+		gossipNewData(poc, "")
+	}
+	return nil
+}
+
+func (a *Auditor) isAudited(logID string, sizes [2]uint64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.audited[logID][sizes]
+}
+
+//pruneAudited keeps only the audited pairs of the log that are consecutive among sths, sorted by size
+func (a *Auditor) pruneAudited(logID string, sths []*mtr.SignedTreeHeadData){
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	audited, ok := a.audited[logID]
+	if !ok {
+		return
+	}
+	kept := make(map[[2]uint64]bool)
+	for i := 1; i < len(sths); i++ {
+		sizes := [2]uint64{sths[i-1].TreeHeadData.TreeSize, sths[i].TreeHeadData.TreeSize}
+		if audited[sizes] {
+			kept[sizes] = true
+		}
+	}
+	if len(kept) == 0 {
+		delete(a.audited, logID)
+		return
+	}
+	a.audited[logID] = kept
+}
+
+func (a *Auditor) markAudited(logID string, sizes [2]uint64){
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.audited[logID]; !ok {
+		a.audited[logID] = make(map[[2]uint64]bool)
+	}
+	a.audited[logID][sizes] = true
+}
+
+//storedSTHsBySize returns one stored STH of the log for each tree size, the most recent one, by increasing size. Must be called with storeLock held
+func storedSTHsBySize(logID string) []*mtr.SignedTreeHeadData {
+	bySize := make(map[uint64]*mtr.SignedTreeHeadData)
+	for _, typeID := range []string{mtr.STHTypeID, mtr.STHPOCTypeID} {
+		for _, byVersion := range messages[typeID][logID] {
+			for _, data := range byVersion {
+				sth, err := data.DeconstructSTH()
+				if err != nil {
+					continue
+				}
+				size := sth.TreeHeadData.TreeSize
+				if current, ok := bySize[size]; !ok || current.TreeHeadData.Timestamp < sth.TreeHeadData.Timestamp {
+					bySize[size] = sth
+				}
+			}
+		}
+	}
+	sths := make([]*mtr.SignedTreeHeadData, 0, len(bySize))
+	for _, sth := range bySize {
+		sths = append(sths, sth)
+	}
+	sort.Slice(sths, func(i, j int) bool { return sths[i].TreeHeadData.TreeSize < sths[j].TreeHeadData.TreeSize })
+	return sths
+}
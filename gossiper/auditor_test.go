@@ -0,0 +1,154 @@
+package main
+
+import (
+  "net/http/httptest"
+  "sync/atomic"
+  "testing"
+
+  cto "github.com/n-ct/ct-gossiper"
+  mtr "github.com/n-ct/ct-monitor"
+)
+
+func TestAuditorAuditLog(t *testing.T){
+  leaves := testLeaves(12)
+  forked := testLeaves(12)
+  forked[5] = forked[6]
+
+  testTables := []struct {
+    name string
+    served [][]byte
+    pocs int
+    reports int
+  }{
+    {"consistent log", leaves, 2, 0},
+    {"forked log", forked, 0, 2}, //both proofs go through the rewritten entry
+  }
+  for _, testTable := range testTables{
+    mustGossiperSetup(t)
+    log := addTestLog(t)
+    fake := &fakeLog{served: testTable.served}
+    server := httptest.NewServer(fake)
+    info := logInfoAt(t, log, server.URL+"/")
+    for i, size := range []int{3, 7, 12} {
+      sth := mustConstruct(t, log.treeHead(t, timestamp+uint64(i), leaves, size))
+      storeEntry(messages, sth, sth.Identifier())
+    }
+
+    a := NewAuditor(cto.AuditorConfig{Interval_ms: 1000, Timeout_ms: 1000})
+    if err := a.AuditLog(info); err != nil {
+      t.Errorf("%v: %v", testTable.name, err)
+    }
+    if pocs := len(storedObjects(mtr.STHPOCTypeID, log.id)); pocs != testTable.pocs {
+      t.Errorf("%v: expected %v STH_POCs, got %v", testTable.name, testTable.pocs, pocs)
+    }
+    if reports := storedObjects(cto.InconsistentSTHPOMTypeID, log.id); len(reports) != testTable.reports {
+      t.Errorf("%v: expected %v inconsistent proofs reported, got %v", testTable.name, testTable.reports, len(reports))
+    }
+
+    //audited pairs are not requested again
+    requests := atomic.LoadInt32(&fake.requests)
+    a.AuditLog(info)
+    if atomic.LoadInt32(&fake.requests) != requests {
+      t.Errorf("%v: expected no new request on the second audit", testTable.name)
+    }
+    server.Close()
+  }
+}
+
+func TestAuditorRetriesFailedRequests(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  leaves := testLeaves(8)
+  fake := &fakeLog{served: leaves, fail: 1}
+  server := httptest.NewServer(fake)
+  defer server.Close()
+  info := logInfoAt(t, log, server.URL)
+  for i, size := range []int{2, 8} {
+    sth := mustConstruct(t, log.treeHead(t, timestamp+uint64(i), leaves, size))
+    storeEntry(messages, sth, sth.Identifier())
+  }
+
+  a := NewAuditor(cto.AuditorConfig{Interval_ms: 1000, Timeout_ms: 1000})
+  if err := a.AuditLog(info); err == nil {
+    t.Errorf("Expected an error from an unavailable log")
+  }
+  atomic.StoreInt32(&fake.fail, 0)
+  if err := a.AuditLog(info); err != nil {
+    t.Errorf("Expected the audit to succeed once the log is back: %v", err)
+  }
+  pocs := storedObjects(mtr.STHPOCTypeID, log.id)
+  if len(pocs) != 1 {
+    t.Fatalf("Expected 1 STH_POC, got %v", len(pocs))
+  }
+  if err := ValidateSignature(pocs[0]); err != nil {
+    t.Errorf("The STH_POC does not validate: %v", err)
+  }
+}
+
+func TestAuditorFailedPair(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  leaves := testLeaves(12)
+  fake := &fakeLog{served: leaves, failFrom: 3}
+  server := httptest.NewServer(fake)
+  defer server.Close()
+  info := logInfoAt(t, log, server.URL)
+  for i, size := range []int{3, 7, 12} {
+    sth := mustConstruct(t, log.treeHead(t, timestamp+uint64(i), leaves, size))
+    storeEntry(messages, sth, sth.Identifier())
+  }
+
+  a := NewAuditor(cto.AuditorConfig{Interval_ms: 1000, Timeout_ms: 1000})
+  if err := a.AuditLog(info); err == nil {
+    t.Errorf("Expected the failed pair to be reported")
+  }
+  pocs := storedObjects(mtr.STHPOCTypeID, log.id)
+  if len(pocs) != 1 {
+    t.Fatalf("Expected the pair after the failed one to be audited, got %v STH_POCs", len(pocs))
+  }
+  if poc, err := pocs[0].DeconstructPOC(); err != nil || poc.TreeSize1 != 7 {
+    t.Errorf("Expected the proof from size 7, got %+v: %v", poc, err)
+  }
+}
+
+func TestAuditorPrunesAudited(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  leaves := testLeaves(12)
+  fake := &fakeLog{served: leaves}
+  server := httptest.NewServer(fake)
+  defer server.Close()
+  info := logInfoAt(t, log, server.URL)
+  var sths []mtr.CTObject
+  for i, size := range []int{3, 7, 12} {
+    sth := mustConstruct(t, log.treeHead(t, timestamp+uint64(i), leaves, size))
+    storeEntry(messages, sth, sth.Identifier())
+    sths = append(sths, sth)
+  }
+  //removes the STH and the STH_POC stored for its tree head, as retention would
+  remove := func(sth mtr.CTObject){
+    objects := append(storedObjects(mtr.STHPOCTypeID, log.id), &sth)
+    storeLock.Lock()
+    defer storeLock.Unlock()
+    for _, data := range objects {
+      if data.Timestamp == sth.Timestamp {
+        removeEntry(messages, data.Identifier())
+      }
+    }
+  }
+
+  a := NewAuditor(cto.AuditorConfig{Interval_ms: 1000, Timeout_ms: 1000})
+  if err := a.AuditLog(info); err != nil {
+    t.Fatal(err)
+  }
+  remove(sths[0])
+  a.AuditLog(info)
+  if audited := a.audited[log.id]; len(audited) != 1 || !audited[[2]uint64{7, 12}] {
+    t.Errorf("Expected only the pair of stored STHs to be kept, got %v", audited)
+  }
+  remove(sths[1])
+  a.AuditLog(info)
+  if _, ok := a.audited[log.id]; ok {
+    t.Errorf("Expected the log to be forgotten with a single stored STH")
+  }
+}
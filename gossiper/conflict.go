@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+const maxUnresolvedConflicts = 1000
+
+// ErrNotConflicting is returned by a resolver when the two objects carry the same signed
+// content and only differ in how it was encoded or signed, for example the same tree head
+// signed twice. The received object is then treated as a duplicate.
+var ErrNotConflicting = errors.New("objects carry the same signed content")
+
+// ConflictResolver builds the proof of misbehavior for two valid objects of its type that
+// share an identifier but differ in digest.
+type ConflictResolver func(stored *mtr.CTObject, received *mtr.CTObject) (*mtr.CTObject, error)
+
+// UnresolvedConflict is a conflict between objects of a type without a resolver.
+type UnresolvedConflict struct{
+	Time time.Time `json:"time"`
+	TypeID string `json:"type_id"`
+	Identifier string `json:"identifier"`
+	StoredDigest []byte `json:"stored_digest"`
+	ReceivedDigest []byte `json:"received_digest"`
+	Sender string `json:"sender"`
+}
+
+var resolvers = make(map[string]ConflictResolver); //[TypeID]
+var resolversLock sync.RWMutex;
+var unresolved []UnresolvedConflict; //most recent last
+var unresolvedLock sync.Mutex;
+
+//RegisterConflictResolver makes r the resolver of typeID, registering a type twice is a programming error
+func RegisterConflictResolver(typeID string, r ConflictResolver){
+	resolversLock.Lock()
+	defer resolversLock.Unlock()
+	if r == nil {
+		panic("gossiper: RegisterConflictResolver with a nil resolver for " + typeID)
+	}
+	if _, ok := resolvers[typeID]; ok {
+		panic("gossiper: RegisterConflictResolver called twice for " + typeID)
+	}
+	resolvers[typeID] = r
+}
+
+//resolverFor returns the resolver registered for typeID, nil if there is none
+func resolverFor(typeID string) ConflictResolver {
+	resolversLock.RLock()
+	defer resolversLock.RUnlock()
+	return resolvers[typeID]
+}
+
+func init(){
+	RegisterConflictResolver(mtr.STHTypeID, resolveConflictingSTHs)
+	RegisterConflictResolver(mtr.STHPOCTypeID, resolveConflictingSTHs)
+	RegisterConflictResolver(mtr.SRDWithRevDataTypeID, resolveConflictingSRDs)
+	RegisterConflictResolver(mtr.AlertTypeID, resolveEquivocatingAlerts)
+	RegisterConflictResolver(cto.STHCosignatureTypeID, resolveConflictingCosignatures)
+	//two proofs of the same misbehavior, built from different objects or in a different order
+	RegisterConflictResolver(mtr.ConflictingSTHPOMTypeID, sameMisbehavior)
+	RegisterConflictResolver(mtr.ConflictingSRDPOMTypeID, sameMisbehavior)
+	RegisterConflictResolver(cto.MonitorEquivocationPOMTypeID, sameMisbehavior)
+}
+
+//resolveConflictingSTHs proves that a log signed two different tree heads with the same timestamp
+func resolveConflictingSTHs(stored *mtr.CTObject, received *mtr.CTObject) (*mtr.CTObject, error) {
+	sth1, err := received.DeconstructSTH()
+	if err != nil {
+		return nil, err
+	}
+	sth2, err := stored.DeconstructSTH()
+	if err != nil {
+		return nil, err
+	}
+	if sth1.TreeHeadData == sth2.TreeHeadData {
+		return nil, ErrNotConflicting
+	}
+	return mtr.CreateConflictingSTHPOM(received, stored)
+}
+
+//resolveConflictingSRDs proves that an entity signed two different revocation digests with the same timestamp
+func resolveConflictingSRDs(stored *mtr.CTObject, received *mtr.CTObject) (*mtr.CTObject, error) {
+	srd1, err := received.DeconstructSRD()
+	if err != nil {
+		return nil, err
+	}
+	srd2, err := stored.DeconstructSRD()
+	if err != nil {
+		return nil, err
+	}
+	if srd1.RevDigest.Timestamp == srd2.RevDigest.Timestamp && bytes.Equal(srd1.RevDigest.CRVHash, srd2.RevDigest.CRVHash) &&
+		bytes.Equal(srd1.RevDigest.CRVDeltaHash, srd2.RevDigest.CRVDeltaHash) {
+		return nil, ErrNotConflicting
+	}
+	return mtr.CreateConflictingSRDPOM(received, stored)
+}
+
+//resolveEquivocatingAlerts proves that a monitor signed two different alerts about the same subject and timestamp, alerts are stored under their subject
+func resolveEquivocatingAlerts(stored *mtr.CTObject, received *mtr.CTObject) (*mtr.CTObject, error) {
+	alert1, err := received.DeconstructAlert()
+	if err != nil {
+		return nil, err
+	}
+	alert2, err := stored.DeconstructAlert()
+	if err != nil {
+		return nil, err
+	}
+	if alert1.TBS == alert2.TBS {
+		return nil, ErrNotConflicting
+	}
+	return cto.CreateMonitorEquivocationPOM(received, stored)
+}
+
+// resolveConflictingCosignatures proves that a log signed two different tree heads with the same
+// timestamp, found in two cosignatures of a witness. The same tree head cosigned twice is not a conflict.
+func resolveConflictingCosignatures(stored *mtr.CTObject, received *mtr.CTObject) (*mtr.CTObject, error) {
+	cosig1, err := cto.DeconstructSTHCosignature(received)
+	if err != nil {
+		return nil, err
+	}
+	cosig2, err := cto.DeconstructSTHCosignature(stored)
+	if err != nil {
+		return nil, err
+	}
+	if cosig1.TBS.STH.TreeHeadData == cosig2.TBS.STH.TreeHeadData {
+		return nil, ErrNotConflicting
+	}
+	sth1, err := mtr.ConstructCTObject(&cosig1.TBS.STH)
+	if err != nil {
+		return nil, err
+	}
+	sth2, err := mtr.ConstructCTObject(&cosig2.TBS.STH)
+	if err != nil {
+		return nil, err
+	}
+	return mtr.CreateConflictingSTHPOM(sth1, sth2)
+}
+
+func sameMisbehavior(stored *mtr.CTObject, received *mtr.CTObject) (*mtr.CTObject, error) {
+	return nil, ErrNotConflicting
+}
+
+// resolveConflict handles a valid object whose digest differs from the stored object with the
+// same identifier. The resolver of its type builds the proof of misbehavior, which is stored and
+// gossiped. Types without a resolver are kept as unresolved conflicts for an operator to look at.
+// It returns the status and response to send back.
+func resolveConflict(record *AuditRecord, stored *mtr.CTObject, received *mtr.CTObject, sender string, requesterAddress string) (int, string) {
+	identifierStr := cto.IdentifierToString(received.Identifier())
+	resolver := resolverFor(received.TypeID)
+	if resolver == nil {
+		glog.Infof("%s Unresolved conflict, no resolver for %v\n\n", identifierStr, received.TypeID)
+		record.observe(received.TypeID, OutcomeUnresolved)
+		recordUnresolved(stored, received, sender)
+		return http.StatusOK, "unresolved conflict"
+	}
+	PoM, err := resolver(stored, received)
+	if errors.Is(err, ErrNotConflicting) {
+		glog.Infof("%s Duplicate Item\n\n", identifierStr)
+		record.observe(received.TypeID, OutcomeDuplicate)
+		return http.StatusBadRequest, "Duplicate item"
+	}
+	record.observe(received.TypeID, OutcomeConflict)
+	if err != nil {
+		glog.Errorf("%s Error creating PoM: %s\n", identifierStr, err)
+		record.Error = err.Error()
+		return http.StatusOK, ""
+	}
+	glog.Infof("%s Misbehavior detected\n", identifierStr);
+	record.Destinations = raisePoM(PoM, requesterAddress)
+	return http.StatusOK, ""
+}
+
+// raisePoM stores a proof of misbehavior this gossiper built and gossips it to the peers and the
+// monitor. The PoM goes through the validator of its type first, the same checks our peers will run,
+// and is dropped if it fails them. A PoM of a type without a validator is only kept locally, since
+// peers could not verify it. It returns where the PoM was sent.
+func raisePoM(PoM *mtr.CTObject, requesterAddress string) []string {
+	identifierStr := cto.IdentifierToString(PoM.Identifier())
+	validator := validatorFor(PoM.TypeID)
+	if validator != nil {
+		if err := ValidateSignature(PoM); err != nil {
+			glog.Errorf("%s Dropping invalid PoM: %v\n\n", identifierStr, err)
+			return nil
+		}
+	}
+	if !storeEntry(workingMapFor(PoM), *PoM, PoM.Identifier()) {
+		glog.Infof("%s PoM already stored\n\n", identifierStr)
+		return nil
+	}
+	pomsCreated.WithLabelValues(PoM.TypeID).Inc()
+	glog.Infof("%s Stored PoM\n", identifierStr)
+	Notify(cto.EventPoMCreated, PoM)
+	if validator == nil {
+		glog.Infof("%s Not gossiping %v, no validator for it\n\n", identifierStr, PoM.TypeID)
+		return nil
+	}
+	destinations := append(gossipPeers(PoM, requesterAddress), gossipMonitor(PoM, requesterAddress)...)
+	glog.Infof("%s Finished gossiping PoM\n\n", identifierStr)
+	return destinations
+}
+
+//recordUnresolved keeps a conflict no resolver could handle, dropping the oldest when full
+func recordUnresolved(stored *mtr.CTObject, received *mtr.CTObject, sender string){
+	conflict := UnresolvedConflict{
+		Time: time.Now().UTC(),
+		TypeID: received.TypeID,
+		Identifier: cto.IdentifierToString(received.Identifier()),
+		StoredDigest: stored.Digest,
+		ReceivedDigest: received.Digest,
+		Sender: sender,
+	}
+	unresolvedLock.Lock()
+	defer unresolvedLock.Unlock()
+	unresolved = append(unresolved, conflict)
+	if len(unresolved) > maxUnresolvedConflicts {
+		unresolved = unresolved[len(unresolved)-maxUnresolvedConflicts:]
+	}
+}
+
+//UnresolvedConflicts returns a copy of the recorded unresolved conflicts
+func UnresolvedConflicts() []UnresolvedConflict {
+	unresolvedLock.Lock()
+	defer unresolvedLock.Unlock()
+	return append([]UnresolvedConflict{}, unresolved...)
+}
+
+//ConflictsHandler returns the unresolved conflicts as JSON, most recent last
+func ConflictsHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UnresolvedConflicts())
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+  "bytes"
+  "crypto/sha256"
+  "encoding/json"
+  "net/http/httptest"
+  "strings"
+  "testing"
+
+  tls "github.com/google/certificate-transparency-go/tls"
+  cto "github.com/n-ct/ct-gossiper"
+  mtr "github.com/n-ct/ct-monitor"
+)
+
+//gossip posts data to GossipHandler and returns the response body
+func gossip(t *testing.T, data mtr.CTObject) string {
+  jsonStr, err := json.Marshal(data)
+  if err != nil {
+    t.Fatal(err)
+  }
+  recorder := httptest.NewRecorder()
+  GossipHandler(recorder, httptest.NewRequest("POST", GossipPath, bytes.NewBuffer(jsonStr)))
+  return recorder.Body.String()
+}
+
+//storedObjects returns the objects of typeID stored under subject, PoMs are stored under their subject
+func storedObjects(typeID string, subject string) []*mtr.CTObject {
+  storeLock.RLock()
+  defer storeLock.RUnlock()
+  var poms []*mtr.CTObject
+  for _, byTimestamp := range messages[typeID][subject] {
+    for _, pom := range byTimestamp {
+      poms = append(poms, pom)
+    }
+  }
+  return poms
+}
+
+func TestConflictResolvers(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  cosigned := addTestLog(t)
+  monitor := addTestMonitor(t)
+
+  testTables := []struct {
+    name string
+    stored mtr.CTObject
+    received mtr.CTObject
+    pomType string //empty when no PoM is expected
+    subject string
+  }{
+    {"conflicting STHs", log.sth(t, timestamp, 10, 1), log.sth(t, timestamp, 10, 2), mtr.ConflictingSTHPOMTypeID, log.id},
+    {"re-signed STH", log.sth(t, timestamp+1, 10, 1), log.sth(t, timestamp+1, 10, 1), "", ""},
+    {"conflicting SRDs", log.srd(t, timestamp, "crv"), log.srd(t, timestamp, "other crv"), mtr.ConflictingSRDPOMTypeID, log.id},
+    {"re-signed SRD", log.srd(t, timestamp+1, "crv"), log.srd(t, timestamp+1, "crv"), "", ""},
+    {"equivocating alerts", monitor.alert(t, "LoggerNonResponsive", loggerSigner, timestamp), monitor.alert(t, "LoggerResponsive", loggerSigner, timestamp), cto.MonitorEquivocationPOMTypeID, monitor.id},
+    {"re-signed alert", monitor.alert(t, "LoggerNonResponsive", loggerSigner, timestamp+1), monitor.alert(t, "LoggerNonResponsive", loggerSigner, timestamp+1), "", ""},
+    {"cosigned conflicting STHs", monitor.cosign(t, cosigned.signedTreeHead(t, timestamp, 10, 1)), monitor.cosign(t, cosigned.signedTreeHead(t, timestamp, 10, 2)), mtr.ConflictingSTHPOMTypeID, cosigned.id},
+    {"re-cosigned STH", monitor.cosign(t, cosigned.signedTreeHead(t, timestamp+1, 10, 1)), monitor.cosign(t, cosigned.signedTreeHead(t, timestamp+1, 10, 1)), "", ""},
+  }
+  for _, testTable := range testTables{
+    if response := gossip(t, testTable.stored); response != "new data" {
+      t.Fatalf("%v: storing the first object returned %q", testTable.name, response)
+    }
+    response := gossip(t, testTable.received)
+    if len(testTable.pomType) == 0 {
+      if !strings.HasPrefix(response, "Duplicate item") {
+        t.Errorf("%v: expected a duplicate, got %q", testTable.name, response)
+      }
+      continue
+    }
+    poms := storedObjects(testTable.pomType, testTable.subject)
+    if len(poms) != 1 {
+      t.Errorf("%v: expected 1 %v about %v, got %v", testTable.name, testTable.pomType, testTable.subject, len(poms))
+      continue
+    }
+    if validatorFor(testTable.pomType) != nil {
+      if err := ValidateSignature(poms[0]); err != nil {
+        t.Errorf("%v: the PoM does not validate: %v", testTable.name, err)
+      }
+    }
+  }
+}
+
+func TestEquivocationSubjects(t *testing.T){
+  mustGossiperSetup(t)
+  monitor := addTestMonitor(t)
+  //alerts as ct-monitor builds them, with the subject only in the signed alert
+  ctMonitorAlert := func(alertType string, subject string) mtr.CTObject {
+    return modified(monitor.alert(t, alertType, subject, timestamp), func(d *mtr.CTObject){ d.Subject = "" })
+  }
+
+  testTables := []struct {
+    name string
+    data mtr.CTObject
+    response string
+    poms int
+  }{
+    {"first alert", ctMonitorAlert("LoggerNonResponsive", loggerSigner), "new data", 0},
+    {"same time, another subject", ctMonitorAlert("LoggerNonResponsive", "another log"), "new data", 0},
+    {"same subject, another alert", ctMonitorAlert("LoggerResponsive", loggerSigner), "", 1},
+  }
+  for _, testTable := range testTables{
+    if response := gossip(t, testTable.data); response != testTable.response {
+      t.Errorf("%v: returned %q want %q", testTable.name, response, testTable.response)
+    }
+    if poms := storedObjects(cto.MonitorEquivocationPOMTypeID, monitor.id); len(poms) != testTable.poms {
+      t.Errorf("%v: expected %v PoMs, got %v", testTable.name, testTable.poms, len(poms))
+    }
+  }
+  alert1, alert2 := monitor.alert(t, "LoggerNonResponsive", loggerSigner, timestamp), monitor.alert(t, "LoggerNonResponsive", "another log", timestamp)
+  if _, err := cto.CreateMonitorEquivocationPOM(&alert1, &alert2); err == nil {
+    t.Errorf("Expected no PoM for alerts about different subjects")
+  }
+}
+
+func TestRaisePoMInvalid(t *testing.T){
+  mustGossiperSetup(t)
+  monitor := addTestMonitor(t)
+  pom := equivocation(t, monitor.alert(t, "LoggerNonResponsive", loggerSigner, timestamp), monitor.alert(t, "LoggerResponsive", "another log", timestamp))
+  if destinations := raisePoM(&pom, ""); destinations != nil {
+    t.Errorf("Expected the invalid PoM not to be gossiped, sent to %v", destinations)
+  }
+  if poms := storedObjects(cto.MonitorEquivocationPOMTypeID, monitor.id); len(poms) != 0 {
+    t.Errorf("Expected the invalid PoM not to be stored, got %v", len(poms))
+  }
+}
+
+func TestUnresolvedConflict(t *testing.T){
+  mustGossiperSetup(t)
+  const typeID = "TEST_OBJECT"
+  RegisterValidator(typeID, ValidatorFuncs{
+    SignatureFunc: func(data *mtr.CTObject) (tls.HashAlgorithm, error) { return tls.SHA256, nil },
+  })
+  defer func(){
+    validatorsLock.Lock()
+    delete(validators, typeID)
+    validatorsLock.Unlock()
+  }()
+  before := len(UnresolvedConflicts())
+
+  object := func(blob string) mtr.CTObject {
+    digest := sha256.Sum256([]byte(blob))
+    return mtr.CTObject{TypeID: typeID, Version: version, Timestamp: timestamp, Signer: "tester", Digest: digest[:], Blob: []byte(blob)}
+  }
+  gossip(t, object("first"))
+  if response := gossip(t, object("second")); response != "unresolved conflict" {
+    t.Errorf("Expected an unresolved conflict, got %q", response)
+  }
+  conflicts := UnresolvedConflicts()
+  if len(conflicts) != before+1 {
+    t.Fatalf("Expected 1 new unresolved conflict, got %v", len(conflicts)-before)
+  }
+  if conflict := conflicts[len(conflicts)-1]; conflict.TypeID != typeID || bytes.Equal(conflict.StoredDigest, conflict.ReceivedDigest) {
+    t.Errorf("Unexpected conflict %+v", conflict)
+  }
+
+  recorder := httptest.NewRecorder()
+  ConflictsHandler(recorder, httptest.NewRequest("GET", cto.ConflictsPath, nil))
+  var served []UnresolvedConflict
+  if err := json.NewDecoder(recorder.Body).Decode(&served); err != nil || len(served) != len(conflicts) {
+    t.Errorf("Expected %v conflicts to be served, got %v: %v", len(conflicts), len(served), err)
+  }
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+// storedPOC is an STH_POC of a log found in the store.
+type storedPOC struct{
+	data *mtr.CTObject
+	sth *mtr.SignedTreeHeadData
+	proof *mtr.ConsistencyProofData
+}
+
+//storedSTHsOfSize returns the stored STHs and STH_POCs of the log for a tree of size treeSize. Must be called with storeLock held
+func storedSTHsOfSize(logID string, treeSize uint64) []*mtr.SignedTreeHeadData {
+	var sths []*mtr.SignedTreeHeadData
+	for _, typeID := range []string{mtr.STHTypeID, mtr.STHPOCTypeID} {
+		for _, byVersion := range messages[typeID][logID] {
+			for _, data := range byVersion {
+				sth, err := data.DeconstructSTH()
+				if err == nil && sth.TreeHeadData.TreeSize == treeSize {
+					sths = append(sths, sth)
+				}
+			}
+		}
+	}
+	return sths
+}
+
+//storedPOCsFrom returns the stored STH_POCs of the log whose proof starts at a tree of size treeSize. Must be called with storeLock held
+func storedPOCsFrom(logID string, treeSize uint64) []storedPOC {
+	var pocs []storedPOC
+	for _, byVersion := range messages[mtr.STHPOCTypeID][logID] {
+		for _, data := range byVersion {
+			if poc, err := readPOC(data); err == nil && poc.proof.TreeSize1 == treeSize {
+				pocs = append(pocs, poc)
+			}
+		}
+	}
+	return pocs
+}
+
+func readPOC(data *mtr.CTObject) (storedPOC, error) {
+	sth, err := data.DeconstructSTH()
+	if err != nil {
+		return storedPOC{}, err
+	}
+	proof, err := data.DeconstructPOC()
+	if err != nil {
+		return storedPOC{}, err
+	}
+	return storedPOC{data, sth, proof}, nil
+}
+
+//verifyPOC checks the consistency proof of poc from oldSTH, an STH of the same log for the first tree size of the proof
+func verifyPOC(oldSTH *mtr.SignedTreeHeadData, poc storedPOC) error {
+	return VerifyConsistencyProof(poc.proof.TreeSize1, poc.proof.TreeSize2,
+		oldSTH.TreeHeadData.SHA256RootHash[:], poc.sth.TreeHeadData.SHA256RootHash[:], poc.proof.ConsistencyPath)
+}
+
+// ErrInconsistentProof is returned for an STH_POC whose consistency proof fails to verify from a
+// stored STH of the log. The proof is not signed by the log and the sender may only relay what
+// the log served, so the object is refused without blaming the sender and the log is asked for
+// its own proof, see recheckWithLog.
+var ErrInconsistentProof = errors.New("consistency proof does not verify")
+
+// checkConsistency verifies the consistency proof of a received STH_POC from the stored STHs of
+// the log for the first tree size of the proof. A proof whose older STH is not stored yet is
+// checked by checkStoredSTHs once it arrives.
+func checkConsistency(data *mtr.CTObject) error {
+	if data.TypeID != mtr.STHPOCTypeID {
+		return nil
+	}
+	poc, err := readPOC(data)
+	if err != nil {
+		return err
+	}
+	if oldSTH := failingOldSTH(poc); oldSTH != nil {
+		return fmt.Errorf("%w from size %v", ErrInconsistentProof, poc.proof.TreeSize1)
+	}
+	return nil
+}
+
+//failingOldSTH returns a stored STH of the log the proof of poc fails to verify from, nil if there is none
+func failingOldSTH(poc storedPOC) *mtr.SignedTreeHeadData {
+	storeLock.RLock()
+	oldSTHs := storedSTHsOfSize(poc.sth.LogID, poc.proof.TreeSize1)
+	storeLock.RUnlock()
+	for _, oldSTH := range oldSTHs {
+		if verifyPOC(oldSTH, poc) != nil {
+			return oldSTH
+		}
+	}
+	return nil
+}
+
+// recheckPOC asks the log for its own proof when a received STH_POC was refused with
+// ErrInconsistentProof. The answer of the log is stored and gossiped if it verifies, and
+// reported locally if it fails too.
+func recheckPOC(data *mtr.CTObject){
+	poc, err := readPOC(data)
+	if err != nil {
+		return
+	}
+	if oldSTH := failingOldSTH(poc); oldSTH != nil {
+		if err := recheckWithLog(oldSTH, poc.sth); err != nil {
+			glog.Infof("%s Consistency proof from size %v not checked with the log: %v\n", cto.IdentifierToString(data.Identifier()), poc.proof.TreeSize1, err)
+		}
+	}
+}
+
+// recheckWithLog requests the consistency proof from older to newer from their log and handles
+// it with storeProof. It returns an error wrapping ErrProofFailed if the proof of the log fails,
+// or the error that prevented the check.
+func recheckWithLog(older *mtr.SignedTreeHeadData, newer *mtr.SignedTreeHeadData) error {
+	timeout := 10 * time.Second
+	if config := getConfig(); config != nil {
+		timeout = durationOrDefault(config.Auditor.Timeout_ms, timeout)
+	}
+	return proofFromLog(older, newer, timeout)
+}
+
+//proofFromLog requests the consistency proof from older to newer from their log within timeout and handles it with storeProof
+func proofFromLog(older *mtr.SignedTreeHeadData, newer *mtr.SignedTreeHeadData, timeout time.Duration) error {
+	oldSize, newSize := older.TreeHeadData.TreeSize, newer.TreeHeadData.TreeSize
+	info := getLogs().FindLogByLogID(newer.LogID)
+	if info == nil || len(info.URL) == 0 {
+		return fmt.Errorf("no consistency proof from size %v to %v and no URL for log %v", oldSize, newSize, newer.LogID)
+	}
+	client, err := mtr.NewLogClient(info)
+	if err != nil {
+		return err
+	}
+	proof, err := getConsistencyProof(client, [2]uint64{oldSize, newSize}, timeout)
+	if err != nil {
+		return fmt.Errorf("proof from size %v to %v: %v", oldSize, newSize, err)
+	}
+	return storeProof(older, newer, proof)
+}
+
+// checkStoredSTHs compares a newly stored STH or STH_POC with the stored objects of its log.
+// A stored tree head of the same size with another root shows that the log presents a split
+// view: both are signed by the log, so a ConflictingSTH PoM is raised. A stored STH_POC whose
+// proof starts at the new tree and fails to verify from it was accepted before an STH of that
+// size was known: its proof is dropped, its tree head is kept as an STH and the log is asked for
+// its own proof.
+func checkStoredSTHs(data *mtr.CTObject){
+	if data.TypeID != mtr.STHTypeID && data.TypeID != mtr.STHPOCTypeID {
+		return
+	}
+	sth, err := data.DeconstructSTH()
+	if err != nil {
+		return
+	}
+	var splitViews []*mtr.CTObject
+	var failed []storedPOC
+	storeLock.Lock()
+	for _, typeID := range []string{mtr.STHTypeID, mtr.STHPOCTypeID} {
+		for _, byVersion := range messages[typeID][sth.LogID] {
+			for _, stored := range byVersion {
+				other, err := stored.DeconstructSTH()
+				if err == nil && other.TreeHeadData.TreeSize == sth.TreeHeadData.TreeSize && other.TreeHeadData.SHA256RootHash != sth.TreeHeadData.SHA256RootHash {
+					splitViews = append(splitViews, stored)
+				}
+			}
+		}
+	}
+	for _, poc := range storedPOCsFrom(sth.LogID, sth.TreeHeadData.TreeSize) {
+		if err := verifyPOC(sth, poc); err != nil {
+			glog.Infof("%s Dropped the proof, consistency proof from size %v failed: %v\n", cto.IdentifierToString(poc.data.Identifier()), poc.proof.TreeSize1, err)
+			removeEntry(messages, poc.data.Identifier())
+			if treeHead, err := mtr.ConstructCTObject(poc.sth); err == nil {
+				identifier := treeHead.Identifier()
+				if _, ok := messages[identifier.First][identifier.Second][identifier.Third][identifier.Fourth]; !ok {
+					addEntry(messages, *treeHead, identifier)
+				}
+			}
+			failed = append(failed, poc)
+		}
+	}
+	storeLock.Unlock()
+
+	for _, stored := range splitViews {
+		PoM, err := mtr.CreateConflictingSTHPOM(data, stored)
+		if err != nil {
+			glog.Errorf("%s Error creating PoM: %s\n", cto.IdentifierToString(data.Identifier()), err)
+			continue
+		}
+		glog.Infof("%s Split view, log signed another root for tree size %v\n", cto.IdentifierToString(data.Identifier()), sth.TreeHeadData.TreeSize)
+		raisePoM(PoM, "")
+	}
+	for _, poc := range failed {
+		if err := recheckWithLog(sth, poc.sth); err != nil {
+			glog.Infof("%s Consistency proof from size %v not checked with the log: %v\n", cto.IdentifierToString(poc.data.Identifier()), poc.proof.TreeSize1, err)
+		}
+	}
+}
+
+// reportInconsistentProof records that a log answered our own request with a consistency proof
+// from oldSTH that fails to verify. The proof is not signed by the log, so nobody else could check
+// the record: it is kept locally as an InconsistentSTHPOM and sent to the notifiers, never gossiped.
+func reportInconsistentProof(oldSTH *mtr.SignedTreeHeadData, poc *mtr.CTObject){
+	identifierStr := cto.IdentifierToString(poc.Identifier())
+	report, err := cto.CreateInconsistentSTHPOM(oldSTH, poc)
+	if err != nil {
+		glog.Errorf("%s Error creating InconsistentSTHPOM: %s\n", identifierStr, err)
+		return
+	}
+	if !storeEntry(messages, *report, report.Identifier()) {
+		return
+	}
+	glog.Infof("%s Stored inconsistent proof from size %v, not gossiped\n", identifierStr, oldSTH.TreeHeadData.TreeSize)
+	Notify(cto.EventInconsistentProof, report)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "testing"
+
+  ct "github.com/google/certificate-transparency-go"
+  cto "github.com/n-ct/ct-gossiper"
+  mtr "github.com/n-ct/ct-monitor"
+)
+
+//treeHead returns an STH of the log for the first size leaves
+func (k *testKey) treeHead(t *testing.T, timestamp uint64, leaves [][]byte, size int) *mtr.SignedTreeHeadData {
+  var root ct.SHA256Hash
+  copy(root[:], treeHash(leaves[:size]))
+  return k.signTreeHead(t, ct.TreeHeadSignature{Version: ct.V1, SignatureType: ct.TreeHashSignatureType, Timestamp: timestamp, TreeSize: uint64(size), SHA256RootHash: root})
+}
+
+//sthPOC returns an STH_POC of the log for the first size2 leaves with the given proof from size1
+func (k *testKey) sthPOC(t *testing.T, timestamp uint64, leaves [][]byte, size1 int, size2 int, proof [][]byte) mtr.CTObject {
+  return mustConstruct(t, &mtr.SignedTreeHeadWithConsistencyProof{
+    SignedTreeHead: *k.treeHead(t, timestamp, leaves, size2),
+    ConsistencyProof: mtr.ConsistencyProofData{LogID: k.id, TreeSize1: uint64(size1), TreeSize2: uint64(size2), ConsistencyPath: proof},
+  })
+}
+
+func TestCheckConsistency(t *testing.T){
+  leaves := testLeaves(12)
+  forked := testLeaves(12)
+  forked[2] = forked[3] //the sender attached a proof of another tree
+
+  testTables := []struct {
+    name string
+    first func(log *testKey) mtr.CTObject
+    second func(log *testKey) mtr.CTObject
+    response string //to the second object
+    pocs int
+    sths int
+  }{
+    {"consistent", func(log *testKey) mtr.CTObject {
+      return mustConstruct(t, log.treeHead(t, timestamp, leaves, 5))
+    }, func(log *testKey) mtr.CTObject {
+      return log.sthPOC(t, timestamp+1, leaves, 5, 12, consistencyProof(5, leaves))
+    }, "new data", 1, 1},
+    {"bad proof", func(log *testKey) mtr.CTObject {
+      return mustConstruct(t, log.treeHead(t, timestamp, leaves, 5))
+    }, func(log *testKey) mtr.CTObject {
+      return log.sthPOC(t, timestamp+1, leaves, 5, 12, consistencyProof(5, forked))
+    }, "inconsistent proof\n", 0, 1},
+    {"older STH received last", func(log *testKey) mtr.CTObject {
+      return log.sthPOC(t, timestamp+1, leaves, 5, 12, consistencyProof(5, forked))
+    }, func(log *testKey) mtr.CTObject {
+      return mustConstruct(t, log.treeHead(t, timestamp, leaves, 5))
+    }, "new data", 0, 2},
+    {"older STH unknown", func(log *testKey) mtr.CTObject {
+      return mustConstruct(t, log.treeHead(t, timestamp, leaves, 4))
+    }, func(log *testKey) mtr.CTObject {
+      return log.sthPOC(t, timestamp+1, leaves, 5, 12, consistencyProof(5, forked))
+    }, "new data", 1, 1},
+  }
+  for _, testTable := range testTables{
+    mustGossiperSetup(t)
+    log := addTestLog(t)
+    if response := gossip(t, testTable.first(log)); response != "new data" {
+      t.Fatalf("%v: storing the first object returned %q", testTable.name, response)
+    }
+    if response := gossip(t, testTable.second(log)); response != testTable.response {
+      t.Errorf("%v: storing the second object returned %q want %q", testTable.name, response, testTable.response)
+    }
+    if pocs := storedObjects(mtr.STHPOCTypeID, log.id); len(pocs) != testTable.pocs {
+      t.Errorf("%v: expected %v STH_POCs, got %v", testTable.name, testTable.pocs, len(pocs))
+    }
+    if sths := storedObjects(mtr.STHTypeID, log.id); len(sths) != testTable.sths {
+      t.Errorf("%v: expected %v STHs, got %v", testTable.name, testTable.sths, len(sths))
+    }
+    if reports := storedObjects(cto.InconsistentSTHPOMTypeID, log.id); len(reports) != 0 {
+      t.Errorf("%v: expected a bad proof from a peer not to be reported, got %v", testTable.name, len(reports))
+    }
+    for sender, score := range scores.Snapshot() {
+      if score.Events[PeerEventInvalid] != 0 {
+        t.Errorf("%v: expected %v not to be blamed for the proof", testTable.name, sender)
+      }
+    }
+  }
+}
+
+func TestRecheckPOC(t *testing.T){
+  leaves := testLeaves(12)
+  forked := testLeaves(12)
+  forked[2] = forked[3]
+
+  testTables := []struct {
+    name string
+    served [][]byte //tree the log builds its proof from, nil when it is unavailable
+    pocs int
+    reports int
+  }{
+    {"the peer relayed a bad proof", leaves, 1, 0},
+    {"the log serves a bad proof", forked, 0, 1},
+    {"log unavailable", nil, 0, 0},
+  }
+  for _, testTable := range testTables{
+    mustGossiperSetup(t)
+    log := addTestLog(t)
+    var handler http.Handler = http.NotFoundHandler()
+    if testTable.served != nil {
+      handler = &fakeLog{served: testTable.served}
+    }
+    server := httptest.NewServer(handler)
+    logInfoAt(t, log, server.URL)
+
+    gossip(t, mustConstruct(t, log.treeHead(t, timestamp, leaves, 5)))
+    if response := gossip(t, log.sthPOC(t, timestamp+1, leaves, 5, 12, consistencyProof(5, forked))); response != "inconsistent proof\n" {
+      t.Errorf("%v: expected the proof to be refused, got %q", testTable.name, response)
+    }
+    server.Close()
+    if pocs := storedObjects(mtr.STHPOCTypeID, log.id); len(pocs) != testTable.pocs {
+      t.Errorf("%v: expected %v STH_POCs, got %v", testTable.name, testTable.pocs, len(pocs))
+    }
+    if reports := storedObjects(cto.InconsistentSTHPOMTypeID, log.id); len(reports) != testTable.reports {
+      t.Errorf("%v: expected %v reports, got %v", testTable.name, testTable.reports, len(reports))
+    }
+  }
+}
+
+func TestSplitView(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  leaves := testLeaves(8)
+  forked := testLeaves(8)
+  forked[0] = forked[1]
+
+  gossip(t, mustConstruct(t, log.treeHead(t, timestamp, leaves, 8)))
+  //another timestamp, so the STHs do not share an identifier
+  gossip(t, log.sthPOC(t, timestamp+1, forked, 4, 8, consistencyProof(4, forked)))
+  gossip(t, mustConstruct(t, log.treeHead(t, timestamp+2, leaves, 8)))
+
+  poms := storedObjects(mtr.ConflictingSTHPOMTypeID, log.id)
+  if len(poms) != 2 {
+    t.Fatalf("Expected a PoM for each tree head with another root, got %v", len(poms))
+  }
+  for _, pom := range poms {
+    if err := ValidateSignature(pom); err != nil {
+      t.Errorf("The PoM does not validate: %v", err)
+    }
+  }
+}
+
+func TestSTHPOCValidator(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  leaves := testLeaves(8)
+  forked := testLeaves(8)
+  forked[0] = forked[1]
+  oldSTH := log.treeHead(t, timestamp, leaves, 3)
+  mustInconsistent := func(poc mtr.CTObject) mtr.CTObject {
+    pom, err := cto.CreateInconsistentSTHPOM(oldSTH, &poc)
+    if err != nil {
+      t.Fatal(err)
+    }
+    return *pom
+  }
+
+  runValidatorTests(t, []validatorTest{
+    {"STH_POC", log.sthPOC(t, timestamp+1, leaves, 3, 8, consistencyProof(3, leaves)), nil},
+    {"STH_POC under another subject", modified(log.sthPOC(t, timestamp+1, leaves, 3, 8, consistencyProof(3, leaves)), func(d *mtr.CTObject){ d.Subject = "elsewhere" }), ErrInconsistentObject},
+    {"InconsistentSTHPOM is not accepted from peers", mustInconsistent(log.sthPOC(t, timestamp+1, leaves, 3, 8, consistencyProof(3, forked))), errAny},
+    {"STH_POC ending at another size", mustConstruct(t, &mtr.SignedTreeHeadWithConsistencyProof{
+      SignedTreeHead: *log.treeHead(t, timestamp+1, leaves, 7),
+      ConsistencyProof: mtr.ConsistencyProofData{LogID: log.id, TreeSize1: 3, TreeSize2: 8, ConsistencyPath: consistencyProof(3, leaves)},
+    }), ErrInconsistentObject},
+    {"STH_POC of another log", mustConstruct(t, &mtr.SignedTreeHeadWithConsistencyProof{
+      SignedTreeHead: *log.treeHead(t, timestamp+1, leaves, 8),
+      ConsistencyProof: mtr.ConsistencyProofData{LogID: loggerSigner, TreeSize1: 3, TreeSize2: 8, ConsistencyPath: consistencyProof(3, leaves)},
+    }), ErrInconsistentObject},
+  })
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+  "bytes"
+  "encoding/base64"
+  "encoding/json"
+  "net/http"
+  "strconv"
+  "sync"
+  "sync/atomic"
+  "testing"
+
+  ct "github.com/google/certificate-transparency-go"
+  tls "github.com/google/certificate-transparency-go/tls"
+  mtr "github.com/n-ct/ct-monitor"
+  mtrList "github.com/n-ct/ct-monitor/entitylist"
+)
+
+// fakeLog is a log for the tests that serves get-sth, get-sth-consistency and get-proof-by-hash,
+// and counts the requests. It answers get-sth with the STH it is given, or an error when it has none.
+// The proofs are built from served, which differs from the tree of its STHs for a log that forked.
+type fakeLog struct{
+  served [][]byte
+  failFrom int //answer with an error to the requests for a consistency proof from this tree size
+  requests int32
+  fail int32 //answer every request with an error when set
+
+  mu sync.Mutex
+  sth *ct.GetSTHResponse
+}
+
+//setSTH makes the log answer get-sth with sth, or with an error when it is nil
+func (f *fakeLog) setSTH(t *testing.T, sth *mtr.SignedTreeHeadData){
+  var resp *ct.GetSTHResponse
+  if sth != nil {
+    r := getSTHResponse(t, sth)
+    resp = &r
+  }
+  f.mu.Lock()
+  f.sth = resp
+  f.mu.Unlock()
+}
+
+func (f *fakeLog) ServeHTTP(w http.ResponseWriter, req *http.Request){
+  atomic.AddInt32(&f.requests, 1)
+  if atomic.LoadInt32(&f.fail) != 0 {
+    http.Error(w, "unavailable", http.StatusServiceUnavailable)
+    return
+  }
+  switch req.URL.Path {
+  case ct.GetSTHPath:
+    f.mu.Lock()
+    sth := f.sth
+    f.mu.Unlock()
+    if sth == nil {
+      http.Error(w, "unavailable", http.StatusServiceUnavailable)
+      return
+    }
+    json.NewEncoder(w).Encode(sth)
+  case ct.GetSTHConsistencyPath:
+    f.serveConsistency(w, req)
+  case ct.GetProofByHashPath:
+    f.serveInclusion(w, req)
+  default:
+    http.Error(w, "unavailable", http.StatusServiceUnavailable)
+  }
+}
+
+func (f *fakeLog) serveConsistency(w http.ResponseWriter, req *http.Request){
+  first, err1 := strconv.Atoi(req.URL.Query().Get("first"))
+  second, err2 := strconv.Atoi(req.URL.Query().Get("second"))
+  if err1 != nil || err2 != nil || first > second || second > len(f.served) || (f.failFrom != 0 && first == f.failFrom) {
+    http.Error(w, "bad request", http.StatusBadRequest)
+    return
+  }
+  json.NewEncoder(w).Encode(ct.GetSTHConsistencyResponse{Consistency: consistencyProof(first, f.served[:second])})
+}
+
+func (f *fakeLog) serveInclusion(w http.ResponseWriter, req *http.Request){
+  hash, err1 := base64.StdEncoding.DecodeString(req.URL.Query().Get("hash"))
+  size, err2 := strconv.Atoi(req.URL.Query().Get("tree_size"))
+  if err1 != nil || err2 != nil || size > len(f.served) {
+    http.Error(w, "bad request", http.StatusBadRequest)
+    return
+  }
+  for i, leaf := range f.served[:size] {
+    if bytes.Equal(leaf, hash) {
+      json.NewEncoder(w).Encode(ct.GetProofByHashResponse{LeafIndex: int64(i), AuditPath: inclusionProof(i, f.served[:size])})
+      return
+    }
+  }
+  http.Error(w, "not found", http.StatusNotFound)
+}
+
+//getSTHResponse returns the get-sth response of a log for the STH
+func getSTHResponse(t *testing.T, sth *mtr.SignedTreeHeadData) ct.GetSTHResponse {
+  sig, err := tls.Marshal(sth.Signature)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return ct.GetSTHResponse{
+    TreeSize: sth.TreeHeadData.TreeSize,
+    Timestamp: sth.TreeHeadData.Timestamp,
+    SHA256RootHash: sth.TreeHeadData.SHA256RootHash[:],
+    TreeHeadSignature: sig,
+  }
+}
+
+//logInfoAt points the log of k at url
+func logInfoAt(t *testing.T, k *testKey, url string) *mtrList.LogInfo {
+  listsLock.Lock()
+  defer listsLock.Unlock()
+  info := allLogs.FindLogByLogID(k.id)
+  if info == nil {
+    t.Fatalf("log %v not in the log list", k.id)
+  }
+  info.URL = url
+  return info
+}
+
+//onlyLog leaves the log of k as the only log of the list, so that polls and audits of the whole list stay local
+func onlyLog(t *testing.T, k *testKey){
+  listsLock.Lock()
+  defer listsLock.Unlock()
+  info := allLogs.FindLogByLogID(k.id)
+  if info == nil {
+    t.Fatalf("log %v not in the log list", k.id)
+  }
+  allLogs = &mtrList.LogList{Operators: []*mtrList.Operator{{Name: "test", Logs: []*mtrList.LogInfo{info}}}}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+//kinds of feed events
+const (
+	EventObject = "object"
+	EventPoM = "pom"
+	EventAlert = "alert"
+	EventGap = "gap" //events after the cursor were lost, read the missing objects from the query API
+)
+
+const (
+	feedHistorySize = 1000 //events kept to resume from a cursor
+	feedSubscriberBuffer = 100 //events a slow subscriber can fall behind before it is disconnected
+	feedHeartbeat = 15 * time.Second
+)
+
+// FeedEvent is an object published on the feed. Its ID is the cursor to resume from,
+// made of the start time of this gossiper and a sequence number.
+type FeedEvent struct{
+	ID string `json:"id"`
+	Kind string `json:"kind"`
+	Object *mtr.CTObject `json:"object"`
+	seq uint64
+}
+
+// FeedFilter selects events, empty fields match everything.
+type FeedFilter struct{
+	TypeIDs map[string]bool
+	LogID string //matches the signer or subject of the object, and the cosignatures of the STHs of the log
+}
+
+func (f FeedFilter) matches(e *FeedEvent) bool {
+	if len(f.TypeIDs) != 0 && !f.TypeIDs[e.Object.TypeID] {
+		return false
+	}
+	if len(f.LogID) == 0 || e.Object.Signer == f.LogID || e.Object.Subject == f.LogID {
+		return true
+	}
+	return e.Object.TypeID == cto.STHCosignatureTypeID && strings.HasPrefix(e.Object.Subject, cto.CosignatureSubject(f.LogID, ""))
+}
+
+// Feed keeps the latest events and sends new ones to every subscriber.
+type Feed struct{
+	epoch string
+	mu sync.Mutex
+	seq uint64
+	history []*FeedEvent
+	subscribers map[chan *FeedEvent]bool
+	closed chan struct{}
+	closeOnce sync.Once
+}
+
+var feed = NewFeed();
+
+func NewFeed() *Feed {
+	return &Feed{epoch: strconv.FormatInt(time.Now().UnixNano(), 36), subscribers: make(map[chan *FeedEvent]bool), closed: make(chan struct{})}
+}
+
+func eventKind(data *mtr.CTObject) string {
+	switch data.TypeID {
+	case mtr.ConflictingSTHPOMTypeID, mtr.ConflictingSRDPOMTypeID, cto.MonitorEquivocationPOMTypeID, cto.InconsistentSTHPOMTypeID:
+		return EventPoM
+	case mtr.AlertTypeID:
+		return EventAlert
+	}
+	return EventObject
+}
+
+//Publish sends a newly stored object to the subscribers, disconnecting those that fall behind
+func (f *Feed) Publish(data *mtr.CTObject){
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	e := &FeedEvent{ID: fmt.Sprintf("%s-%d", f.epoch, f.seq), Kind: eventKind(data), Object: data, seq: f.seq}
+	f.history = append(f.history, e)
+	if len(f.history) > feedHistorySize {
+		f.history = f.history[len(f.history)-feedHistorySize:]
+	}
+	for ch := range f.subscribers {
+		select {
+		case ch <- e:
+		default:
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the events after cursor that are still in the history and a channel
+// receiving the new ones. gap is set when events after the cursor are no longer available.
+func (f *Feed) Subscribe(cursor string) (backlog []*FeedEvent, ch chan *FeedEvent, gap bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch = make(chan *FeedEvent, feedSubscriberBuffer)
+	f.subscribers[ch] = true
+	if len(cursor) == 0 {
+		return nil, ch, false
+	}
+
+	var after uint64
+	parts := strings.SplitN(cursor, "-", 2)
+	if len(parts) == 2 && parts[0] == f.epoch {
+		after, _ = strconv.ParseUint(parts[1], 10, 64)
+	} else {
+		gap = true //cursor from an earlier run, replay everything we have
+	}
+	if len(f.history) != 0 && f.history[0].seq > after+1 {
+		gap = true
+	}
+	for _, e := range f.history {
+		if e.seq > after {
+			backlog = append(backlog, e)
+		}
+	}
+	return backlog, ch, gap
+}
+
+//Unsubscribe stops sending events to ch
+func (f *Feed) Unsubscribe(ch chan *FeedEvent){
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subscribers[ch] {
+		delete(f.subscribers, ch)
+		close(ch)
+	}
+}
+
+//Close ends every stream, used on shutdown
+func (f *Feed) Close(){
+	f.closeOnce.Do(func(){ close(f.closed) })
+}
+
+//parseFeedFilter reads the type and log parameters, type can be repeated or comma separated
+func parseFeedFilter(req *http.Request) FeedFilter {
+	params := req.URL.Query()
+	filter := FeedFilter{LogID: params.Get("log")}
+	for _, value := range params["type"] {
+		for _, typeID := range strings.Split(value, ",") {
+			if typeID = strings.TrimSpace(typeID); len(typeID) != 0 {
+				if filter.TypeIDs == nil {
+					filter.TypeIDs = make(map[string]bool)
+				}
+				filter.TypeIDs[typeID] = true
+			}
+		}
+	}
+	return filter
+}
+
+//writeEvent writes one event in the Server-Sent Events format
+func writeEvent(w http.ResponseWriter, id string, kind string, v interface{}) error {
+	jsonStr, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(id) != 0 {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", kind, jsonStr)
+	return err
+}
+
+// FeedHandler streams newly stored objects, PoMs and alerts as Server-Sent Events.
+// The type and log parameters filter the events. A client resumes after a disconnect
+// with the Last-Event-ID header or the cursor parameter. The write deadline of the
+// connection is pushed forward before each event and heartbeat, so the stream stays
+// open past the server write timeout.
+func FeedHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	filter := parseFeedFilter(req)
+	cursor := req.Header.Get("Last-Event-ID")
+	if len(cursor) == 0 {
+		cursor = req.URL.Query().Get("cursor")
+	}
+	backlog, ch, gap := feed.Subscribe(cursor)
+	defer feed.Unsubscribe(ch)
+
+	var writeTimeout time.Duration
+	if config := getConfig(); config != nil {
+		writeTimeout = cto.Milliseconds(config.Timeouts.Write_ms)
+	}
+	controller := http.NewResponseController(w)
+	extendDeadline := func(){
+		if writeTimeout > 0 {
+			controller.SetWriteDeadline(time.Now().Add(writeTimeout))
+		}
+	}
+
+	extendDeadline()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "retry: 1000\n\n")
+	if gap {
+		writeEvent(w, "", EventGap, map[string]string{"cursor": cursor})
+	}
+	for _, e := range backlog {
+		if filter.matches(e) {
+			if err := writeEvent(w, e.ID, e.Kind, e); err != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				glog.Infof("Feed subscriber %v fell behind, disconnecting\n", req.RemoteAddr)
+				return
+			}
+			if !filter.matches(e) {
+				continue
+			}
+			extendDeadline()
+			if err := writeEvent(w, e.ID, e.Kind, e); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			extendDeadline()
+			if _, err := fmt.Fprintf(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-feed.closed:
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
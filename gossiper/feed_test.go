@@ -0,0 +1,175 @@
+package main
+
+import (
+  "bufio"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+
+  cto "github.com/n-ct/ct-gossiper"
+  mtr "github.com/n-ct/ct-monitor"
+)
+
+//readEvent returns the id and event lines of the next event on the stream, skipping comments and retry
+func readEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+  var id, kind string
+  for {
+    line, err := reader.ReadString('\n')
+    if err != nil {
+      t.Fatalf("Stream ended: %v", err)
+    }
+    line = strings.TrimSuffix(line, "\n")
+    switch {
+    case strings.HasPrefix(line, "id: "):
+      id = strings.TrimPrefix(line, "id: ")
+    case strings.HasPrefix(line, "event: "):
+      kind = strings.TrimPrefix(line, "event: ")
+    case len(line) == 0 && len(kind) != 0:
+      return id, kind
+    }
+  }
+}
+
+func openFeed(t *testing.T, url string, lastEventID string) (*http.Response, *bufio.Reader) {
+  req, _ := http.NewRequest("GET", url, nil)
+  if len(lastEventID) != 0 {
+    req.Header.Set("Last-Event-ID", lastEventID)
+  }
+  resp, err := http.DefaultClient.Do(req)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if resp.Header.Get("Content-Type") != "text/event-stream" {
+    t.Fatalf("Unexpected content type %v", resp.Header.Get("Content-Type"))
+  }
+  return resp, bufio.NewReader(resp.Body)
+}
+
+//waitForSubscribers waits until the feed has n subscribers, so objects stored afterwards are streamed
+func waitForSubscribers(t *testing.T, n int){
+  for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+    feed.mu.Lock()
+    count := len(feed.subscribers)
+    feed.mu.Unlock()
+    if count == n {
+      return
+    }
+  }
+  t.Fatalf("Expected %v feed subscribers", n)
+}
+
+func TestFeedHandler(t *testing.T){
+  mustGossiperSetup(t)
+  feed = NewFeed()
+  server := httptest.NewServer(http.HandlerFunc(FeedHandler))
+  defer server.Close()
+
+  resp, reader := openFeed(t, server.URL+"?type="+mtr.STHTypeID+","+mtr.AlertTypeID+","+cto.STHCosignatureTypeID+"&log=logA", "")
+  waitForSubscribers(t, 1)
+  objects := []mtr.CTObject{
+    {TypeID: mtr.STHTypeID, Version: version, Timestamp: 1, Signer: "logB"},
+    {TypeID: mtr.STHPOCTypeID, Version: version, Timestamp: 2, Signer: "logA"},
+    {TypeID: mtr.STHTypeID, Version: version, Timestamp: 3, Signer: "logA"},
+    {TypeID: mtr.AlertTypeID, Version: version, Timestamp: 4, Signer: "monitor2", Subject: "logA"},
+    {TypeID: cto.STHCosignatureTypeID, Version: version, Timestamp: 5, Signer: "witness1", Subject: cto.CosignatureSubject("logB", "witness1")},
+    {TypeID: cto.STHCosignatureTypeID, Version: version, Timestamp: 6, Signer: "witness1", Subject: cto.CosignatureSubject("logA", "witness1")},
+  }
+  for _, data := range objects {
+    storeEntry(workingMapFor(&data), data, data.Identifier())
+  }
+  firstID, kind := readEvent(t, reader)
+  if kind != EventObject {
+    t.Errorf("Expected the STH of logA, got %v", kind)
+  }
+  if _, kind := readEvent(t, reader); kind != EventAlert {
+    t.Errorf("Expected the alert about logA, got %v", kind)
+  }
+  if _, kind := readEvent(t, reader); kind != EventObject {
+    t.Errorf("Expected the cosignature of the STH of logA, got %v", kind)
+  }
+  resp.Body.Close()
+
+  //resume after the first event, the alert is replayed from the history
+  resp, reader = openFeed(t, server.URL+"?log=logA", firstID)
+  defer resp.Body.Close()
+  if _, kind := readEvent(t, reader); kind != EventAlert {
+    t.Errorf("Expected the alert to be replayed, got %v", kind)
+  }
+}
+
+//TestFeedWriteTimeout checks that a stream stays open past the server write timeout
+func TestFeedWriteTimeout(t *testing.T){
+  mustGossiperSetup(t)
+  feed = NewFeed()
+  config := *gossipConfig
+  config.Timeouts.Write_ms = 200
+  listsLock.Lock()
+  saved := gossipConfig
+  gossipConfig = &config
+  listsLock.Unlock()
+  defer func(){
+    listsLock.Lock()
+    gossipConfig = saved
+    listsLock.Unlock()
+  }()
+  server := httptest.NewUnstartedServer(http.HandlerFunc(FeedHandler))
+  server.Config.WriteTimeout = cto.Milliseconds(config.Timeouts.Write_ms)
+  server.Start()
+  defer server.Close()
+
+  resp, reader := openFeed(t, server.URL, "")
+  defer resp.Body.Close()
+  waitForSubscribers(t, 1)
+  time.Sleep(2 * server.Config.WriteTimeout)
+  data := mtr.CTObject{TypeID: mtr.STHTypeID, Version: version, Timestamp: 1, Signer: "logA"}
+  storeEntry(workingMapFor(&data), data, data.Identifier())
+  if _, kind := readEvent(t, reader); kind != EventObject {
+    t.Errorf("Expected the STH after the write timeout, got %v", kind)
+  }
+}
+
+func TestEventKind(t *testing.T){
+  testTables := []struct {
+    typeID string
+    kind string
+  }{
+    {mtr.STHTypeID, EventObject},
+    {mtr.AlertTypeID, EventAlert},
+    {mtr.ConflictingSTHPOMTypeID, EventPoM},
+    {cto.MonitorEquivocationPOMTypeID, EventPoM},
+    {mtr.ConflictingSRDPOMTypeID, EventPoM},
+    {cto.InconsistentSTHPOMTypeID, EventPoM},
+  }
+  for _, testTable := range testTables{
+    if kind := eventKind(&mtr.CTObject{TypeID: testTable.typeID}); kind != testTable.kind {
+      t.Errorf("%v: got %v want %v", testTable.typeID, kind, testTable.kind)
+    }
+  }
+}
+
+func TestFeedSubscribeGap(t *testing.T){
+  f := NewFeed()
+  for i := 0; i < feedHistorySize+10; i++ {
+    f.Publish(&mtr.CTObject{TypeID: mtr.STHTypeID, Timestamp: uint64(i)})
+  }
+  testTables := []struct {
+    cursor string
+    backlog int
+    gap bool
+  }{
+    {"", 0, false},
+    {fmt.Sprintf("%s-%d", f.epoch, feedHistorySize+5), 5, false},
+    {fmt.Sprintf("%s-%d", f.epoch, 1), feedHistorySize, true},
+    {"earlier-run-5", feedHistorySize, true},
+  }
+  for _, testTable := range testTables{
+    backlog, ch, gap := f.Subscribe(testTable.cursor)
+    f.Unsubscribe(ch)
+    if len(backlog) != testTable.backlog || gap != testTable.gap {
+      t.Errorf("Subscribe(%q) returned %v events and gap %v, want %v and %v", testTable.cursor, len(backlog), gap, testTable.backlog, testTable.gap)
+    }
+  }
+}
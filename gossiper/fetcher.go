@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+	mtrList "github.com/n-ct/ct-monitor/entitylist"
+)
+
+const fetchTick = time.Second //how often the fetcher looks for logs that are due
+
+const FetchError = "error" //outcome label of a poll that got no STH from the log
+
+var fetcher *Fetcher;
+
+// Fetcher polls get-sth on the usable logs of the log list. Each STH goes through the same
+// validation, conflict resolution and gossip as an STH posted to /ct/v1/gossip.
+// A log that cannot be reached is polled again after a wait that doubles with each failure.
+type Fetcher struct{
+	config cto.FetcherConfig
+
+	mu sync.Mutex
+	polls map[string]*logPoll //[LogID]
+	stop chan struct{}
+	running sync.WaitGroup //the background loop and the polls it started
+}
+
+// logPoll is when a log is polled next.
+type logPoll struct{
+	next time.Time
+	failures int //consecutive failed polls
+	inFlight bool
+}
+
+//NewFetcher creates a fetcher from the fetcher section of the configuration
+func NewFetcher(config cto.FetcherConfig) *Fetcher {
+	return &Fetcher{config: config, polls: make(map[string]*logPoll), stop: make(chan struct{})}
+}
+
+//Start polls the logs that are due in the background
+func (f *Fetcher) Start(){
+	f.running.Add(1)
+	go func(){
+		defer f.running.Done()
+		ticker := time.NewTicker(fetchTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				f.FetchDue(now)
+			case <-f.stop:
+				return
+			}
+		}
+	}()
+}
+
+//Stop ends the background polls and waits for the polls in flight
+func (f *Fetcher) Stop(){
+	close(f.stop)
+	f.running.Wait()
+}
+
+// FetchDue starts a poll of every usable log whose next poll is at or before now. It does not wait
+// for the polls, a log whose poll is still in flight is not claimed again, so a slow log does not
+// hold up the others.
+func (f *Fetcher) FetchDue(now time.Time){
+	for _, op := range getLogs().Operators {
+		for _, info := range op.Logs {
+			if !usableLog(info) || !f.claim(info.LogID, now) {
+				continue
+			}
+			f.running.Add(1)
+			go func(info *mtrList.LogInfo){
+				defer f.running.Done()
+				err := f.FetchLog(info)
+				if err != nil {
+					glog.Errorf("Polling log %v: %v\n", info.LogID, err)
+				}
+				f.schedule(info.LogID, time.Now(), err)
+			}(info)
+		}
+	}
+}
+
+// FetchLog gets the current STH of the log and handles it like an STH received from a peer.
+// It returns an error if the log could not be reached, an STH that is a duplicate or invalid is not an error.
+func (f *Fetcher) FetchLog(info *mtrList.LogInfo) error {
+	client, err := mtr.NewLogClient(info)
+	if err != nil {
+		sthFetches.WithLabelValues(info.LogID, FetchError).Inc()
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cto.Milliseconds(f.config.Timeout_ms))
+	defer cancel()
+	data, err := client.GetSTH(ctx)
+	if err != nil {
+		sthFetches.WithLabelValues(info.LogID, FetchError).Inc()
+		return err
+	}
+
+	record := &AuditRecord{Time: time.Now().UTC(), Sender: info.LogID, RemoteAddr: info.URL}
+	defer writeAudit(record)
+	ingestObject(record, data, "", "") //the log is not a peer, it is not scored
+	sthFetches.WithLabelValues(info.LogID, record.Outcome).Inc()
+	return nil
+}
+
+//claim returns true and marks the poll of the log in flight if it is due at now
+func (f *Fetcher) claim(logID string, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	poll, ok := f.polls[logID]
+	if !ok {
+		poll = &logPoll{}
+		f.polls[logID] = poll
+	}
+	if poll.inFlight || now.Before(poll.next) {
+		return false
+	}
+	poll.inFlight = true
+	return true
+}
+
+//schedule sets the next poll of the log after a poll that ended at now with err
+func (f *Fetcher) schedule(logID string, now time.Time, err error){
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	poll, ok := f.polls[logID]
+	if !ok {
+		poll = &logPoll{}
+		f.polls[logID] = poll
+	}
+	poll.inFlight = false
+	if err == nil {
+		poll.failures = 0
+	} else {
+		poll.failures++
+	}
+	poll.next = now.Add(f.wait(logID, poll.failures))
+}
+
+//wait returns the time until the next poll of the log after failures consecutive failed polls
+func (f *Fetcher) wait(logID string, failures int) time.Duration {
+	interval := cto.Milliseconds(f.config.Interval_ms)
+	if logInterval, ok := f.config.Log_intervals_ms[logID]; ok && logInterval > 0 {
+		interval = cto.Milliseconds(logInterval)
+	}
+	maxBackoff := cto.Milliseconds(f.config.Max_backoff_ms)
+	for i := 0; i < failures && interval < maxBackoff; i++ {
+		interval *= 2
+	}
+	if failures > 0 && interval > maxBackoff {
+		interval = maxBackoff
+	}
+	return interval
+}
+
+//usableLog returns true for a log with a URL that the log list does not mark pending, retired or rejected
+func usableLog(info *mtrList.LogInfo) bool {
+	if len(info.URL) == 0 {
+		return false
+	}
+	if info.State == nil {
+		return true
+	}
+	return info.State.Pending == nil && info.State.Retired == nil && info.State.Rejected == nil
+}
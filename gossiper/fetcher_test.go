@@ -0,0 +1,143 @@
+package main
+
+import (
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "sync/atomic"
+  "testing"
+  "time"
+
+  cto "github.com/n-ct/ct-gossiper"
+  mtr "github.com/n-ct/ct-monitor"
+  mtrList "github.com/n-ct/ct-monitor/entitylist"
+)
+
+func TestFetcherFetchLog(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  other := newTestKey(t)
+  fake := &fakeLog{}
+  server := httptest.NewServer(fake)
+  defer server.Close()
+  info := logInfoAt(t, log, server.URL)
+  f := NewFetcher(cto.FetcherConfig{Interval_ms: 1000, Timeout_ms: 1000, Max_backoff_ms: 8000})
+
+  testTables := []struct {
+    name string
+    sth *mtr.SignedTreeHeadData
+    fails bool
+    sths int
+    poms int
+  }{
+    {"log unavailable", nil, true, 0, 0},
+    {"new STH", log.signedTreeHead(t, timestamp, 10, 1), false, 1, 0},
+    {"same STH again", log.signedTreeHead(t, timestamp, 10, 1), false, 1, 0},
+    {"newer STH", log.signedTreeHead(t, timestamp+1, 12, 2), false, 2, 0},
+    {"STH signed with another key", other.signedTreeHead(t, timestamp+2, 14, 3), false, 2, 0},
+    {"conflicting STH", log.signedTreeHead(t, timestamp+1, 12, 3), false, 2, 1},
+  }
+  for _, testTable := range testTables{
+    fake.setSTH(t, testTable.sth)
+    if err := f.FetchLog(info); (err != nil) != testTable.fails {
+      t.Errorf("%v: FetchLog returned %v", testTable.name, err)
+    }
+    if sths := len(storedObjects(mtr.STHTypeID, log.id)); sths != testTable.sths {
+      t.Errorf("%v: expected %v stored STHs, got %v", testTable.name, testTable.sths, sths)
+    }
+    if poms := len(storedObjects(mtr.ConflictingSTHPOMTypeID, log.id)); poms != testTable.poms {
+      t.Errorf("%v: expected %v PoMs, got %v", testTable.name, testTable.poms, poms)
+    }
+  }
+}
+
+func TestFetcherSchedule(t *testing.T){
+  f := NewFetcher(cto.FetcherConfig{Interval_ms: 1000, Log_intervals_ms: map[string]int{"fast": 250}, Max_backoff_ms: 3000})
+  now := time.Now()
+
+  testTables := []struct {
+    name string
+    logID string
+    failures int
+    wait time.Duration
+  }{
+    {"default interval", "log", 0, time.Second},
+    {"log interval", "fast", 0, 250 * time.Millisecond},
+    {"one failure", "log", 1, 2 * time.Second},
+    {"one failure of a log with its own interval", "fast", 1, 500 * time.Millisecond},
+    {"capped backoff", "log", 2, 3 * time.Second},
+    {"many failures", "log", 100, 3 * time.Second},
+  }
+  for _, testTable := range testTables{
+    if wait := f.wait(testTable.logID, testTable.failures); wait != testTable.wait {
+      t.Errorf("%v: expected a wait of %v, got %v", testTable.name, testTable.wait, wait)
+    }
+  }
+
+  if !f.claim("log", now) {
+    t.Fatalf("Expected a log never polled to be due")
+  }
+  if f.claim("log", now) {
+    t.Errorf("Expected a log being polled not to be polled again")
+  }
+  f.schedule("log", now, errors.New("unavailable"))
+  if f.claim("log", now.Add(1999 * time.Millisecond)) {
+    t.Errorf("Expected the log to wait 2s after a failed poll")
+  }
+  if !f.claim("log", now.Add(2 * time.Second)) {
+    t.Errorf("Expected the log to be due 2s after a failed poll")
+  }
+  f.schedule("log", now, nil)
+  if f.claim("log", now.Add(999 * time.Millisecond)) || !f.claim("log", now.Add(time.Second)) {
+    t.Errorf("Expected a successful poll to reset the backoff")
+  }
+}
+
+func TestUsableLog(t *testing.T){
+  testTables := []struct {
+    name string
+    info mtrList.LogInfo
+    usable bool
+  }{
+    {"no state", mtrList.LogInfo{URL: "https://log.example/"}, true},
+    {"no URL", mtrList.LogInfo{}, false},
+    {"usable", mtrList.LogInfo{URL: "https://log.example/", State: &mtrList.LogStates{Usable: &mtrList.LogState{}}}, true},
+    {"read only", mtrList.LogInfo{URL: "https://log.example/", State: &mtrList.LogStates{ReadOnly: &mtrList.ReadOnlyLogState{}}}, true},
+    {"pending", mtrList.LogInfo{URL: "https://log.example/", State: &mtrList.LogStates{Pending: &mtrList.LogState{}}}, false},
+    {"retired", mtrList.LogInfo{URL: "https://log.example/", State: &mtrList.LogStates{Retired: &mtrList.LogState{}}}, false},
+    {"rejected", mtrList.LogInfo{URL: "https://log.example/", State: &mtrList.LogStates{Rejected: &mtrList.LogState{}}}, false},
+  }
+  for _, testTable := range testTables{
+    if usable := usableLog(&testTable.info); usable != testTable.usable {
+      t.Errorf("%v: expected usable %v, got %v", testTable.name, testTable.usable, usable)
+    }
+  }
+}
+
+func TestFetcherFetchDue(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  fake := &fakeLog{}
+  fake.setSTH(t, log.signedTreeHead(t, timestamp, 10, 1))
+  release := make(chan struct{})
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request){
+    <-release
+    fake.ServeHTTP(w, req)
+  }))
+  defer server.Close()
+  logInfoAt(t, log, server.URL)
+  onlyLog(t, log)
+  f := NewFetcher(cto.FetcherConfig{Interval_ms: 1000, Timeout_ms: 5000, Max_backoff_ms: 8000})
+
+  now := time.Now()
+  f.FetchDue(now) //returns while the log has not answered
+  f.FetchDue(now.Add(time.Hour)) //the poll is in flight, the log is not polled again
+  close(release)
+  f.Stop() //waits for the poll, so nothing outlives the test
+  if len(storedObjects(mtr.STHTypeID, log.id)) == 0 {
+    t.Errorf("The STH of the log was not stored")
+  }
+  if requests := atomic.LoadInt32(&fake.requests); requests != 1 {
+    t.Errorf("Expected 1 request to the log, got %v", requests)
+  }
+}
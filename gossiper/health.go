@@ -0,0 +1,243 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	cto "github.com/n-ct/ct-gossiper"
+)
+
+// Version is reported by /status, set at build time with -ldflags "-X main.Version=..."
+var Version = "dev"
+
+var startTime = time.Now()
+
+const monitorCheckInterval = 10 * time.Second //how often CheckMonitor dials our monitor
+
+// Exchange is the outcome of the latest exchanges with a peer or the monitor.
+type Exchange struct{
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+//Reachable reports whether the latest exchange succeeded, false if there was none
+func (e Exchange) Reachable() bool {
+	return e.LastSuccess != nil && (e.LastFailure == nil || e.LastSuccess.After(*e.LastFailure))
+}
+
+var exchanges = make(map[string]Exchange); //[MonitorID or monitorQueue]
+var exchangesLock sync.Mutex;
+
+//recordExchange records the outcome of a request to or from the named peer, or to the monitor
+func recordExchange(name string, err error){
+	if len(name) == 0 {
+		return
+	}
+	now := time.Now()
+	exchangesLock.Lock()
+	defer exchangesLock.Unlock()
+	e := exchanges[name]
+	if err != nil {
+		e.LastFailure = &now
+		e.LastError = err.Error()
+	} else {
+		e.LastSuccess = &now
+	}
+	exchanges[name] = e
+}
+
+func getExchange(name string) Exchange {
+	exchangesLock.Lock()
+	defer exchangesLock.Unlock()
+	return exchanges[name]
+}
+
+// PeerStatus describes one peer in the status report.
+type PeerStatus struct{
+	MonitorID string `json:"monitor_id"`
+	GossiperURL string `json:"gossiper_url"`
+	Reachable bool `json:"reachable"`
+	Paused bool `json:"paused"`
+	Untrusted bool `json:"untrusted"` //a stored PoM proves the monitor equivocated
+	Exchange
+}
+
+// MonitorStatus describes our monitor in the status report.
+type MonitorStatus struct{
+	MonitorID string `json:"monitor_id"`
+	MonitorURL string `json:"monitor_url"`
+	Reachable bool `json:"reachable"`
+	Checked *time.Time `json:"checked,omitempty"` //time of the check that set reachable, none before the first check
+	Untrusted bool `json:"untrusted"`
+	Exchange
+}
+
+// Status is the report served on /status.
+type Status struct{
+	Version string `json:"version"`
+	Started time.Time `json:"started"`
+	UptimeSeconds int64 `json:"uptime_seconds"`
+	Ready bool `json:"ready"`
+	AdvertisedURL string `json:"advertised_url"`
+	Logs int `json:"logs"`
+	Monitors int `json:"monitors"`
+	StoredObjects int `json:"stored_objects"`
+	Quarantined int `json:"quarantined"`
+	UnresolvedConflicts int `json:"unresolved_conflicts"`
+	UntrustedMonitors []string `json:"untrusted_monitors"` //monitors proven to sign contradictory alerts
+	Peers []PeerStatus `json:"peers"`
+	Monitor MonitorStatus `json:"monitor"`
+}
+
+func getMyAddress() string {
+	listsLock.RLock()
+	defer listsLock.RUnlock()
+	return myAddress
+}
+
+//ready reports whether the configuration and both lists are loaded and shutdown has not started
+func ready() bool {
+	if isShuttingDown() {
+		return false
+	}
+	listsLock.RLock()
+	defer listsLock.RUnlock()
+	return gossipConfig != nil && allLogs != nil && allMonitors != nil
+}
+
+//monitorReachable dials our monitor to check that it is up
+func monitorReachable(monitorURL string, timeout time.Duration) bool {
+	address, err := hostPort(monitorURL)
+	if err != nil {
+		return false
+	}
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// monitorCheck is the outcome of the latest periodic check of our monitor. It is kept so that
+// /status, which anyone can request, never opens a connection itself.
+type monitorCheck struct{
+	url string
+	reachable bool
+	checked time.Time
+}
+
+var lastMonitorCheck monitorCheck;
+var monitorCheckLock sync.Mutex;
+
+//CheckMonitor checks that our monitor is reachable every interval until stop is closed
+func CheckMonitor(interval time.Duration, stop <-chan struct{}){
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		checkMonitor()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+//checkMonitor dials our monitor and records the outcome for the status report
+func checkMonitor(){
+	config, monitors := getConfig(), getMonitors()
+	if config == nil || monitors == nil {
+		return
+	}
+	monitor := monitors.FindMonitorByMonitorID(config.Monitor_id)
+	if monitor == nil {
+		return
+	}
+	reachable := monitorReachable(monitor.MonitorURL, cto.Milliseconds(config.Timeouts.Monitor_dial_ms))
+	monitorCheckLock.Lock()
+	lastMonitorCheck = monitorCheck{url: monitor.MonitorURL, reachable: reachable, checked: time.Now()}
+	monitorCheckLock.Unlock()
+}
+
+//untrustedMonitors returns the monitors a stored MonitorEquivocationPOM is about. Must be called with storeLock held
+func untrustedMonitors() map[string]bool {
+	untrusted := make(map[string]bool)
+	for monitorID := range messages[cto.MonitorEquivocationPOMTypeID] {
+		untrusted[monitorID] = true
+	}
+	return untrusted
+}
+
+//GetStatus builds the status report
+func GetStatus() Status {
+	status := Status{
+		Version: Version,
+		Started: startTime,
+		UptimeSeconds: int64(time.Since(startTime) / time.Second),
+		Ready: ready(),
+		AdvertisedURL: getMyAddress(),
+		Quarantined: quarantineSize(),
+		UnresolvedConflicts: len(UnresolvedConflicts()),
+		Peers: []PeerStatus{},
+		UntrustedMonitors: []string{},
+	}
+	if !status.Ready {
+		return status
+	}
+	config, logs, monitors := getConfig(), getLogs(), getMonitors()
+	status.Logs = countLogs(logs)
+	status.Monitors = countMonitors(monitors)
+	storeLock.RLock()
+	status.StoredObjects = countEntries(messages) + countEntries(alertsMap)
+	untrusted := untrustedMonitors()
+	storeLock.RUnlock()
+	for monitorID := range untrusted {
+		status.UntrustedMonitors = append(status.UntrustedMonitors, monitorID)
+	}
+	sort.Strings(status.UntrustedMonitors)
+
+	for _, peer := range getPeers() {
+		e := getExchange(peer.MonitorID)
+		status.Peers = append(status.Peers, PeerStatus{MonitorID: peer.MonitorID, GossiperURL: peer.GossiperURL, Reachable: e.Reachable(), Paused: isPaused(peer.MonitorID), Untrusted: untrusted[peer.MonitorID], Exchange: e})
+	}
+	sort.Slice(status.Peers, func(i, j int) bool { return status.Peers[i].MonitorID < status.Peers[j].MonitorID })
+
+	status.Monitor = MonitorStatus{MonitorID: config.Monitor_id, Untrusted: untrusted[config.Monitor_id], Exchange: getExchange(monitorQueue)}
+	if monitor := monitors.FindMonitorByMonitorID(config.Monitor_id); monitor != nil {
+		status.Monitor.MonitorURL = monitor.MonitorURL
+		monitorCheckLock.Lock()
+		if check := lastMonitorCheck; check.url == monitor.MonitorURL {
+			status.Monitor.Reachable, status.Monitor.Checked = check.reachable, &check.checked
+		}
+		monitorCheckLock.Unlock()
+	}
+	return status
+}
+
+//HealthzHandler answers as long as the server is running
+func HealthzHandler(w http.ResponseWriter, req *http.Request){
+	w.Write([]byte("ok"))
+}
+
+//ReadyzHandler answers 503 until the configuration and lists are loaded, and again once shutdown starts
+func ReadyzHandler(w http.ResponseWriter, req *http.Request){
+	if !ready() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+//StatusHandler reports the state of the gossiper, its peers and its monitor
+func StatusHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, GetStatus())
+}
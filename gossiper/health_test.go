@@ -0,0 +1,119 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+
+  mtrList "github.com/n-ct/ct-monitor/entitylist"
+)
+
+func TestReadyzHandler(t *testing.T){
+  listsLock.Lock()
+  savedConfig := gossipConfig
+  gossipConfig = nil
+  listsLock.Unlock()
+
+  recorder := httptest.NewRecorder()
+  ReadyzHandler(recorder, httptest.NewRequest("GET", "/readyz", nil))
+  if recorder.Code != http.StatusServiceUnavailable {
+    t.Errorf("Expected 503 before setup, got %v", recorder.Code)
+  }
+
+  listsLock.Lock()
+  gossipConfig = savedConfig
+  listsLock.Unlock()
+  mustGossiperSetup(t)
+  recorder = httptest.NewRecorder()
+  ReadyzHandler(recorder, httptest.NewRequest("GET", "/readyz", nil))
+  if recorder.Code != http.StatusOK {
+    t.Errorf("Expected 200 after setup, got %v", recorder.Code)
+  }
+}
+
+func TestStatusHandler(t *testing.T){
+  mustGossiperSetup(t)
+  recordExchange("monitor2", errors.New("connection refused"))
+  recordExchange("monitor2", nil)
+
+  recorder := httptest.NewRecorder()
+  StatusHandler(recorder, httptest.NewRequest("GET", "/status", nil))
+  var status Status
+  if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+    t.Fatal(err)
+  }
+  if !status.Ready || status.Logs == 0 || status.Monitors != 2 {
+    t.Errorf("Unexpected status %+v", status)
+  }
+  if len(status.Peers) != 1 || status.Peers[0].MonitorID != "monitor2" || !status.Peers[0].Reachable {
+    t.Errorf("Expected monitor2 to be reachable, got %+v", status.Peers)
+  }
+  if status.Monitor.MonitorID != "monitor1" || status.Monitor.Reachable {
+    t.Errorf("Expected monitor1 to be unreachable, got %+v", status.Monitor)
+  }
+
+  recordExchange("monitor2", errors.New("timeout"))
+  if getExchange("monitor2").Reachable() {
+    t.Errorf("Expected monitor2 to be unreachable after a failure")
+  }
+}
+
+func TestStatusUntrustedMonitor(t *testing.T){
+  mustGossiperSetup(t)
+  monitor := addTestMonitor(t)
+  alert1 := monitor.alert(t, "LoggerNonResponsive", loggerSigner, timestamp)
+  alert2 := monitor.alert(t, "LoggerResponsive", loggerSigner, timestamp)
+  if status := GetStatus(); len(status.UntrustedMonitors) != 0 {
+    t.Fatalf("Expected no untrusted monitor, got %v", status.UntrustedMonitors)
+  }
+
+  gossip(t, alert1)
+  gossip(t, alert2)
+  status := GetStatus()
+  if len(status.UntrustedMonitors) != 1 || status.UntrustedMonitors[0] != monitor.id {
+    t.Errorf("Expected %v to be untrusted, got %v", monitor.id, status.UntrustedMonitors)
+  }
+  if status.Peers[0].Untrusted || status.Monitor.Untrusted {
+    t.Errorf("Expected only %v to be untrusted: %+v %+v", monitor.id, status.Peers, status.Monitor)
+  }
+  view := GetLogView(getLogs().FindLogByLogID(loggerSigner), false)
+  if len(view.Alerts) != 1 || !view.Alerts[0].Untrusted {
+    t.Errorf("Expected the alert to be marked untrusted: %+v", view.Alerts)
+  }
+
+  //a gossiper that did not see the alerts marks the monitor once it receives the proof
+  pom := mustEquivocation(t, alert1, alert2)
+  mustGossiperSetup(t)
+  listsLock.Lock()
+  allMonitors.MonitorOperators = append(allMonitors.MonitorOperators, &mtrList.MonitorOperator{Name: "test", Monitors: []*mtrList.MonitorInfo{{MonitorID: monitor.id, MonitorKey: monitor.key}}})
+  listsLock.Unlock()
+  if response := gossip(t, pom); response != "new data" {
+    t.Fatalf("Expected the proof to be stored, got %q", response)
+  }
+  if status := GetStatus(); len(status.UntrustedMonitors) != 1 || status.UntrustedMonitors[0] != monitor.id {
+    t.Errorf("Expected the proof to mark %v as untrusted, got %v", monitor.id, status.UntrustedMonitors)
+  }
+}
+
+func TestStatusMonitorCheck(t *testing.T){
+  mustGossiperSetup(t)
+  server := httptest.NewServer(http.NotFoundHandler())
+  listsLock.Lock()
+  allMonitors.FindMonitorByMonitorID("monitor1").MonitorURL = server.URL
+  listsLock.Unlock()
+
+  if status := GetStatus(); status.Monitor.Reachable || status.Monitor.Checked != nil {
+    t.Errorf("Expected the monitor not to be checked yet, got %+v", status.Monitor)
+  }
+  checkMonitor()
+  server.Close()
+  if status := GetStatus(); !status.Monitor.Reachable || status.Monitor.Checked == nil {
+    t.Errorf("Expected the status to report the last check, got %+v", status.Monitor)
+  }
+  checkMonitor()
+  if status := GetStatus(); status.Monitor.Reachable {
+    t.Errorf("Expected the monitor to be unreachable after the next check, got %+v", status.Monitor)
+  }
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+	mtrList "github.com/n-ct/ct-monitor/entitylist"
+)
+
+// STHSummary is the part of a verified STH shown in the log view.
+type STHSummary struct{
+	TreeSize uint64 `json:"tree_size"`
+	Timestamp uint64 `json:"timestamp"`
+	RootHash string `json:"root_hash"` //base64
+}
+
+// ObjectRef identifies a stored object, it can be fetched from the object endpoint.
+type ObjectRef struct{
+	TypeID string `json:"type"`
+	Signer string `json:"signer,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Timestamp uint64 `json:"timestamp"`
+	Version string `json:"version"`
+	Untrusted bool `json:"untrusted,omitempty"` //the signer is a monitor proven to equivocate
+}
+
+// LogView is what this gossiper knows about one log.
+type LogView struct{
+	LogID string `json:"log_id"`
+	Description string `json:"description,omitempty"`
+	URL string `json:"url"`
+	LatestSTH *STHSummary `json:"latest_sth,omitempty"`
+	STHCount int `json:"sth_count"`
+	FirstSeen *time.Time `json:"first_seen,omitempty"` //when this gossiper first stored an STH of the log since it started
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	PoMs []ObjectRef `json:"poms"`
+	Alerts []ObjectRef `json:"alerts"`
+	History []STHSummary `json:"history,omitempty"` //every stored STH by timestamp, only when a single log is requested
+}
+
+type seenTimes struct{
+	first time.Time
+	last time.Time
+}
+
+var logSeen = make(map[string]seenTimes); //[LogID]
+var logSeenLock sync.Mutex;
+
+//noteStored records when an STH of a log was stored
+func noteStored(data *mtr.CTObject){
+	if data.TypeID != mtr.STHTypeID && data.TypeID != mtr.STHPOCTypeID {
+		return
+	}
+	now := time.Now()
+	logSeenLock.Lock()
+	defer logSeenLock.Unlock()
+	seen, ok := logSeen[data.Signer]
+	if !ok {
+		seen.first = now
+	}
+	seen.last = now
+	logSeen[data.Signer] = seen
+}
+
+func refTo(data *mtr.CTObject) ObjectRef {
+	return ObjectRef{TypeID: data.TypeID, Signer: data.Signer, Subject: data.Subject, Timestamp: data.Timestamp, Version: data.Version.String()}
+}
+
+//sthsOf returns a summary of every stored STH of the log, oldest first. Must be called with storeLock held
+func sthsOf(logID string) []STHSummary {
+	var sths []STHSummary
+	for _, typeID := range []string{mtr.STHTypeID, mtr.STHPOCTypeID} {
+		for _, byVersion := range messages[typeID][logID] {
+			for _, data := range byVersion {
+				sth, err := data.DeconstructSTH()
+				if err != nil {
+					glog.Errorf("Stored STH of %v cannot be read: %v\n", logID, err)
+					continue
+				}
+				sths = append(sths, STHSummary{
+					TreeSize: sth.TreeHeadData.TreeSize,
+					Timestamp: sth.TreeHeadData.Timestamp,
+					RootHash: base64.StdEncoding.EncodeToString(sth.TreeHeadData.SHA256RootHash[:]),
+				})
+			}
+		}
+	}
+	sort.Slice(sths, func(i, j int) bool {
+		if sths[i].Timestamp != sths[j].Timestamp {
+			return sths[i].Timestamp < sths[j].Timestamp
+		}
+		return sths[i].TreeSize < sths[j].TreeSize
+	})
+	return sths
+}
+
+//refsIn returns references to the objects stored under dataMap[first][second], oldest first. Must be called with storeLock held
+func refsIn(byTimestamp map[uint64]map[string]*mtr.CTObject) []ObjectRef {
+	refs := []ObjectRef{}
+	for _, byVersion := range byTimestamp {
+		for _, data := range byVersion {
+			refs = append(refs, refTo(data))
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Timestamp < refs[j].Timestamp })
+	return refs
+}
+
+//equivocationsAbout returns references to the stored MonitorEquivocationPOMs whose alerts are about the log.
+//Must be called with storeLock held
+func equivocationsAbout(logID string) []ObjectRef {
+	var refs []ObjectRef
+	for monitorID, byTimestamp := range messages[cto.MonitorEquivocationPOMTypeID] {
+		for _, byVersion := range byTimestamp {
+			for _, data := range byVersion {
+				pom, err := cto.DeconstructMonitorEquivocationPOM(data)
+				if err != nil {
+					glog.Errorf("Stored MonitorEquivocationPOM of %v cannot be read: %v\n", monitorID, err)
+					continue
+				}
+				if pom.Alert1.TBS.Subject == logID {
+					refs = append(refs, refTo(data))
+				}
+			}
+		}
+	}
+	return refs
+}
+
+//GetLogView builds the view of one log, with its STH history if withHistory is set
+func GetLogView(info *mtrList.LogInfo, withHistory bool) LogView {
+	view := LogView{LogID: info.LogID, Description: info.Description, URL: info.URL}
+
+	storeLock.RLock()
+	sths := sthsOf(info.LogID)
+	view.PoMs = equivocationsAbout(info.LogID)
+	for _, typeID := range []string{mtr.ConflictingSTHPOMTypeID, mtr.ConflictingSRDPOMTypeID, cto.InconsistentSTHPOMTypeID} {
+		view.PoMs = append(view.PoMs, refsIn(messages[typeID][info.LogID])...)
+	}
+	sort.Slice(view.PoMs, func(i, j int) bool { return view.PoMs[i].Timestamp < view.PoMs[j].Timestamp })
+	view.Alerts = []ObjectRef{}
+	untrusted := untrustedMonitors()
+	for signer, bySigner := range alertsMap[info.LogID] {
+		refs := refsIn(bySigner)
+		for i := range refs {
+			refs[i].Untrusted = untrusted[signer]
+		}
+		view.Alerts = append(view.Alerts, refs...)
+	}
+	storeLock.RUnlock()
+	sort.Slice(view.Alerts, func(i, j int) bool { return view.Alerts[i].Timestamp < view.Alerts[j].Timestamp })
+
+	view.STHCount = len(sths)
+	if len(sths) != 0 {
+		latest := sths[len(sths)-1]
+		view.LatestSTH = &latest
+	}
+	if withHistory {
+		view.History = sths
+	}
+
+	logSeenLock.Lock()
+	if seen, ok := logSeen[info.LogID]; ok {
+		view.FirstSeen, view.LastSeen = &seen.first, &seen.last
+	}
+	logSeenLock.Unlock()
+	return view
+}
+
+// LogsHandler returns the view of every log in the log list, or of the log given by
+// the log_id parameter together with the history of its STHs.
+func LogsHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	logs := getLogs()
+	if logID := req.URL.Query().Get("log_id"); len(logID) != 0 {
+		info := logs.FindLogByLogID(logID)
+		if info == nil {
+			http.Error(w, "log not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, GetLogView(info, true))
+		return
+	}
+
+	views := []LogView{}
+	for _, op := range logs.Operators {
+		for _, info := range op.Logs {
+			views = append(views, GetLogView(info, false))
+		}
+	}
+	writeJSON(w, views)
+}
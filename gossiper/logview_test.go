@@ -0,0 +1,101 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "testing"
+
+  cto "github.com/n-ct/ct-gossiper"
+  mtr "github.com/n-ct/ct-monitor"
+  mtrList "github.com/n-ct/ct-monitor/entitylist"
+)
+
+func TestLogsHandler(t *testing.T){
+  mustGossiperSetup(t)
+  storeEntry(messages, sthCTObject, sthCTObject.Identifier())
+  alert := mtr.CTObject{TypeID: mtr.AlertTypeID, Version: version, Timestamp: timestamp, Signer: "monitor2", Subject: loggerSigner, Blob: []byte("alert")}
+  storeEntry(alertsMap, alert, alert.Identifier())
+
+  recorder := httptest.NewRecorder()
+  LogsHandler(recorder, httptest.NewRequest("GET", "/ct/v1/logs", nil))
+  var views []LogView
+  if err := json.NewDecoder(recorder.Body).Decode(&views); err != nil {
+    t.Fatal(err)
+  }
+  var view *LogView
+  for i := range views {
+    if views[i].LogID == loggerSigner {
+      view = &views[i]
+    } else if views[i].STHCount != 0 || views[i].LatestSTH != nil {
+      t.Errorf("Expected no STHs for %v, got %+v", views[i].LogID, views[i])
+    }
+  }
+  if view == nil {
+    t.Fatalf("Log %v missing from %+v", loggerSigner, views)
+  }
+  if view.STHCount != 1 || view.LatestSTH == nil || view.LatestSTH.TreeSize != 961922764 || view.LatestSTH.Timestamp != timestamp {
+    t.Errorf("Unexpected latest STH %+v", view)
+  }
+  if view.FirstSeen == nil || view.LastSeen == nil || len(view.Alerts) != 1 || len(view.PoMs) != 0 || view.History != nil {
+    t.Errorf("Unexpected log view %+v", view)
+  }
+}
+
+func TestLogViewPoMs(t *testing.T){
+  mustGossiperSetup(t)
+  monitor := addTestMonitor(t)
+  for _, pom := range []mtr.CTObject{
+    mustEquivocation(t, monitor.alert(t, "LoggerNonResponsive", loggerSigner, timestamp), monitor.alert(t, "LoggerResponsive", loggerSigner, timestamp)),
+    {TypeID: mtr.ConflictingSRDPOMTypeID, Version: version, Timestamp: timestamp, Subject: loggerSigner},
+    {TypeID: cto.InconsistentSTHPOMTypeID, Version: version, Timestamp: timestamp, Subject: loggerSigner},
+  } {
+    storeEntry(messages, pom, pom.Identifier())
+  }
+
+  testTables := []struct {
+    logID string
+    poms int
+  }{
+    {loggerSigner, 3},
+    {"another log", 0},
+  }
+  for _, testTable := range testTables{
+    view := GetLogView(&mtrList.LogInfo{LogID: testTable.logID}, false)
+    if len(view.PoMs) != testTable.poms {
+      t.Errorf("%v: expected %v PoMs, got %+v", testTable.logID, testTable.poms, view.PoMs)
+    }
+  }
+}
+
+func TestLogsHandlerSingleLog(t *testing.T){
+  mustGossiperSetup(t)
+  storeEntry(messages, sthCTObject, sthCTObject.Identifier())
+
+  testTables := []struct {
+    logID string
+    expected int
+  }{
+    {loggerSigner, http.StatusOK},
+    {"unknown", http.StatusNotFound},
+  }
+  for _, testTable := range testTables{
+    recorder := httptest.NewRecorder()
+    LogsHandler(recorder, httptest.NewRequest("GET", "/ct/v1/logs?log_id="+url.QueryEscape(testTable.logID), nil))
+    if recorder.Code != testTable.expected {
+      t.Errorf("Log %v returned %v, want %v", testTable.logID, recorder.Code, testTable.expected)
+      continue
+    }
+    if recorder.Code != http.StatusOK {
+      continue
+    }
+    var view LogView
+    if err := json.NewDecoder(recorder.Body).Decode(&view); err != nil {
+      t.Fatal(err)
+    }
+    if len(view.History) != 1 || view.History[0].RootHash != "Z9nMILXtbeXTCrKYjd0MMJGT0ayLjwY6jvXnGm4kO+g=" {
+      t.Errorf("Unexpected history %+v", view.History)
+    }
+  }
+}
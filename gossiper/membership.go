@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	ct "github.com/google/certificate-transparency-go"
+	tls "github.com/google/certificate-transparency-go/tls"
+	cto "github.com/n-ct/ct-gossiper"
+	mtrList "github.com/n-ct/ct-monitor/entitylist"
+	signature "github.com/n-ct/ct-monitor/signature"
+	mtrUtils "github.com/n-ct/ct-monitor/utils"
+)
+
+//states of a member
+const (
+	MemberAlive = "alive"
+	MemberSuspect = "suspect" //did not answer a direct or an indirect probe
+	MemberDead = "dead" //left or stayed suspect for too long
+)
+
+const (
+	defaultProbeInterval = 1000 * time.Millisecond
+	defaultProbeTimeout = 500 * time.Millisecond
+	defaultIndirectProbes = 3
+	defaultSuspectTimeout = 5000 * time.Millisecond
+	defaultMaxClockSkew = 30000 * time.Millisecond
+)
+
+var membership *Membership;
+
+// Member is a gossiper taking part in the membership protocol.
+// Its gossiper URL always comes from the trusted monitor list, never from the messages.
+type Member struct{
+	MonitorID string `json:"monitor_id"`
+	GossiperURL string `json:"gossiper_url"`
+	State string `json:"state"`
+	StateChanged time.Time `json:"state_changed"`
+}
+
+// MembershipMessage is the body of every membership request and response, signed with the
+// monitor key of the sender. The path is signed so that a message sent to one endpoint cannot
+// be replayed to another, the recipient so that it cannot be replayed to another member, and
+// the timestamp and nonce so that it cannot be replayed at all.
+type MembershipMessage struct{
+	MembershipSignedFields
+	Signature ct.DigitallySigned `json:"signature"`
+}
+
+// MembershipSignedFields are the fields of a MembershipMessage covered by the signature.
+type MembershipSignedFields struct{
+	Path string `json:"path"` //endpoint the message is sent to, or that a reply answers
+	Reply bool `json:"reply,omitempty"`
+	MonitorID string `json:"monitor_id"` //sender of the message
+	To string `json:"to"` //recipient of the message, nonces are only remembered by the recipient
+	Target string `json:"target,omitempty"` //member to probe for a ping-req
+	Members []string `json:"members,omitempty"` //members the sender believes to be alive
+	Timestamp uint64 `json:"timestamp"` //milliseconds, messages further than max_clock_skew_ms from our clock are refused
+	Nonce string `json:"nonce"` //random, accepted once from each sender
+}
+
+// Membership keeps the list of peers up to date at runtime.
+// Gossipers join by contacting the seeds, every probe interval a random member is
+// pinged directly and, if it does not answer, through other members before being
+// suspected and eventually removed. Only monitors in the monitor list can become members.
+type Membership struct{
+	self string
+	probeInterval time.Duration
+	probeTimeout time.Duration
+	indirectProbes int
+	suspectTimeout time.Duration
+	maxClockSkew time.Duration
+	signer *signature.Signer
+
+	mu sync.Mutex
+	members map[string]*Member //[MonitorID]
+	removed map[string]bool //[MonitorID] members removed by the admin, the protocol does not bring them back
+	nonces map[string]time.Time //[MonitorID/Nonce]time seen, kept while the message could still be replayed
+	stop chan struct{}
+}
+
+// NewMembership creates the membership state of gossiper self, using seeds as the first members.
+// Messages are signed with priv_key of the configuration, which must be the private key of
+// publicKey, the key of self in the monitor list.
+func NewMembership(config cto.MembershipConfig, self string, publicKey string, seeds []*mtrList.MonitorInfo) (*Membership, error) {
+	signer, err := newMonitorSigner(config.Priv_key, self, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	m := &Membership{
+		self: self,
+		probeInterval: durationOrDefault(config.Probe_interval_ms, defaultProbeInterval),
+		probeTimeout: durationOrDefault(config.Probe_timeout_ms, defaultProbeTimeout),
+		indirectProbes: config.Indirect_probes,
+		suspectTimeout: durationOrDefault(config.Suspect_timeout_ms, defaultSuspectTimeout),
+		maxClockSkew: durationOrDefault(config.Max_clock_skew_ms, defaultMaxClockSkew),
+		signer: signer,
+		members: make(map[string]*Member),
+		removed: make(map[string]bool),
+		nonces: make(map[string]time.Time),
+		stop: make(chan struct{}),
+	}
+	if m.indirectProbes == 0 {
+		m.indirectProbes = defaultIndirectProbes
+	}
+	now := time.Now()
+	for _, seed := range seeds {
+		m.members[seed.MonitorID] = &Member{MonitorID: seed.MonitorID, GossiperURL: seed.GossiperURL, State: MemberAlive, StateChanged: now}
+	}
+	return m, nil
+}
+
+//newMonitorSigner returns the signer of privKey, checking that it is the private key of publicKey, the key of monitor id in the monitor list
+func newMonitorSigner(privKey string, id string, publicKey string) (*signature.Signer, error) {
+	signer, err := signature.NewSigner(privKey)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.CreateSignature(tls.SHA256, id)
+	if err == nil {
+		err = signature.VerifySignature(publicKey, id, *sig)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key does not match the key of %v in the monitor list: %v", id, err)
+	}
+	return signer, nil
+}
+
+//durationOrDefault converts milliseconds to a duration, using def when ms is not set
+func durationOrDefault(ms int, def time.Duration) time.Duration {
+	if ms <= 0 {
+		return def
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+//Start joins the seeds and starts probing members in the background
+func (m *Membership) Start(){
+	for _, member := range m.Members() {
+		reply, err := m.send(member, cto.MembershipJoinPath, MembershipSignedFields{})
+		if err != nil {
+			glog.Infof("Unable to join through %v: %v\n", member.MonitorID, err)
+			continue
+		}
+		glog.Infof("Joined through %v\n", member.MonitorID)
+		m.merge(reply.Members)
+	}
+	m.updatePeers()
+
+	go func(){
+		ticker := time.NewTicker(m.probeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.probe()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+//Leave stops probing and tells every member that this gossiper is leaving
+func (m *Membership) Leave(){
+	close(m.stop)
+	for _, member := range m.Members() {
+		if member.State == MemberDead {
+			continue
+		}
+		if _, err := m.send(member, cto.MembershipLeavePath, MembershipSignedFields{}); err != nil {
+			glog.Infof("Unable to send leave to %v: %v\n", member.MonitorID, err)
+		}
+	}
+}
+
+//Members returns a copy of all members sorted by MonitorID
+func (m *Membership) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := make([]Member, 0, len(m.members))
+	for _, member := range m.members {
+		members = append(members, *member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].MonitorID < members[j].MonitorID })
+	return members
+}
+
+//probe checks one random member directly and then indirectly, and expires old suspects
+func (m *Membership) probe(){
+	target, helpers := m.pickProbeTarget()
+	if target != nil {
+		if m.ping(target) || m.indirectPing(target, helpers) {
+			m.setState(target.MonitorID, MemberAlive)
+		} else if target.State == MemberAlive {
+			glog.Infof("Member %v suspected\n", target.MonitorID)
+			m.setState(target.MonitorID, MemberSuspect)
+		}
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	for _, member := range m.members {
+		if member.State == MemberSuspect && now.Sub(member.StateChanged) > m.suspectTimeout {
+			glog.Infof("Member %v declared dead\n", member.MonitorID)
+			member.State = MemberDead
+			member.StateChanged = now
+		}
+	}
+	m.mu.Unlock()
+	m.updatePeers()
+}
+
+//pickProbeTarget returns a random live member and up to indirectProbes alive members to help probing it
+func (m *Membership) pickProbeTarget() (*Member, []Member) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var candidates []*Member
+	for _, member := range m.members {
+		if member.State != MemberDead {
+			candidates = append(candidates, member)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	target := *candidates[rand.Intn(len(candidates))]
+
+	var helpers []Member
+	for _, i := range rand.Perm(len(candidates)) {
+		if len(helpers) == m.indirectProbes {
+			break
+		}
+		if candidates[i].MonitorID != target.MonitorID && candidates[i].State == MemberAlive {
+			helpers = append(helpers, *candidates[i])
+		}
+	}
+	return &target, helpers
+}
+
+//ping sends a direct ping to member and merges the members it reports
+func (m *Membership) ping(member *Member) bool {
+	reply, err := m.send(*member, cto.MembershipPingPath, m.message(""))
+	if err != nil {
+		return false
+	}
+	m.merge(reply.Members)
+	return true
+}
+
+//indirectPing asks helpers to ping target and returns true if any of them reached it
+func (m *Membership) indirectPing(target *Member, helpers []Member) bool {
+	if len(helpers) == 0 {
+		return false
+	}
+	results := make(chan bool, len(helpers))
+	for _, helper := range helpers {
+		go func(helper Member){
+			_, err := m.sendWithTimeout(helper, cto.MembershipPingReqPath, m.message(target.MonitorID), 2*m.probeTimeout)
+			results <- err == nil
+		}(helper)
+	}
+	reached := false
+	for range helpers {
+		if <-results {
+			reached = true
+		}
+	}
+	return reached
+}
+
+//message builds the fields of a membership message listing the alive members
+func (m *Membership) message(target string) MembershipSignedFields {
+	msg := MembershipSignedFields{Target: target}
+	for _, member := range m.Members() {
+		if member.State == MemberAlive {
+			msg.Members = append(msg.Members, member.MonitorID)
+		}
+	}
+	return msg
+}
+
+//merge adds trusted members we did not know about. Members we declared dead only come back by contacting us
+func (m *Membership) merge(monitorIDs []string){
+	changed := false
+	m.mu.Lock()
+	for _, monitorID := range monitorIDs {
+		if _, ok := m.members[monitorID]; ok || monitorID == m.self || m.removed[monitorID] {
+			continue
+		}
+		if info := trustedMonitor(monitorID); info != nil {
+			glog.Infof("Learned about member %v\n", monitorID)
+			m.members[monitorID] = &Member{MonitorID: monitorID, GossiperURL: info.GossiperURL, State: MemberAlive, StateChanged: time.Now()}
+			changed = true
+		}
+	}
+	m.mu.Unlock()
+	if changed {
+		m.updatePeers()
+	}
+}
+
+//Remove declares a member dead and keeps it dead until Restore is called
+func (m *Membership) Remove(monitorID string) error {
+	m.mu.Lock()
+	m.removed[monitorID] = true
+	m.mu.Unlock()
+	err := m.setState(monitorID, MemberDead)
+	if err != nil {
+		m.mu.Lock()
+		delete(m.removed, monitorID)
+		m.mu.Unlock()
+	}
+	return err
+}
+
+//Restore lets a member removed with Remove take part again and marks it alive
+func (m *Membership) Restore(monitorID string) error {
+	m.mu.Lock()
+	delete(m.removed, monitorID)
+	m.mu.Unlock()
+	return m.setState(monitorID, MemberAlive)
+}
+
+//setState changes the state of a member, adding it if it is trusted and unknown. Removed members can only be declared dead
+func (m *Membership) setState(monitorID string, state string) error {
+	if monitorID == m.self {
+		return fmt.Errorf("%v is this gossiper", monitorID)
+	}
+	info := trustedMonitor(monitorID)
+	if info == nil {
+		return fmt.Errorf("%v is not in the monitor list", monitorID)
+	}
+	m.mu.Lock()
+	if m.removed[monitorID] && state != MemberDead {
+		m.mu.Unlock()
+		return fmt.Errorf("%v was removed by the admin", monitorID)
+	}
+	member, ok := m.members[monitorID]
+	if !ok {
+		member = &Member{MonitorID: monitorID}
+		m.members[monitorID] = member
+	}
+	member.GossiperURL = info.GossiperURL
+	if member.State != state {
+		member.State = state
+		member.StateChanged = time.Now()
+	}
+	m.mu.Unlock()
+	m.updatePeers()
+	return nil
+}
+
+//updatePeers sets the gossip peers to every member that is not dead
+func (m *Membership) updatePeers(){
+	var newPeers []*mtrList.MonitorInfo
+	for _, member := range m.Members() {
+		if member.State == MemberDead {
+			continue
+		}
+		if info := trustedMonitor(member.MonitorID); info != nil {
+			newPeers = append(newPeers, info)
+		}
+	}
+	setPeers(newPeers)
+}
+
+//send posts a signed membership message to a member and decodes the reply, which must be signed by the member
+func (m *Membership) send(member Member, path string, fields MembershipSignedFields) (*MembershipMessage, error) {
+	return m.sendWithTimeout(member, path, fields, m.probeTimeout)
+}
+
+func (m *Membership) sendWithTimeout(member Member, path string, fields MembershipSignedFields, timeout time.Duration) (*MembershipMessage, error) {
+	fields.Path, fields.Reply, fields.To = path, false, member.MonitorID
+	msg, err := m.sign(fields)
+	if err != nil {
+		return nil, err
+	}
+	jsonStr, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(mtrUtils.CreateRequestURL(member.GossiperURL, path), "application/json", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v returned %v", path, resp.Status)
+	}
+	var reply MembershipMessage
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, err
+	}
+	if reply.MonitorID != member.MonitorID {
+		return nil, fmt.Errorf("%v answered for %v", member.MonitorID, reply.MonitorID)
+	}
+	if err := m.verify(&reply, path, true); err != nil {
+		return nil, fmt.Errorf("reply of %v: %v", member.MonitorID, err)
+	}
+	return &reply, nil
+}
+
+//sign fills in the sender, timestamp and nonce of fields and signs them with the key of our monitor
+func (m *Membership) sign(fields MembershipSignedFields) (*MembershipMessage, error) {
+	nonce := make([]byte, 16)
+	if _, err := crand.Read(nonce); err != nil {
+		return nil, err
+	}
+	fields.MonitorID = m.self
+	fields.Timestamp = uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	fields.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	sig, err := m.signer.CreateSignature(tls.SHA256, fields)
+	if err != nil {
+		return nil, err
+	}
+	return &MembershipMessage{MembershipSignedFields: fields, Signature: *sig}, nil
+}
+
+// verify checks that msg was signed for path and for us by a monitor of the monitor list, within
+// maxClockSkew of our clock and with a nonce not seen before.
+func (m *Membership) verify(msg *MembershipMessage, path string, reply bool) error {
+	info := trustedMonitor(msg.MonitorID)
+	if info == nil {
+		return fmt.Errorf("%v is not in the monitor list", msg.MonitorID)
+	}
+	if msg.Path != path || msg.Reply != reply {
+		return fmt.Errorf("message signed for %v", msg.Path)
+	}
+	if msg.To != m.self {
+		return fmt.Errorf("message addressed to %v", msg.To)
+	}
+	now := time.Now()
+	sent := time.Unix(0, int64(msg.Timestamp) * int64(time.Millisecond))
+	if sent.Before(now.Add(-m.maxClockSkew)) || sent.After(now.Add(m.maxClockSkew)) {
+		return fmt.Errorf("timestamp %v is too far from our clock", msg.Timestamp)
+	}
+	if len(msg.Nonce) == 0 {
+		return errors.New("no nonce")
+	}
+	if err := signature.VerifySignature(info.MonitorKey, msg.MembershipSignedFields, msg.Signature); err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, seen := range m.nonces {
+		if now.Sub(seen) > 2*m.maxClockSkew {
+			delete(m.nonces, key)
+		}
+	}
+	key := msg.MonitorID + "/" + msg.Nonce
+	if _, ok := m.nonces[key]; ok {
+		return errors.New("message replayed")
+	}
+	m.nonces[key] = now
+	return nil
+}
+
+//trustedMonitor returns the monitor list entry of monitorID, or nil if it is not trusted
+func trustedMonitor(monitorID string) *mtrList.MonitorInfo {
+	monitors := getMonitors()
+	if monitors == nil || len(monitorID) == 0 {
+		return nil
+	}
+	return monitors.FindMonitorByMonitorID(monitorID)
+}
+
+//decodeMembershipMessage reads a message sent to path and signed by a trusted member, writing the error response if it is not
+func decodeMembershipMessage(w http.ResponseWriter, req *http.Request, path string) (*MembershipMessage, bool) {
+	if membership == nil {
+		http.Error(w, "membership disabled", http.StatusNotFound)
+		return nil, false
+	}
+	var msg MembershipMessage
+	if err := json.NewDecoder(req.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := membership.verify(&msg, path, false); err != nil {
+		glog.Infof("Membership message from %v refused: %v\n", msg.MonitorID, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil, false
+	}
+	return &msg, true
+}
+
+//writeMembershipMessage signs fields as the reply to the message of the member to, sent to path, and writes it
+func writeMembershipMessage(w http.ResponseWriter, path string, to string, fields MembershipSignedFields){
+	fields.Path, fields.Reply, fields.To = path, true, to
+	msg, err := membership.sign(fields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
+
+//MembershipJoinHandler adds the sender as a member and replies with the alive members
+func MembershipJoinHandler(w http.ResponseWriter, req *http.Request){
+	msg, ok := decodeMembershipMessage(w, req, cto.MembershipJoinPath)
+	if !ok {
+		return
+	}
+	if err := membership.setState(msg.MonitorID, MemberAlive); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	glog.Infof("Member %v joined\n", msg.MonitorID)
+	writeMembershipMessage(w, cto.MembershipJoinPath, msg.MonitorID, membership.message(""))
+}
+
+//MembershipLeaveHandler removes the sender from the peers
+func MembershipLeaveHandler(w http.ResponseWriter, req *http.Request){
+	msg, ok := decodeMembershipMessage(w, req, cto.MembershipLeavePath)
+	if !ok {
+		return
+	}
+	if err := membership.setState(msg.MonitorID, MemberDead); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	glog.Infof("Member %v left\n", msg.MonitorID)
+	writeMembershipMessage(w, cto.MembershipLeavePath, msg.MonitorID, MembershipSignedFields{})
+}
+
+//MembershipPingHandler answers a direct probe, marking the sender alive
+func MembershipPingHandler(w http.ResponseWriter, req *http.Request){
+	msg, ok := decodeMembershipMessage(w, req, cto.MembershipPingPath)
+	if !ok {
+		return
+	}
+	membership.setState(msg.MonitorID, MemberAlive)
+	membership.merge(msg.Members)
+	writeMembershipMessage(w, cto.MembershipPingPath, msg.MonitorID, membership.message(""))
+}
+
+//MembershipPingReqHandler probes the target on behalf of the sender
+func MembershipPingReqHandler(w http.ResponseWriter, req *http.Request){
+	msg, ok := decodeMembershipMessage(w, req, cto.MembershipPingReqPath)
+	if !ok {
+		return
+	}
+	info := trustedMonitor(msg.Target)
+	if info == nil {
+		http.Error(w, "unknown target", http.StatusBadRequest)
+		return
+	}
+	if !membership.ping(&Member{MonitorID: info.MonitorID, GossiperURL: info.GossiperURL}) {
+		http.Error(w, "target unreachable", http.StatusGatewayTimeout)
+		return
+	}
+	writeMembershipMessage(w, cto.MembershipPingReqPath, msg.MonitorID, MembershipSignedFields{Target: msg.Target})
+}
+
+//MembersHandler returns every member and its state as JSON
+func MembersHandler(w http.ResponseWriter, req *http.Request){
+	if membership == nil {
+		http.Error(w, "membership disabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(membership.Members())
+}
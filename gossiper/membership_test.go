@@ -0,0 +1,180 @@
+package main
+
+import (
+  "bytes"
+  "crypto/ecdsa"
+  "crypto/x509"
+  "encoding/base64"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+
+  tls "github.com/google/certificate-transparency-go/tls"
+  cto "github.com/n-ct/ct-gossiper"
+)
+
+func peerIDs() []string {
+  var ids []string
+  for _, peer := range getPeers() {
+    ids = append(ids, peer.MonitorID)
+  }
+  return ids
+}
+
+//privKey returns the private key of k as set in the configuration
+func (k *testKey) privKey(t *testing.T) string {
+  der, err := x509.MarshalECPrivateKey(k.signer.PrivKey.(*ecdsa.PrivateKey))
+  if err != nil {
+    t.Fatal(err)
+  }
+  return base64.StdEncoding.EncodeToString(der)
+}
+
+//newTestMembership returns the membership of the monitor self
+func newTestMembership(t *testing.T, config cto.MembershipConfig, self *testKey) *Membership {
+  config.Priv_key = self.privKey(t)
+  m, err := NewMembership(config, self.id, self.key, getPeers())
+  if err != nil {
+    t.Fatal(err)
+  }
+  return m
+}
+
+//membershipMessage returns the message of the monitor from to the monitor to, signed by k
+func (k *testKey) membershipMessage(t *testing.T, from string, to string, path string, sent time.Time) MembershipMessage {
+  fields := MembershipSignedFields{Path: path, MonitorID: from, To: to, Timestamp: uint64(sent.UnixNano() / int64(time.Millisecond)), Nonce: base64.StdEncoding.EncodeToString([]byte(sent.String()))}
+  sig, err := k.signer.CreateSignature(tls.SHA256, fields)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return MembershipMessage{MembershipSignedFields: fields, Signature: *sig}
+}
+
+func TestNewMembership(t *testing.T){
+  mustGossiperSetup(t)
+  self := addTestMonitor(t)
+  if _, err := NewMembership(cto.MembershipConfig{Priv_key: self.privKey(t)}, self.id, self.key, nil); err != nil {
+    t.Errorf("Expected the membership to be created: %v", err)
+  }
+  if _, err := NewMembership(cto.MembershipConfig{Priv_key: newTestKey(t).privKey(t)}, self.id, self.key, nil); err == nil {
+    t.Errorf("Expected a key that is not the one of the monitor list to be rejected")
+  }
+}
+
+func TestMembershipHandlers(t *testing.T){
+  mustGossiperSetup(t)
+  self := addTestMonitor(t)
+  peer := addTestMonitor(t)
+  other := addTestMonitor(t)
+  stranger := newTestKey(t)
+  setPeers(nil)
+  membership = newTestMembership(t, cto.MembershipConfig{}, self)
+  defer func(){ membership = nil }()
+  peerView := newTestMembership(t, cto.MembershipConfig{}, peer) //replies are addressed to peer
+
+  now := time.Now()
+  join := peer.membershipMessage(t, peer.id, self.id, cto.MembershipJoinPath, now)
+  testTables := []struct {
+    name string
+    handler http.HandlerFunc
+    message MembershipMessage
+    code int
+    peers int
+  }{
+    {"join", MembershipJoinHandler, join, http.StatusOK, 1},
+    {"replayed join", MembershipJoinHandler, join, http.StatusForbidden, 1},
+    {"untrusted monitor", MembershipJoinHandler, stranger.membershipMessage(t, stranger.id, self.id, cto.MembershipJoinPath, now), http.StatusForbidden, 1},
+    {"ourselves", MembershipJoinHandler, self.membershipMessage(t, self.id, self.id, cto.MembershipJoinPath, now), http.StatusBadRequest, 1},
+    {"leave signed by another key", MembershipLeaveHandler, stranger.membershipMessage(t, peer.id, self.id, cto.MembershipLeavePath, now), http.StatusForbidden, 1},
+    {"ping replayed as a leave", MembershipLeaveHandler, peer.membershipMessage(t, peer.id, self.id, cto.MembershipPingPath, now.Add(1)), http.StatusForbidden, 1},
+    {"stale leave", MembershipLeaveHandler, peer.membershipMessage(t, peer.id, self.id, cto.MembershipLeavePath, now.Add(-time.Hour)), http.StatusForbidden, 1},
+    {"leave sent to another member, replayed to us", MembershipLeaveHandler, peer.membershipMessage(t, peer.id, other.id, cto.MembershipLeavePath, now.Add(2)), http.StatusForbidden, 1},
+    {"leave", MembershipLeaveHandler, peer.membershipMessage(t, peer.id, self.id, cto.MembershipLeavePath, now.Add(2)), http.StatusOK, 0},
+    {"ping", MembershipPingHandler, peer.membershipMessage(t, peer.id, self.id, cto.MembershipPingPath, now.Add(3)), http.StatusOK, 1},
+  }
+
+  for _, testTable := range testTables{
+    jsonStr, _ := json.Marshal(testTable.message)
+    recorder := httptest.NewRecorder()
+    testTable.handler(recorder, httptest.NewRequest("POST", testTable.message.Path, bytes.NewBuffer(jsonStr)))
+    if recorder.Code != testTable.code {
+      t.Errorf("%s: returned %v want %v", testTable.name, recorder.Code, testTable.code)
+    }
+    if len(getPeers()) != testTable.peers {
+      t.Errorf("%s: left peers %v want %d peers", testTable.name, peerIDs(), testTable.peers)
+    }
+    if recorder.Code != http.StatusOK {
+      continue
+    }
+    var reply MembershipMessage
+    if err := json.NewDecoder(recorder.Body).Decode(&reply); err != nil {
+      t.Fatal(err)
+    }
+    if err := peerView.verify(&reply, testTable.message.Path, true); err != nil {
+      t.Errorf("%s: the reply does not verify: %v", testTable.name, err)
+    }
+  }
+}
+
+func TestMembershipRemove(t *testing.T){
+  mustGossiperSetup(t)
+  self := addTestMonitor(t)
+  peer := addTestMonitor(t)
+  setPeers(nil)
+  membership = newTestMembership(t, cto.MembershipConfig{}, self)
+  defer func(){ membership = nil }()
+
+  now := time.Now()
+  testTables := []struct {
+    name string
+    change func() error
+    handler http.HandlerFunc
+    message MembershipMessage
+    code int
+    peers int
+  }{
+    {"join", nil, MembershipJoinHandler, peer.membershipMessage(t, peer.id, self.id, cto.MembershipJoinPath, now), http.StatusOK, 1},
+    {"ping after removal", func() error { return membership.Remove(peer.id) }, MembershipPingHandler, peer.membershipMessage(t, peer.id, self.id, cto.MembershipPingPath, now.Add(1)), http.StatusOK, 0},
+    {"join after removal", nil, MembershipJoinHandler, peer.membershipMessage(t, peer.id, self.id, cto.MembershipJoinPath, now.Add(2)), http.StatusBadRequest, 0},
+    {"ping after restore", func() error { return membership.Restore(peer.id) }, MembershipPingHandler, peer.membershipMessage(t, peer.id, self.id, cto.MembershipPingPath, now.Add(3)), http.StatusOK, 1},
+  }
+  for _, testTable := range testTables{
+    if testTable.change != nil {
+      if err := testTable.change(); err != nil {
+        t.Fatalf("%s: %v", testTable.name, err)
+      }
+    }
+    jsonStr, _ := json.Marshal(testTable.message)
+    recorder := httptest.NewRecorder()
+    testTable.handler(recorder, httptest.NewRequest("POST", testTable.message.Path, bytes.NewBuffer(jsonStr)))
+    if recorder.Code != testTable.code {
+      t.Errorf("%s: returned %v want %v", testTable.name, recorder.Code, testTable.code)
+    }
+    if len(getPeers()) != testTable.peers {
+      t.Errorf("%s: left peers %v want %d peers", testTable.name, peerIDs(), testTable.peers)
+    }
+  }
+}
+
+func TestMembershipProbeUnreachable(t *testing.T){
+  mustGossiperSetup(t)
+  //monitor2's gossiper is not running, so every probe fails
+  m := newTestMembership(t, cto.MembershipConfig{Suspect_timeout_ms: 1}, addTestMonitor(t))
+
+  m.probe()
+  if state := m.Members()[0].State; state != MemberSuspect {
+    t.Fatalf("Expected unreachable member to be %s, got %s", MemberSuspect, state)
+  }
+  m.mu.Lock()
+  m.members["monitor2"].StateChanged = m.members["monitor2"].StateChanged.Add(-m.suspectTimeout * 2)
+  m.mu.Unlock()
+  m.probe()
+  if state := m.Members()[0].State; state != MemberDead {
+    t.Errorf("Expected expired suspect to be %s, got %s", MemberDead, state)
+  }
+  if len(getPeers()) != 0 {
+    t.Errorf("Expected dead member to be removed from peers, got %v", peerIDs())
+  }
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+var ErrProofFailed = errors.New("merkle proof does not verify")
+
+//hashChildren returns the RFC 6962 hash of an interior node
+func hashChildren(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// VerifyConsistencyProof checks that the tree of size2 with root2 extends the tree of size1 with root1,
+// using the RFC 6962 consistency proof between them (algorithm of RFC 9162 section 2.1.4.2).
+func VerifyConsistencyProof(size1 uint64, size2 uint64, root1 []byte, root2 []byte, proof [][]byte) error {
+	switch {
+	case size1 > size2:
+		return fmt.Errorf("%w: tree size %v is larger than %v", ErrProofFailed, size1, size2)
+	case size1 == size2:
+		if len(proof) != 0 || !bytes.Equal(root1, root2) {
+			return fmt.Errorf("%w: trees of size %v differ", ErrProofFailed, size1)
+		}
+		return nil
+	case size1 == 0:
+		if len(proof) != 0 {
+			return fmt.Errorf("%w: non-empty proof from the empty tree", ErrProofFailed)
+		}
+		return nil
+	case len(proof) == 0:
+		return fmt.Errorf("%w: empty proof", ErrProofFailed)
+	}
+
+	if size1&(size1-1) == 0 { //the old tree is a complete subtree, its root starts the path
+		proof = append([][]byte{root1}, proof...)
+	}
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return fmt.Errorf("%w: proof is too long", ErrProofFailed)
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = hashChildren(c, fr)
+			sr = hashChildren(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = hashChildren(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("%w: proof is too short", ErrProofFailed)
+	}
+	if !bytes.Equal(fr, root1) {
+		return fmt.Errorf("%w: root of size %v does not match", ErrProofFailed, size1)
+	}
+	if !bytes.Equal(sr, root2) {
+		return fmt.Errorf("%w: root of size %v does not match", ErrProofFailed, size2)
+	}
+	return nil
+}
+
+// VerifyInclusionProof checks that the leaf with leafHash is at index in the tree of size with root,
+// using the RFC 6962 audit path of the leaf (algorithm of RFC 9162 section 2.1.3.2).
+func VerifyInclusionProof(index uint64, size uint64, leafHash []byte, proof [][]byte, root []byte) error {
+	if index >= size {
+		return fmt.Errorf("%w: index %v is outside the tree of size %v", ErrProofFailed, index, size)
+	}
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return fmt.Errorf("%w: proof is too long", ErrProofFailed)
+		}
+		if fn&1 == 1 || fn == sn {
+			r = hashChildren(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = hashChildren(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("%w: proof is too short", ErrProofFailed)
+	}
+	if !bytes.Equal(r, root) {
+		return fmt.Errorf("%w: root of size %v does not match", ErrProofFailed, size)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+  "crypto/sha256"
+  "errors"
+  "fmt"
+  "testing"
+)
+
+//testLeaves returns the RFC 6962 leaf hashes of n made-up entries
+func testLeaves(n int) [][]byte {
+  leaves := make([][]byte, n)
+  for i := range leaves {
+    h := sha256.Sum256(append([]byte{0}, []byte(fmt.Sprintf("entry %d", i))...))
+    leaves[i] = h[:]
+  }
+  return leaves
+}
+
+//largestPowerOfTwoBelow returns the largest power of 2 smaller than n, n > 1
+func largestPowerOfTwoBelow(n int) int {
+  k := 1
+  for k<<1 < n {
+    k <<= 1
+  }
+  return k
+}
+
+//treeHash returns the RFC 6962 Merkle tree hash of the leaf hashes
+func treeHash(leaves [][]byte) []byte {
+  switch len(leaves) {
+  case 0:
+    h := sha256.Sum256(nil)
+    return h[:]
+  case 1:
+    return leaves[0]
+  }
+  k := largestPowerOfTwoBelow(len(leaves))
+  return hashChildren(treeHash(leaves[:k]), treeHash(leaves[k:]))
+}
+
+//consistencyProof returns the RFC 6962 consistency proof from the first m leaves to all of them
+func consistencyProof(m int, leaves [][]byte) [][]byte {
+  return subproof(m, leaves, true)
+}
+
+func subproof(m int, leaves [][]byte, complete bool) [][]byte {
+  n := len(leaves)
+  if m == n {
+    if complete {
+      return nil
+    }
+    return [][]byte{treeHash(leaves)}
+  }
+  k := largestPowerOfTwoBelow(n)
+  if m <= k {
+    return append(subproof(m, leaves[:k], complete), treeHash(leaves[k:]))
+  }
+  return append(subproof(m-k, leaves[k:], false), treeHash(leaves[:k]))
+}
+
+//inclusionProof returns the RFC 6962 audit path of leaf m in the tree of the leaves
+func inclusionProof(m int, leaves [][]byte) [][]byte {
+  n := len(leaves)
+  if n <= 1 {
+    return nil
+  }
+  k := largestPowerOfTwoBelow(n)
+  if m < k {
+    return append(inclusionProof(m, leaves[:k]), treeHash(leaves[k:]))
+  }
+  return append(inclusionProof(m-k, leaves[k:]), treeHash(leaves[:k]))
+}
+
+func TestVerifyConsistencyProof(t *testing.T){
+  leaves := testLeaves(17)
+  for size2 := 1; size2 <= len(leaves); size2++ {
+    root2 := treeHash(leaves[:size2])
+    for size1 := 1; size1 <= size2; size1++ {
+      root1 := treeHash(leaves[:size1])
+      proof := consistencyProof(size1, leaves[:size2])
+      if err := VerifyConsistencyProof(uint64(size1), uint64(size2), root1, root2, proof); err != nil {
+        t.Errorf("%v to %v: %v", size1, size2, err)
+      }
+      if size1 == size2 {
+        continue
+      }
+      if err := VerifyConsistencyProof(uint64(size1), uint64(size2), root2, root2, proof); !errors.Is(err, ErrProofFailed) {
+        t.Errorf("%v to %v: expected a wrong old root to fail, got %v", size1, size2, err)
+      }
+      if err := VerifyConsistencyProof(uint64(size1), uint64(size2), root1, root1, proof); !errors.Is(err, ErrProofFailed) {
+        t.Errorf("%v to %v: expected a wrong new root to fail, got %v", size1, size2, err)
+      }
+      if err := VerifyConsistencyProof(uint64(size1), uint64(size2), root1, root2, proof[:len(proof)-1]); err == nil {
+        t.Errorf("%v to %v: expected a truncated proof to fail", size1, size2)
+      }
+      if err := VerifyConsistencyProof(uint64(size1), uint64(size2), root1, root2, append(proof, root1)); err == nil {
+        t.Errorf("%v to %v: expected a longer proof to fail", size1, size2)
+      }
+    }
+  }
+
+  testTables := []struct {
+    name string
+    size1, size2 uint64
+    proof [][]byte
+    valid bool
+  }{
+    {"from the empty tree", 0, 5, nil, true},
+    {"shrinking tree", 5, 4, nil, false},
+    {"empty proof", 4, 5, nil, false},
+    {"same size, other root", 4, 4, nil, false},
+  }
+  for _, testTable := range testTables{
+    err := VerifyConsistencyProof(testTable.size1, testTable.size2, treeHash(leaves[:testTable.size1]), treeHash(leaves[:5]), testTable.proof)
+    if (err == nil) != testTable.valid {
+      t.Errorf("%v: VerifyConsistencyProof returned %v", testTable.name, err)
+    }
+  }
+}
+
+func TestVerifyInclusionProof(t *testing.T){
+  leaves := testLeaves(17)
+  other := testLeaves(18)[17]
+  for size := 1; size <= len(leaves); size++ {
+    root := treeHash(leaves[:size])
+    for index := 0; index < size; index++ {
+      proof := inclusionProof(index, leaves[:size])
+      if err := VerifyInclusionProof(uint64(index), uint64(size), leaves[index], proof, root); err != nil {
+        t.Errorf("leaf %v of %v: %v", index, size, err)
+      }
+      if err := VerifyInclusionProof(uint64(index), uint64(size), other, proof, root); !errors.Is(err, ErrProofFailed) {
+        t.Errorf("leaf %v of %v: expected another leaf to fail, got %v", index, size, err)
+      }
+      if index+1 < size {
+        if err := VerifyInclusionProof(uint64(index+1), uint64(size), leaves[index], proof, root); err == nil {
+          t.Errorf("leaf %v of %v: expected another index to fail", index, size)
+        }
+      }
+      if len(proof) != 0 {
+        if err := VerifyInclusionProof(uint64(index), uint64(size), leaves[index], proof[:len(proof)-1], root); err == nil {
+          t.Errorf("leaf %v of %v: expected a truncated proof to fail", index, size)
+        }
+      }
+    }
+    if err := VerifyInclusionProof(uint64(size), uint64(size), leaves[0], nil, root); err == nil {
+      t.Errorf("size %v: expected an index outside the tree to fail", size)
+    }
+  }
+}
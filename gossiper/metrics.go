@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	cto "github.com/n-ct/ct-gossiper"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+//outcomes of a received object, used as the outcome label
+const (
+	OutcomeNew = "new"
+	OutcomeDuplicate = "duplicate"
+	OutcomeBlobRequest = "blob-request"
+	OutcomeInvalid = "invalid"
+	OutcomeConflict = "conflict"
+	OutcomeQuarantined = "quarantined"
+	OutcomeBanned = "banned"
+	OutcomeUnresolved = "unresolved"
+)
+
+var (
+	objectsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gossiper",
+		Name: "objects_received_total",
+		Help: "Objects received on the gossip endpoint by TypeID and outcome.",
+	}, []string{"type", "outcome"})
+	pomsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gossiper",
+		Name: "poms_created_total",
+		Help: "Proofs of misbehavior created by this gossiper by TypeID.",
+	}, []string{"type"})
+	sendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gossiper",
+		Name: "send_duration_seconds",
+		Help: "Time taken to post an object to a peer or the monitor, including a blob resend.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"peer"})
+	sendFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gossiper",
+		Name: "send_failures_total",
+		Help: "Posts to a peer or the monitor that failed.",
+	}, []string{"peer"})
+	consistencyAudits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gossiper",
+		Name: "consistency_audits_total",
+		Help: "Consistency proofs requested from the logs by result.",
+	}, []string{"result"})
+	sthFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gossiper",
+		Name: "sth_fetches_total",
+		Help: "STHs polled from the logs by LogID and outcome, error when the log could not be reached.",
+	}, []string{"log", "outcome"})
+	sctFeedback = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gossiper",
+		Name: "sct_feedback_total",
+		Help: "SCTs received through SCT feedback by result, accepted or rejected.",
+	}, []string{"result"})
+	sctInclusionAudits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gossiper",
+		Name: "sct_inclusion_audits_total",
+		Help: "Inclusion checks of the SCTs past their MMD by result.",
+	}, []string{"result"})
+	witnessCosignatures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gossiper",
+		Name: "witness_cosignatures_total",
+		Help: "STHs the witness tried to cosign by result, refused when the tree does not extend the last cosigned one.",
+	}, []string{"result"})
+	verifyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gossiper",
+		Name: "signature_verify_seconds",
+		Help: "Time taken to verify the signature and digest of an object by TypeID.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 12),
+	}, []string{"type"})
+
+	queueDepthDesc = prometheus.NewDesc("gossiper_outbound_queue_depth", "Objects waiting in each outbound queue.", []string{"queue"}, nil)
+	storeSizeDesc = prometheus.NewDesc("gossiper_store_objects", "Objects held in each store.", []string{"store"}, nil)
+	quarantineSizeDesc = prometheus.NewDesc("gossiper_quarantined_objects", "Objects waiting for their signer to appear in the lists.", nil, nil)
+	admissionRejectedDesc = prometheus.NewDesc("gossiper_admission_rejected_total", "Gossip requests rejected by admission control by reason.", []string{"reason"}, nil)
+)
+
+func init(){
+	prometheus.MustRegister(objectsReceived, pomsCreated, sendDuration, sendFailures, consistencyAudits, sthFetches, sctFeedback, sctInclusionAudits, witnessCosignatures, verifyDuration, stateCollector{})
+}
+
+// stateCollector reports values that are read from the gossiper state when scraped.
+type stateCollector struct{}
+
+func (stateCollector) Describe(ch chan<- *prometheus.Desc){
+	ch <- queueDepthDesc
+	ch <- storeSizeDesc
+	ch <- quarantineSizeDesc
+	ch <- admissionRejectedDesc
+}
+
+func (stateCollector) Collect(ch chan<- prometheus.Metric){
+	for name, depth := range queueDepths() {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(depth), name)
+	}
+	storeLock.RLock()
+	messageCount, alertCount := countEntries(messages), countEntries(alertsMap)
+	storeLock.RUnlock()
+	ch <- prometheus.MustNewConstMetric(storeSizeDesc, prometheus.GaugeValue, float64(messageCount), "messages")
+	ch <- prometheus.MustNewConstMetric(storeSizeDesc, prometheus.GaugeValue, float64(alertCount), "alerts")
+	ch <- prometheus.MustNewConstMetric(quarantineSizeDesc, prometheus.GaugeValue, float64(quarantineSize()))
+	if admission != nil {
+		for reason, count := range admission.Rejected() {
+			ch <- prometheus.MustNewConstMetric(admissionRejectedDesc, prometheus.CounterValue, float64(count), reason)
+		}
+	}
+}
+
+//MetricsHandler serves the metrics in the Prometheus text format
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+//typeLabel reports types with a registered validator under their own TypeID, anything else as unknown so senders cannot create labels
+func typeLabel(typeID string) string {
+	if validatorFor(typeID) != nil {
+		return typeID
+	}
+	return "unknown"
+}
+
+//observeReceived counts an object received on the gossip endpoint
+func observeReceived(typeID string, outcome string){
+	objectsReceived.WithLabelValues(typeLabel(typeID), outcome).Inc()
+}
+
+//observeSend records how long a post to the named queue took and whether it failed
+func observeSend(name string, start time.Time, err error){
+	sendDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		sendFailures.WithLabelValues(name).Inc()
+	}
+}
+
+//countEntries returns the number of objects in the selected map. Must be called with storeLock held
+func countEntries(dataMap cto.MessagesMap) int {
+	count := 0
+	for _, bySecond := range dataMap {
+		for _, byTimestamp := range bySecond {
+			for _, byVersion := range byTimestamp {
+				count += len(byVersion)
+			}
+		}
+	}
+	return count
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "net/http/httptest"
+  "strings"
+  "testing"
+
+  "github.com/prometheus/client_golang/prometheus/testutil"
+  mtr "github.com/n-ct/ct-monitor"
+)
+
+func TestGossipHandlerMetrics(t *testing.T){
+  mustGossiperSetup(t)
+  newBefore := testutil.ToFloat64(objectsReceived.WithLabelValues(mtr.STHTypeID, OutcomeNew))
+  duplicateBefore := testutil.ToFloat64(objectsReceived.WithLabelValues(mtr.STHTypeID, OutcomeDuplicate))
+
+  jsonStr, _ := json.Marshal(sthCTObject)
+  for i := 0; i < 2; i++ {
+    GossipHandler(httptest.NewRecorder(), httptest.NewRequest("POST", GossipPath, bytes.NewBuffer(jsonStr)))
+  }
+  if count := testutil.ToFloat64(objectsReceived.WithLabelValues(mtr.STHTypeID, OutcomeNew)) - newBefore; count != 1 {
+    t.Errorf("Expected 1 new STH, got %v", count)
+  }
+  if count := testutil.ToFloat64(objectsReceived.WithLabelValues(mtr.STHTypeID, OutcomeDuplicate)) - duplicateBefore; count != 1 {
+    t.Errorf("Expected 1 duplicate STH, got %v", count)
+  }
+  if typeLabel("made-up-type") != "unknown" {
+    t.Errorf("Expected an unknown TypeID to be reported as unknown")
+  }
+}
+
+func TestMetricsHandler(t *testing.T){
+  mustGossiperSetup(t)
+  storeEntry(messages, sthCTObject, sthCTObject.Identifier())
+
+  recorder := httptest.NewRecorder()
+  MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+  body := recorder.Body.String()
+  for _, expected := range []string{`gossiper_store_objects{store="messages"} 1`, "gossiper_quarantined_objects", "gossiper_objects_received_total"} {
+    if !strings.Contains(body, expected) {
+      t.Errorf("Metrics do not contain %q", expected)
+    }
+  }
+}
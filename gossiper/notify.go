@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+const notifierQueueSize = 100
+
+// Notification is the payload sent to the notifiers.
+type Notification struct{
+	Event string `json:"event"`
+	LogID string `json:"log_id"`
+	Gossiper string `json:"gossiper"` //advertised URL of the gossiper sending the notification
+	Time time.Time `json:"time"`
+	Object *mtr.CTObject `json:"object,omitempty"`
+	SCT *StoredSCT `json:"sct,omitempty"` //set instead of Object for sct_not_included
+}
+
+// notifier delivers notifications from its own goroutine so a slow webhook or command
+// does not hold up gossip.
+type notifier struct{
+	config cto.NotifierConfig
+	events map[string]bool
+	logIDs map[string]bool
+	typeIDs map[string]bool
+	queue chan []byte
+	done chan struct{}
+	deliver func(n *notifier, payload []byte) error
+}
+
+var notifiers []*notifier;
+var notifiersLock sync.RWMutex;
+var jsonlLock sync.Mutex; //serializes appends to the jsonl files
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+//newNotifier returns a started notifier for config
+func newNotifier(config cto.NotifierConfig) *notifier {
+	n := &notifier{
+		config: config,
+		events: toSet(config.Events),
+		logIDs: toSet(config.Log_ids),
+		typeIDs: toSet(config.Type_ids),
+		queue: make(chan []byte, notifierQueueSize),
+		done: make(chan struct{}),
+	}
+	switch config.Type {
+	case cto.NotifierWebhook:
+		n.deliver = withRetries(postWebhook)
+	case cto.NotifierCommand:
+		n.deliver = withRetries(runCommand)
+	case cto.NotifierJSONL:
+		n.deliver = appendJSONL
+	}
+	go n.run()
+	return n
+}
+
+//matches reports whether the notifier wants the notification
+func (n *notifier) matches(notification *Notification) bool {
+	if len(n.events) != 0 && !n.events[notification.Event] {
+		return false
+	}
+	if len(n.logIDs) != 0 && !n.logIDs[notification.LogID] {
+		return false
+	}
+	if len(n.typeIDs) != 0 && (notification.Object == nil || !n.typeIDs[notification.Object.TypeID]) {
+		return false
+	}
+	return true
+}
+
+func (n *notifier) run(){
+	defer close(n.done)
+	for payload := range n.queue {
+		if err := n.deliver(n, payload); err != nil {
+			glog.Errorf("%v notifier failed: %v\n", n.config.Type, err)
+		}
+	}
+}
+
+//SetNotifiers replaces the notifiers, the old ones finish what they have queued
+func SetNotifiers(configs []cto.NotifierConfig){
+	var started []*notifier
+	for _, config := range configs {
+		started = append(started, newNotifier(config))
+	}
+	notifiersLock.Lock()
+	old := notifiers
+	notifiers = started
+	notifiersLock.Unlock()
+	for _, n := range old {
+		close(n.queue)
+	}
+}
+
+//CloseNotifiers stops the notifiers and waits until they have delivered what they have queued or ctx is done
+func CloseNotifiers(ctx context.Context){
+	notifiersLock.Lock()
+	old := notifiers
+	notifiers = nil
+	notifiersLock.Unlock()
+	for _, n := range old {
+		close(n.queue)
+	}
+	for _, n := range old {
+		select {
+		case <-n.done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+//objectLogID returns the log an object is about
+func objectLogID(data *mtr.CTObject) string {
+	if len(data.Subject) != 0 {
+		return data.Subject
+	}
+	return data.Signer
+}
+
+//Notify sends event about data to every notifier that wants it
+func Notify(event string, data *mtr.CTObject){
+	send(&Notification{Event: event, LogID: objectLogID(data), Gossiper: getMyAddress(), Time: time.Now(), Object: data})
+}
+
+//NotifySCT sends event about an SCT received through SCT feedback to every notifier that wants it
+func NotifySCT(event string, sct *StoredSCT){
+	send(&Notification{Event: event, LogID: sct.LogID, Gossiper: getMyAddress(), Time: time.Now(), SCT: sct})
+}
+
+func send(notification *Notification){
+	var payload []byte
+	notifiersLock.RLock()
+	defer notifiersLock.RUnlock()
+	for _, n := range notifiers {
+		if !n.matches(notification) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(notification); err != nil {
+				glog.Errorf("Unable to encode notification: %v\n", err)
+				return
+			}
+		}
+		select {
+		case n.queue <- payload:
+		default:
+			glog.Errorf("%v notifier queue full, dropping %v notification\n", n.config.Type, notification.Event)
+		}
+	}
+}
+
+//notifyStored notifies about a PoM or alert that was received and stored
+func notifyStored(data *mtr.CTObject){
+	switch data.TypeID {
+	case mtr.ConflictingSTHPOMTypeID, mtr.ConflictingSRDPOMTypeID, cto.MonitorEquivocationPOMTypeID:
+		Notify(cto.EventPoMReceived, data)
+	case mtr.AlertTypeID:
+		Notify(cto.EventAlertReceived, data)
+	}
+}
+
+//withRetries retries deliver with an exponential backoff, up to the configured number of retries
+func withRetries(deliver func(n *notifier, payload []byte) error) func(n *notifier, payload []byte) error {
+	return func(n *notifier, payload []byte) error {
+		backoff := 500 * time.Millisecond
+		var err error
+		for attempt := 0; attempt <= n.config.Max_retries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+			if err = deliver(n, payload); err == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("giving up after %v attempts: %v", n.config.Max_retries+1, err)
+	}
+}
+
+//signPayload returns the HMAC-SHA256 of payload, hex encoded
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//postWebhook posts the payload to the webhook URL, any status other than 2xx is an error
+func postWebhook(n *notifier, payload []byte) error {
+	req, err := http.NewRequest("POST", n.config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.config.Secret) != 0 {
+		req.Header.Set("X-Gossiper-Signature", "sha256="+signPayload(n.config.Secret, payload))
+	}
+	client := &http.Client{Timeout: cto.Milliseconds(n.config.Timeout_ms)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %v returned %v", n.config.URL, resp.Status)
+	}
+	return nil
+}
+
+//runCommand runs the command with the payload on its stdin
+func runCommand(n *notifier, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cto.Milliseconds(n.config.Timeout_ms))
+	defer cancel()
+	cmd := exec.CommandContext(ctx, n.config.Command[0], n.config.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %v: %s", n.config.Command[0], err, output)
+	}
+	return nil
+}
+
+//appendJSONL appends the payload as one line to the file
+func appendJSONL(n *notifier, payload []byte) error {
+	jsonlLock.Lock()
+	defer jsonlLock.Unlock()
+	f, err := os.OpenFile(n.config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(payload, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
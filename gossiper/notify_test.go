@@ -0,0 +1,121 @@
+package main
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "path/filepath"
+  "sync"
+  "testing"
+  "time"
+
+  cto "github.com/n-ct/ct-gossiper"
+  mtr "github.com/n-ct/ct-monitor"
+)
+
+//waitForFile waits until the file has n lines
+func waitForFile(t *testing.T, path string, n int) [][]byte {
+  for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+      continue
+    }
+    if lines := bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n")); len(lines) == n {
+      return lines
+    }
+  }
+  t.Fatalf("Expected %v lines in %v", n, path)
+  return nil
+}
+
+func TestNotifyWebhook(t *testing.T){
+  var mu sync.Mutex
+  var attempts int
+  received := make(chan []byte, 1)
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request){
+    body, _ := ioutil.ReadAll(req.Body)
+    if req.Header.Get("X-Gossiper-Signature") != "sha256="+signPayload("secret", body) {
+      t.Errorf("Unexpected signature %v", req.Header.Get("X-Gossiper-Signature"))
+    }
+    mu.Lock()
+    attempts++
+    first := attempts == 1
+    mu.Unlock()
+    if first {
+      http.Error(w, "try again", http.StatusInternalServerError)
+      return
+    }
+    received <- body
+  }))
+  defer server.Close()
+
+  SetNotifiers([]cto.NotifierConfig{{Type: cto.NotifierWebhook, URL: server.URL, Secret: "secret", Max_retries: 1, Timeout_ms: 1000}})
+  defer CloseNotifiers(context.Background())
+  pom := &mtr.CTObject{TypeID: mtr.ConflictingSTHPOMTypeID, Version: version, Timestamp: timestamp, Signer: "monitor1", Subject: loggerSigner}
+  Notify(cto.EventPoMCreated, pom)
+
+  select {
+  case body := <-received:
+    var notification Notification
+    if err := json.Unmarshal(body, &notification); err != nil {
+      t.Fatal(err)
+    }
+    if notification.Event != cto.EventPoMCreated || notification.LogID != loggerSigner || notification.Object.TypeID != mtr.ConflictingSTHPOMTypeID {
+      t.Errorf("Unexpected notification %+v", notification)
+    }
+  case <-time.After(5 * time.Second):
+    t.Fatal("Webhook was not retried")
+  }
+}
+
+func TestNotifyFilters(t *testing.T){
+  path := filepath.Join(t.TempDir(), "notifications.jsonl")
+  SetNotifiers([]cto.NotifierConfig{{Type: cto.NotifierJSONL, Path: path, Events: []string{cto.EventPoMReceived, cto.EventAlertReceived}, Log_ids: []string{"logA"}, Type_ids: []string{mtr.ConflictingSTHPOMTypeID, mtr.AlertTypeID}}})
+
+  testTables := []struct {
+    event string
+    data mtr.CTObject
+  }{
+    {cto.EventPoMReceived, mtr.CTObject{TypeID: mtr.ConflictingSTHPOMTypeID, Signer: "monitor2", Subject: "logA"}},
+    {cto.EventPoMCreated, mtr.CTObject{TypeID: mtr.ConflictingSTHPOMTypeID, Signer: "monitor1", Subject: "logA"}},
+    {cto.EventPoMReceived, mtr.CTObject{TypeID: mtr.ConflictingSTHPOMTypeID, Signer: "monitor2", Subject: "logB"}},
+    {cto.EventAlertReceived, mtr.CTObject{TypeID: mtr.STHTypeID, Signer: "logA"}},
+    {cto.EventAlertReceived, mtr.CTObject{TypeID: mtr.AlertTypeID, Signer: "monitor2", Subject: "logA"}},
+  }
+  for _, testTable := range testTables{
+    data := testTable.data
+    Notify(testTable.event, &data)
+  }
+  CloseNotifiers(context.Background())
+
+  lines := waitForFile(t, path, 2)
+  expected := []string{cto.EventPoMReceived, cto.EventAlertReceived}
+  for i, line := range lines {
+    var notification Notification
+    if err := json.Unmarshal(line, &notification); err != nil {
+      t.Fatal(err)
+    }
+    if notification.Event != expected[i] || notification.LogID != "logA" {
+      t.Errorf("Line %v: unexpected notification %+v", i, notification)
+    }
+  }
+}
+
+func TestNotifyCommand(t *testing.T){
+  path := filepath.Join(t.TempDir(), "payload.json")
+  SetNotifiers([]cto.NotifierConfig{{Type: cto.NotifierCommand, Command: []string{"sh", "-c", "cat > " + path}, Timeout_ms: 5000}})
+  Notify(cto.EventAlertReceived, &mtr.CTObject{TypeID: mtr.AlertTypeID, Signer: "monitor2", Subject: "logA"})
+  CloseNotifiers(context.Background())
+
+  lines := waitForFile(t, path, 1)
+  var notification Notification
+  if err := json.Unmarshal(lines[0], &notification); err != nil {
+    t.Fatal(err)
+  }
+  if notification.Event != cto.EventAlertReceived || notification.LogID != "logA" {
+    t.Errorf("Unexpected notification %+v", notification)
+  }
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+const (
+	monitorQueue = "monitor" //name of the queue to our monitor, peer queues are named by MonitorID
+	defaultQueueSize = 1000
+)
+
+// outboundItem is an object waiting to be posted.
+type outboundItem struct{
+	address string
+	data *mtr.CTObject
+	withoutBlob bool
+}
+
+// outboundQueue sends objects to one destination in order, from its own goroutine,
+// so a slow peer does not hold up the handler or the other peers.
+type outboundQueue struct{
+	name string
+	items chan outboundItem
+	done chan struct{} //closed once the queue has been drained
+	draining chan struct{}
+}
+
+var outboundQueues = make(map[string]*outboundQueue);
+var outboundLock sync.Mutex;
+var draining = make(chan struct{}); //closed on shutdown, queues exit once empty. Guarded by outboundLock
+var drainOnce sync.Once;
+
+//enqueue adds an object to the queue of the named destination, returns false if the queue is full
+func enqueue(name string, item outboundItem) bool {
+	q := getQueue(name)
+	select {
+	case q.items <- item:
+		return true
+	default:
+		glog.Errorf("Outbound queue %v full, dropping %s\n", name, cto.IdentifierToString(item.data.Identifier()))
+		return false
+	}
+}
+
+//getQueue returns the queue of the named destination, starting it if needed
+func getQueue(name string) *outboundQueue {
+	outboundLock.Lock()
+	defer outboundLock.Unlock()
+	if q, ok := outboundQueues[name]; ok {
+		return q
+	}
+	size := defaultQueueSize
+	if config := getConfig(); config != nil {
+		size = config.Queues.Peer_queue_size
+		if name == monitorQueue {
+			size = config.Queues.Monitor_queue_size
+		}
+	}
+	q := &outboundQueue{name: name, items: make(chan outboundItem, size), done: make(chan struct{}), draining: draining}
+	outboundQueues[name] = q
+	go q.run()
+	return q
+}
+
+//run posts every queued object until the queue is empty after shutdown started
+func (q *outboundQueue) run(){
+	defer close(q.done)
+	for {
+		select {
+		case item := <-q.items:
+			q.send(item)
+		case <-q.draining:
+			for {
+				select {
+				case item := <-q.items:
+					q.send(item)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+//send posts one object, recording timeouts against the peer
+func (q *outboundQueue) send(item outboundItem){
+	start := time.Now()
+	err := Post(item.address, item.data, item.withoutBlob)
+	observeSend(q.name, start, err)
+	recordExchange(q.name, err)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() && q.name != monitorQueue {
+		scores.Record(q.name, PeerEventTimeout)
+	}
+}
+
+//queueDepths returns the number of objects waiting in each queue
+func queueDepths() map[string]int {
+	outboundLock.Lock()
+	defer outboundLock.Unlock()
+	depths := make(map[string]int, len(outboundQueues))
+	for name, q := range outboundQueues {
+		depths[name] = len(q.items)
+	}
+	return depths
+}
+
+// DrainQueues lets every queue send what it holds and waits until they are empty or ctx is done.
+// Objects still waiting when ctx is done are taken off the queues and returned so they can be spooled.
+func DrainQueues(ctx context.Context) []spooledItem {
+	outboundLock.Lock()
+	drainOnce.Do(func(){ close(draining) })
+	queues := make([]*outboundQueue, 0, len(outboundQueues))
+	for _, q := range outboundQueues {
+		queues = append(queues, q)
+	}
+	outboundLock.Unlock()
+
+	var leftover []spooledItem
+	for _, q := range queues {
+		select {
+		case <-q.done:
+			continue
+		case <-ctx.Done():
+		}
+		for empty := false; !empty; {
+			select {
+			case item := <-q.items:
+				leftover = append(leftover, spooledItem{Queue: q.name, Address: item.address, Data: *item.data, Without_blob: item.withoutBlob})
+			default:
+				empty = true
+			}
+		}
+	}
+	return leftover
+}
+
+// spooledItem is an outbound object written to disk on shutdown and queued again on startup.
+type spooledItem struct{
+	Queue string `json:"queue"`
+	Address string `json:"address"`
+	Data mtr.CTObject `json:"data"`
+	Without_blob bool `json:"without_blob"`
+}
+
+//spoolPath returns the file outbound objects are spooled to, next to the store
+func spoolPath(config *cto.GossipConfig) string {
+	return config.Storage.Path + ".outbound"
+}
+
+//SaveSpool writes the objects that could not be sent before shutdown to path
+func SaveSpool(path string, items []spooledItem) error {
+	jsonStr, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, jsonStr, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+//LoadSpool queues the objects spooled by the previous run again and removes the spool, a missing file is not an error
+func LoadSpool(path string) error {
+	byteData, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var items []spooledItem
+	if err := json.Unmarshal(byteData, &items); err != nil {
+		return err
+	}
+	for i := range items {
+		enqueue(items[i].Queue, outboundItem{address: items[i].Address, data: &items[i].Data, withoutBlob: items[i].Without_blob})
+	}
+	glog.Infof("Queued %v spooled objects from %v\n", len(items), path)
+	return os.Remove(path)
+}
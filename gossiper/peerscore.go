@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+)
+
+//events that change the score of a peer
+const (
+	PeerEventInvalid = "invalid" //malformed JSON or invalid signature
+	PeerEventDigestMismatch = "digest_mismatch"
+	PeerEventTimeout = "timeout"
+	PeerEventNewObject = "new_object" //peer sent us something we did not have
+)
+
+var peerEventScores = map[string]int{
+	PeerEventInvalid: -10,
+	PeerEventDigestMismatch: -20,
+	PeerEventTimeout: -5,
+	PeerEventNewObject: 1,
+}
+
+const (
+	maxPeerScore = 100
+	minPeerScore = -100
+
+	defaultRejectThreshold = -50
+	defaultForwardThreshold = -20
+	defaultBanSeconds = 600
+
+	maxTrackedScores = 4096 //number of senders scored before idle ones are dropped
+	scoreIdleTimeout = time.Hour //scores of senders not seen for this long can be dropped
+)
+
+var scores *PeerScores;
+
+// PeerScore is the reputation of a single sender.
+// Known peers are tracked by MonitorID, everyone else by source IP.
+type PeerScore struct{
+	Score int `json:"score"`
+	Events map[string]uint64 `json:"events"` //[event]count
+	BannedUntil *time.Time `json:"banned_until,omitempty"`
+	lastSeen time.Time
+}
+
+// PeerScores keeps the score of every sender and decides who is banned.
+// At most maxTrackedScores senders are kept, the ones seen least recently are dropped first
+// but banned senders are kept until their ban expires.
+type PeerScores struct{
+	config cto.PeerScoringConfig
+
+	mu sync.Mutex
+	scores map[string]*PeerScore
+}
+
+//NewPeerScores creates PeerScores from the configuration, filling in default thresholds
+func NewPeerScores(config cto.PeerScoringConfig) *PeerScores {
+	return &PeerScores{config: withScoringDefaults(config), scores: make(map[string]*PeerScore)}
+}
+
+//SetConfig replaces the thresholds, current scores and bans are kept
+func (ps *PeerScores) SetConfig(config cto.PeerScoringConfig){
+	ps.mu.Lock()
+	ps.config = withScoringDefaults(config)
+	ps.mu.Unlock()
+}
+
+//withScoringDefaults fills in the thresholds that are not set, a threshold set to 0 is kept
+func withScoringDefaults(config cto.PeerScoringConfig) cto.PeerScoringConfig {
+	if config.Reject_threshold == nil {
+		threshold := defaultRejectThreshold
+		config.Reject_threshold = &threshold
+	}
+	if config.Forward_threshold == nil {
+		threshold := defaultForwardThreshold
+		config.Forward_threshold = &threshold
+	}
+	if config.Ban_seconds == 0 {
+		config.Ban_seconds = defaultBanSeconds
+	}
+	return config
+}
+
+//Record updates the score of peer for the given event and bans it if the score gets too low
+func (ps *PeerScores) Record(peer string, event string){
+	if ps == nil || len(peer) == 0 {
+		return
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	now := time.Now()
+	score := ps.get(peer, now)
+	score.lastSeen = now
+	score.Events[event]++
+	score.Score += peerEventScores[event]
+	if score.Score > maxPeerScore {
+		score.Score = maxPeerScore
+	}
+	if score.Score < minPeerScore {
+		score.Score = minPeerScore
+	}
+
+	if ps.config.Enabled && score.BannedUntil == nil && score.Score <= *ps.config.Reject_threshold {
+		until := now.Add(time.Duration(ps.config.Ban_seconds) * time.Second)
+		score.BannedUntil = &until
+		glog.Infof("Banned peer %v until %v, score %v\n", peer, until, score.Score)
+	}
+}
+
+//Accepting returns false if requests from peer should be refused
+func (ps *PeerScores) Accepting(peer string) bool {
+	if ps == nil || len(peer) == 0 {
+		return true
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if !ps.config.Enabled {
+		return true
+	}
+	if _, ok := ps.scores[peer]; !ok {
+		return true
+	}
+	return ps.get(peer, time.Now()).BannedUntil == nil
+}
+
+//Forwarding returns false if gossip should not be sent to peer
+func (ps *PeerScores) Forwarding(peer string) bool {
+	if ps == nil {
+		return true
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if !ps.config.Enabled {
+		return true
+	}
+	if _, ok := ps.scores[peer]; !ok {
+		return true
+	}
+	score := ps.get(peer, time.Now())
+	return score.BannedUntil == nil && score.Score >= *ps.config.Forward_threshold
+}
+
+//Snapshot returns a copy of all scores
+func (ps *PeerScores) Snapshot() map[string]PeerScore {
+	if ps == nil {
+		return map[string]PeerScore{}
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	now := time.Now()
+	snapshot := make(map[string]PeerScore, len(ps.scores))
+	for peer := range ps.scores {
+		score := ps.get(peer, now)
+		events := make(map[string]uint64, len(score.Events))
+		for event, count := range score.Events {
+			events[event] = count
+		}
+		snapshot[peer] = PeerScore{Score: score.Score, Events: events, BannedUntil: score.BannedUntil}
+	}
+	return snapshot
+}
+
+//get returns the score of peer, lifting an expired ban. Must be called with ps.mu held
+func (ps *PeerScores) get(peer string, now time.Time) *PeerScore {
+	score, ok := ps.scores[peer]
+	if !ok {
+		if len(ps.scores) >= maxTrackedScores {
+			ps.evict(now)
+		}
+		score = &PeerScore{Events: make(map[string]uint64)}
+		ps.scores[peer] = score
+	}
+	if score.BannedUntil != nil && now.After(*score.BannedUntil) {
+		glog.Infof("Ban on peer %v expired\n", peer)
+		score.BannedUntil = nil
+		score.Score = 0
+	}
+	return score
+}
+
+//evict drops the scores of senders that are not banned and have not been seen for scoreIdleTimeout,
+//or the least recently seen one if there are none. Must be called with ps.mu held
+func (ps *PeerScores) evict(now time.Time){
+	oldest := ""
+	for peer, score := range ps.scores {
+		if score.BannedUntil != nil && now.Before(*score.BannedUntil) {
+			continue
+		}
+		if now.Sub(score.lastSeen) > scoreIdleTimeout {
+			delete(ps.scores, peer)
+		} else if len(oldest) == 0 || score.lastSeen.Before(ps.scores[oldest].lastSeen) {
+			oldest = peer
+		}
+	}
+	if len(ps.scores) >= maxTrackedScores && len(oldest) != 0 {
+		delete(ps.scores, oldest)
+	}
+}
+
+//requestSender returns the key used to score the sender of a request, the peer authenticated by its
+//client certificate or else the source IP, never the unauthenticated requesterAddress header
+func requestSender(req *http.Request) string {
+	if peerID := requestPeerID(req); len(peerID) != 0 {
+		return peerID
+	}
+	return sourceIP(req)
+}
+
+//PeerScoresHandler returns the score of every known sender as JSON
+func PeerScoresHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scores.Snapshot())
+}
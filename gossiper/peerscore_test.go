@@ -0,0 +1,105 @@
+package main
+
+import (
+  "bytes"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+
+  cto "github.com/n-ct/ct-gossiper"
+)
+
+//threshold returns a pointer to score, for the optional thresholds of PeerScoringConfig
+func threshold(score int) *int {
+  return &score
+}
+
+func TestPeerScoresBan(t *testing.T){
+  ps := NewPeerScores(cto.PeerScoringConfig{Enabled: true, Reject_threshold: threshold(-30), Forward_threshold: threshold(-10), Ban_seconds: 60})
+
+  testTables := []struct {
+    event string
+    score int
+    accepting bool
+    forwarding bool
+  }{
+    {PeerEventNewObject, 1, true, true},
+    {PeerEventInvalid, -9, true, true},
+    {PeerEventTimeout, -14, true, false},
+    {PeerEventDigestMismatch, -34, false, false},
+  }
+
+  for _, testTable := range testTables{
+    ps.Record("monitor2", testTable.event)
+    score := ps.Snapshot()["monitor2"]
+    if score.Score != testTable.score {
+      t.Errorf("After %s got score %v want %v", testTable.event, score.Score, testTable.score)
+    }
+    if ps.Accepting("monitor2") != testTable.accepting {
+      t.Errorf("After %s got accepting %v want %v", testTable.event, !testTable.accepting, testTable.accepting)
+    }
+    if ps.Forwarding("monitor2") != testTable.forwarding {
+      t.Errorf("After %s got forwarding %v want %v", testTable.event, !testTable.forwarding, testTable.forwarding)
+    }
+  }
+
+  //expire the ban
+  expired := time.Now().Add(-time.Second)
+  ps.scores["monitor2"].BannedUntil = &expired
+  if !ps.Accepting("monitor2") || ps.Snapshot()["monitor2"].Score != 0 {
+    t.Errorf("Expected expired ban to be lifted and score reset, got %+v", ps.Snapshot()["monitor2"])
+  }
+}
+
+func TestGossipHandlerBannedPeer(t *testing.T){
+  defer func(){ scores = nil }()
+  scores = NewPeerScores(cto.PeerScoringConfig{Enabled: true, Reject_threshold: threshold(-20)})
+
+  expected := []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusForbidden}
+  for i, code := range expected {
+    recorder := httptest.NewRecorder()
+    req := httptest.NewRequest("POST", GossipPath, bytes.NewBufferString("not json"))
+    GossipHandler(recorder, req)
+    if recorder.Code != code {
+      t.Errorf("Request %d returned %v want %v", i, recorder.Code, code)
+    }
+  }
+}
+
+func TestPeerScoresThresholds(t *testing.T){
+  testTables := []struct {
+    name string
+    config cto.PeerScoringConfig
+    reject int
+    forward int
+  }{
+    {"unset", cto.PeerScoringConfig{}, defaultRejectThreshold, defaultForwardThreshold},
+    {"set to 0", cto.PeerScoringConfig{Reject_threshold: threshold(-40), Forward_threshold: threshold(0)}, -40, 0},
+  }
+  for _, testTable := range testTables{
+    config := NewPeerScores(testTable.config).config
+    if *config.Reject_threshold != testTable.reject || *config.Forward_threshold != testTable.forward {
+      t.Errorf("%s: got thresholds %v and %v want %v and %v", testTable.name, *config.Reject_threshold, *config.Forward_threshold, testTable.reject, testTable.forward)
+    }
+  }
+}
+
+func TestPeerScoresEviction(t *testing.T){
+  ps := NewPeerScores(cto.PeerScoringConfig{Enabled: true, Reject_threshold: threshold(-10)})
+  ps.Record("banned", PeerEventInvalid)
+  for i := 0; i < maxTrackedScores + 10; i++ {
+    ps.Record(fmt.Sprintf("10.0.%d.%d", i / 256, i % 256), PeerEventNewObject)
+  }
+  snapshot := ps.Snapshot()
+  if len(snapshot) > maxTrackedScores {
+    t.Errorf("Expected at most %v scores, got %v", maxTrackedScores, len(snapshot))
+  }
+  if _, ok := snapshot["banned"]; !ok || ps.Accepting("banned") {
+    t.Errorf("Expected the banned sender to be kept until its ban expires")
+  }
+  if _, ok := snapshot["10.0.0.0"]; ok {
+    t.Errorf("Expected the least recently seen sender to be dropped")
+  }
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	ct "github.com/google/certificate-transparency-go"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+const pollinationClockSkew = 5 * time.Minute //STHs timestamped this far in the future are still fresh
+
+// freshness is the range of STH timestamps, in milliseconds, that take part in STH pollination.
+type freshness struct{
+	oldest uint64
+	newest uint64
+}
+
+//freshnessAt returns the timestamps within window before now
+func freshnessAt(now time.Time, window time.Duration) freshness {
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	f := freshness{newest: uint64(nowMs + int64(pollinationClockSkew / time.Millisecond))}
+	if age := int64(window / time.Millisecond); age < nowMs {
+		f.oldest = uint64(nowMs - age)
+	}
+	return f
+}
+
+func (f freshness) contains(timestamp uint64) bool {
+	return timestamp >= f.oldest && timestamp <= f.newest
+}
+
+// PollinationHandler is called on a Post request to /.well-known/ct-gossip/v1/sth-pollination.
+// Clients that cannot gossip send the STHs they have seen and get back the fresh STHs we know of.
+// Only STHs of known logs issued within the freshness window are accepted or returned, so that an
+// STH cannot be used to track a client, and only the latest STH of each log is returned.
+// Accepted STHs are validated with the log keys and handled like gossip, the others are dropped.
+// Only peers authenticated by their client certificate are scored and banned, anonymous clients
+// may share an address behind NAT and are only limited by the admission source rate.
+func PollinationHandler(w http.ResponseWriter, req *http.Request){
+	config := getConfig()
+	if config == nil || !config.Pollination.Enabled {
+		http.Error(w, "sth pollination disabled", http.StatusNotFound)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sender := requestPeerID(req) //empty for anonymous clients, which are never scored
+	if !scores.Accepting(sender) {
+		glog.Infof("Refused pollination from banned sender %v\n", sender)
+		http.Error(w, "peer banned", http.StatusForbidden)
+		return
+	}
+	var pollination cto.Pollination
+	if err := json.NewDecoder(req.Body).Decode(&pollination); err != nil {
+		scores.Record(sender, PeerEventInvalid)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fresh := freshnessAt(time.Now(), time.Duration(config.Pollination.Freshness_window_seconds) * time.Second)
+	sths := pollination.STHs
+	if len(sths) > config.Pollination.Max_sths {
+		sths = sths[:config.Pollination.Max_sths]
+	}
+	for i := range sths {
+		receivePollinatedSTH(&sths[i], fresh, sender)
+	}
+	writeJSON(w, cto.Pollination{STHs: freshSTHs(fresh, config.Pollination.Max_sths)})
+}
+
+//receivePollinatedSTH stores and gossips a fresh STH of a known log, other STHs are dropped. sender is scored unless it is empty
+func receivePollinatedSTH(sth *cto.PollinationSTH, fresh freshness, sender string){
+	if sth.Version != ct.V1 || !fresh.contains(sth.Timestamp) || getLogs().FindLogByLogID(sth.LogID) == nil {
+		return
+	}
+	data, err := cto.STHFromGetSTH(sth.LogID, &sth.GetSTHResponse)
+	if err != nil {
+		scores.Record(sender, PeerEventInvalid)
+		return
+	}
+	record := &AuditRecord{Time: time.Now().UTC()} //the client is left out so that the STH cannot be linked to it
+	defer writeAudit(record)
+	ingestObject(record, data, sender, "")
+}
+
+//freshSTHs returns the latest fresh STH of each known log, at most max of them
+func freshSTHs(fresh freshness, max int) []cto.PollinationSTH {
+	logs := getLogs()
+	latest := make(map[string]*mtr.SignedTreeHeadData)
+	storeLock.RLock()
+	for _, typeID := range []string{mtr.STHTypeID, mtr.STHPOCTypeID} {
+		for logID, byTimestamp := range messages[typeID] {
+			for timestamp, byVersion := range byTimestamp {
+				if !fresh.contains(timestamp) {
+					continue
+				}
+				for _, data := range byVersion {
+					sth, err := data.DeconstructSTH()
+					if err != nil {
+						continue
+					}
+					if current, ok := latest[logID]; !ok || current.TreeHeadData.Timestamp < sth.TreeHeadData.Timestamp {
+						latest[logID] = sth
+					}
+				}
+			}
+		}
+	}
+	storeLock.RUnlock()
+
+	var logIDs []string
+	for logID := range latest {
+		if logs.FindLogByLogID(logID) != nil {
+			logIDs = append(logIDs, logID)
+		}
+	}
+	sort.Strings(logIDs)
+	sths := []cto.PollinationSTH{}
+	for _, logID := range logIDs {
+		if len(sths) >= max {
+			break
+		}
+		sth, err := cto.NewPollinationSTH(latest[logID])
+		if err != nil {
+			continue
+		}
+		sths = append(sths, sth)
+	}
+	return sths
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+
+  cto "github.com/n-ct/ct-gossiper"
+  mtr "github.com/n-ct/ct-monitor"
+)
+
+//pollinate sends the STHs to the pollination endpoint and returns the response
+func pollinate(t *testing.T, sths ...*mtr.SignedTreeHeadData) *httptest.ResponseRecorder {
+  pollination := cto.Pollination{}
+  for _, sth := range sths {
+    p, err := cto.NewPollinationSTH(sth)
+    if err != nil {
+      t.Fatal(err)
+    }
+    pollination.STHs = append(pollination.STHs, p)
+  }
+  body, _ := json.Marshal(pollination)
+  recorder := httptest.NewRecorder()
+  PollinationHandler(recorder, httptest.NewRequest("POST", cto.PollinationPath, bytes.NewBuffer(body)))
+  return recorder
+}
+
+func TestPollinationHandler(t *testing.T){
+  mustGossiperSetup(t)
+  logA := addTestLog(t)
+  logB := addTestLog(t)
+  unknown := newTestKey(t)
+  now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+  hour := uint64(time.Hour / time.Millisecond)
+
+  if code := pollinate(t).Code; code != http.StatusNotFound {
+    t.Errorf("Expected pollination to be disabled by default, got %v", code)
+  }
+  gossipConfig.Pollination = cto.PollinationConfig{Enabled: true, Freshness_window_seconds: 24 * 3600, Max_sths: 10}
+
+  forged := unknown.signedTreeHead(t, now-1000, 10, 1)
+  forged.LogID = logB.id
+  testTables := []struct {
+    name string
+    sth *mtr.SignedTreeHeadData
+    stored bool
+  }{
+    {"fresh STH", logA.signedTreeHead(t, now-1000, 10, 1), true},
+    {"older fresh STH", logA.signedTreeHead(t, now-2000, 8, 2), true},
+    {"stale STH", logB.signedTreeHead(t, now-25*hour, 5, 1), false},
+    {"STH from the future", logB.signedTreeHead(t, now+hour, 12, 1), false},
+    {"unknown log", unknown.signedTreeHead(t, now-1000, 10, 1), false},
+    {"forged signature", forged, false},
+  }
+  var sths []*mtr.SignedTreeHeadData
+  for _, testTable := range testTables{
+    sths = append(sths, testTable.sth)
+  }
+  recorder := pollinate(t, sths...)
+  if recorder.Code != http.StatusOK {
+    t.Fatalf("Pollination returned %v: %v", recorder.Code, recorder.Body.String())
+  }
+  for _, testTable := range testTables{
+    data := mustConstruct(t, testTable.sth)
+    _, stored := GetObject(data.Identifier())
+    if stored != testTable.stored {
+      t.Errorf("%v: expected stored %v, got %v", testTable.name, testTable.stored, stored)
+    }
+  }
+  //the forged STH does not count against the address of the client, which may be shared
+  if snapshot := scores.Snapshot(); len(snapshot) != 0 {
+    t.Errorf("Expected anonymous clients not to be scored, got %v", snapshot)
+  }
+
+  var resp cto.Pollination
+  if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+    t.Fatal(err)
+  }
+  if len(resp.STHs) != 1 || resp.STHs[0].LogID != logA.id || resp.STHs[0].Timestamp != now-1000 {
+    t.Errorf("Expected only the latest STH of %v, got %+v", logA.id, resp.STHs)
+  }
+
+  testTables2 := []struct {
+    name string
+    stored *mtr.SignedTreeHeadData
+    maxSTHs int
+    returned int
+  }{
+    {"stored STH no longer fresh", logB.signedTreeHead(t, now-25*hour, 5, 1), 10, 1},
+    {"fresh STH of another log", logB.signedTreeHead(t, now-500, 12, 3), 10, 2},
+    {"max_sths", nil, 1, 1},
+  }
+  for _, testTable := range testTables2{
+    if testTable.stored != nil {
+      data := mustConstruct(t, testTable.stored)
+      storeEntry(messages, data, data.Identifier())
+    }
+    gossipConfig.Pollination.Max_sths = testTable.maxSTHs
+    resp := cto.Pollination{}
+    if err := json.NewDecoder(pollinate(t).Body).Decode(&resp); err != nil {
+      t.Fatal(err)
+    }
+    if len(resp.STHs) != testTable.returned {
+      t.Errorf("%v: expected %v STHs, got %v", testTable.name, testTable.returned, len(resp.STHs))
+    }
+  }
+}
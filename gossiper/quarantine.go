@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+const (
+	maxQuarantined = 1000 //objects kept waiting for their signer, the oldest is dropped first
+	maxQuarantinedPerSender = 100 //objects kept for a single sender, its oldest is dropped first
+)
+
+// quarantinedObject is an object whose signer was not in the log or monitor list when it arrived.
+// It is validated again every time the lists are reloaded.
+type quarantinedObject struct{
+	data mtr.CTObject
+	sender string
+	requesterAddress string
+	received time.Time
+}
+
+var quarantine = make(map[string]*quarantinedObject); //[identifier+digest]
+var quarantineLock sync.Mutex;
+
+func quarantineKey(data *mtr.CTObject) string {
+	return cto.IdentifierToString(data.Identifier()) + base64.StdEncoding.EncodeToString(data.Digest)
+}
+
+// quarantineObject keeps data from sender until its signer is known. A sender can only fill
+// its own share of the quarantine, so that one sender cannot push out the objects of the others.
+func quarantineObject(data *mtr.CTObject, sender string, requesterAddress string){
+	quarantineLock.Lock()
+	defer quarantineLock.Unlock()
+	key := quarantineKey(data)
+	if _, ok := quarantine[key]; ok {
+		return
+	}
+	if oldestKey, count := oldestQuarantined(sender); count >= maxQuarantinedPerSender {
+		delete(quarantine, oldestKey)
+	} else if len(quarantine) >= maxQuarantined {
+		oldestKey, _ := oldestQuarantined("")
+		delete(quarantine, oldestKey)
+	}
+	quarantine[key] = &quarantinedObject{data: *data, sender: sender, requesterAddress: requesterAddress, received: time.Now()}
+}
+
+//oldestQuarantined returns the key of the oldest object of sender, of any sender if it is empty, and how many objects it has. Must be called with quarantineLock held
+func oldestQuarantined(sender string) (string, int) {
+	var oldestKey string
+	var oldest time.Time
+	count := 0
+	for k, q := range quarantine {
+		if len(sender) != 0 && q.sender != sender {
+			continue
+		}
+		count++
+		if len(oldestKey) == 0 || q.received.Before(oldest) {
+			oldestKey, oldest = k, q.received
+		}
+	}
+	return oldestKey, count
+}
+
+//quarantineSize returns the number of quarantined objects
+func quarantineSize() int {
+	quarantineLock.Lock()
+	defer quarantineLock.Unlock()
+	return len(quarantine)
+}
+
+// RevalidateQuarantine validates the quarantined objects again, each as if its sender sent it
+// once more. Objects that are now valid are stored and gossiped like new data, or resolved
+// against the stored object of the same identifier, objects whose signer is still unknown stay
+// in quarantine and the rest are dropped.
+func RevalidateQuarantine(){
+	quarantineLock.Lock()
+	pending := quarantine
+	quarantine = make(map[string]*quarantinedObject)
+	quarantineLock.Unlock()
+
+	for _, q := range pending {
+		data := q.data
+		record := &AuditRecord{Time: time.Now().UTC(), Sender: q.sender, RequesterAddress: q.requesterAddress}
+		_, response := ingestObject(record, &data, q.sender, q.requesterAddress)
+		writeAudit(record)
+		if record.Outcome != OutcomeQuarantined {
+			glog.Infof("%s Released from quarantine: %v %s\n", record.Identifier, record.Outcome, response)
+		}
+	}
+}
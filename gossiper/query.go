@@ -0,0 +1,267 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit = 1000
+)
+
+// ObjectQuery selects stored objects, empty fields match everything.
+// Since is inclusive and Until exclusive, both are timestamps in milliseconds.
+type ObjectQuery struct{
+	TypeID string
+	Signer string
+	Subject string
+	Since uint64
+	Until uint64
+	Cursor string //returned as NextCursor by the previous page
+	Limit int
+}
+
+// ObjectPage is one page of query results.
+type ObjectPage struct{
+	Objects []*mtr.CTObject `json:"objects"`
+	NextCursor string `json:"next_cursor,omitempty"` //empty on the last page
+}
+
+//matches reports whether data is selected by the query, ignoring the cursor
+func (q ObjectQuery) matches(data *mtr.CTObject) bool {
+	if len(q.TypeID) != 0 && data.TypeID != q.TypeID {
+		return false
+	}
+	if len(q.Signer) != 0 && data.Signer != q.Signer {
+		return false
+	}
+	if len(q.Subject) != 0 && data.Subject != q.Subject {
+		return false
+	}
+	if data.Timestamp < q.Since || (q.Until != 0 && data.Timestamp >= q.Until) {
+		return false
+	}
+	return true
+}
+
+//objectKey orders objects by timestamp, then by the rest of their fields, so pages stay stable as objects are added
+func objectKey(data *mtr.CTObject) string {
+	return fmt.Sprintf("%020d\x00%s\x00%s\x00%s\x00%s", data.Timestamp, data.TypeID, data.Signer, data.Subject, data.Version.String())
+}
+
+// keyedObject is a stored object with its objectKey.
+type keyedObject struct{
+	key string
+	data *mtr.CTObject
+}
+
+// keyedObjects keeps the objects with the smallest keys, it is a heap with the largest key on top.
+type keyedObjects []keyedObject
+
+func (h keyedObjects) Len() int { return len(h) }
+func (h keyedObjects) Less(i, j int) bool { return h[i].key > h[j].key }
+func (h keyedObjects) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *keyedObjects) Push(x interface{}) { *h = append(*h, x.(keyedObject)) }
+func (h *keyedObjects) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+//add adds entry if it is among the n smallest keys seen so far
+func (h *keyedObjects) add(entry keyedObject, n int){
+	if h.Len() < n {
+		heap.Push(h, entry)
+	} else if entry.key < (*h)[0].key {
+		(*h)[0] = entry
+		heap.Fix(h, 0)
+	}
+}
+
+// QueryObjects returns the page of stored objects selected by q. The type, subject and signer
+// select the maps of the store to scan and the timestamps are checked before the objects, only
+// the objects of the page and the first one of the next are kept and sorted.
+func QueryObjects(q ObjectQuery) (ObjectPage, error) {
+	after := ""
+	if len(q.Cursor) != 0 {
+		decoded, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		if err != nil {
+			return ObjectPage{}, fmt.Errorf("invalid cursor: %v", err)
+		}
+		after = string(decoded)
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultQueryLimit
+	}
+	if q.Limit > maxQueryLimit {
+		q.Limit = maxQueryLimit
+	}
+
+	var selected keyedObjects
+	collect := func(byTimestamp map[uint64]map[string]*mtr.CTObject){
+		for timestamp, byVersion := range byTimestamp {
+			if timestamp < q.Since || (q.Until != 0 && timestamp >= q.Until) {
+				continue
+			}
+			for _, data := range byVersion {
+				if !q.matches(data) {
+					continue
+				}
+				if key := objectKey(data); key > after {
+					selected.add(keyedObject{key, data}, q.Limit+1)
+				}
+			}
+		}
+	}
+	//collectUnder collects the objects under second, or under every key when it is empty
+	collectUnder := func(bySecond map[string]map[uint64]map[string]*mtr.CTObject, second string){
+		if len(second) != 0 {
+			collect(bySecond[second])
+			return
+		}
+		for _, byTimestamp := range bySecond {
+			collect(byTimestamp)
+		}
+	}
+
+	storeLock.RLock()
+	//messages are stored under [TypeID][Subject or Signer], an object with a subject is never under its signer
+	if q.TypeID != mtr.AlertTypeID {
+		if len(q.TypeID) != 0 {
+			collectUnder(messages[q.TypeID], q.Subject)
+		} else {
+			for _, bySubject := range messages {
+				collectUnder(bySubject, q.Subject)
+			}
+		}
+	}
+	//alerts are stored under [Subject][Signer]
+	if len(q.TypeID) == 0 || q.TypeID == mtr.AlertTypeID {
+		if len(q.Subject) != 0 {
+			collectUnder(alertsMap[q.Subject], q.Signer)
+		} else {
+			for _, bySigner := range alertsMap {
+				collectUnder(bySigner, q.Signer)
+			}
+		}
+	}
+	storeLock.RUnlock()
+
+	sort.Slice(selected, func(i, j int) bool { return selected[i].key < selected[j].key })
+	page := ObjectPage{Objects: []*mtr.CTObject{}}
+	for i, entry := range selected {
+		if i == q.Limit {
+			page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(selected[i-1].key))
+			break
+		}
+		page.Objects = append(page.Objects, entry.data)
+	}
+	return page, nil
+}
+
+//GetObject returns the stored object with the given identifier
+func GetObject(identifier mtr.ObjectIdentifier) (*mtr.CTObject, bool) {
+	if message, ok := lookupEntry(messages, identifier); ok {
+		return message, true
+	}
+	return lookupEntry(alertsMap, identifier)
+}
+
+//parseTimestamp reads an optional millisecond timestamp query parameter
+func parseTimestamp(req *http.Request, name string) (uint64, error) {
+	value := req.URL.Query().Get(name)
+	if len(value) == 0 {
+		return 0, nil
+	}
+	timestamp, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return timestamp, nil
+}
+
+//withoutBlobs returns copies of objects without their blob, as requested with without_blob=true
+func withoutBlobs(objects []*mtr.CTObject) []*mtr.CTObject {
+	copies := make([]*mtr.CTObject, 0, len(objects))
+	for _, data := range objects {
+		copies = append(copies, cto.CopyWithoutBlob(data))
+	}
+	return copies
+}
+
+// ObjectsHandler lists stored objects. The type, signer, subject, since and until
+// parameters filter the objects, limit sets the page size and cursor continues from
+// the next_cursor of the previous page.
+func ObjectsHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	params := req.URL.Query()
+	q := ObjectQuery{TypeID: params.Get("type"), Signer: params.Get("signer"), Subject: params.Get("subject"), Cursor: params.Get("cursor")}
+	var err error
+	if q.Since, err = parseTimestamp(req, "since"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Until, err = parseTimestamp(req, "until"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit := params.Get("limit"); len(limit) != 0 {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	page, err := QueryObjects(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if params.Get("without_blob") == "true" {
+		page.Objects = withoutBlobs(page.Objects)
+	}
+	writeJSON(w, page)
+}
+
+// ObjectHandler returns a single object by its identifier, given as the type, signer,
+// subject, timestamp and version (major.minor) parameters.
+func ObjectHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	params := req.URL.Query()
+	data := mtr.CTObject{TypeID: params.Get("type"), Signer: params.Get("signer"), Subject: params.Get("subject")}
+	timestamp, err := parseTimestamp(req, "timestamp")
+	if err != nil || len(data.TypeID) == 0 || len(params.Get("timestamp")) == 0 {
+		http.Error(w, "type and timestamp are required", http.StatusBadRequest)
+		return
+	}
+	data.Timestamp = timestamp
+	if version := params.Get("version"); len(version) != 0 {
+		if _, err := fmt.Sscanf(version, "%d.%d", &data.Version.Major, &data.Version.Minor); err != nil {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+	} else {
+		data.Version = mtr.VersionData{Major: 1}
+	}
+
+	message, ok := GetObject(data.Identifier())
+	if !ok {
+		http.Error(w, "object not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, message)
+}
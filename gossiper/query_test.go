@@ -0,0 +1,125 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+
+  mtr "github.com/n-ct/ct-monitor"
+)
+
+//storeTestObjects stores STHs from two logs at increasing timestamps and one alert
+func storeTestObjects(t *testing.T){
+  mustGossiperSetup(t)
+  for i := uint64(0); i < 5; i++ {
+    for _, signer := range []string{"logA", "logB"} {
+      data := mtr.CTObject{TypeID: mtr.STHTypeID, Version: version, Timestamp: 1000 + i, Signer: signer, Digest: []byte{byte(i)}, Blob: []byte("blob")}
+      storeEntry(messages, data, data.Identifier())
+    }
+  }
+  alert := mtr.CTObject{TypeID: mtr.AlertTypeID, Version: version, Timestamp: 1002, Signer: "monitor2", Subject: "logA", Blob: []byte("alert")}
+  storeEntry(alertsMap, alert, alert.Identifier())
+}
+
+func TestQueryObjects(t *testing.T){
+  storeTestObjects(t)
+
+  testTables := []struct {
+    query ObjectQuery
+    expected int
+  }{
+    {ObjectQuery{}, 11},
+    {ObjectQuery{TypeID: mtr.STHTypeID}, 10},
+    {ObjectQuery{Signer: "logA"}, 5},
+    {ObjectQuery{Subject: "logA"}, 1},
+    {ObjectQuery{Since: 1001, Until: 1003}, 5},
+    {ObjectQuery{TypeID: mtr.STHTypeID, Signer: "logB", Since: 1004}, 1},
+    {ObjectQuery{TypeID: mtr.STHTypeID, Subject: "logA"}, 0},
+    {ObjectQuery{TypeID: mtr.AlertTypeID, Subject: "logA", Signer: "monitor2"}, 1},
+    {ObjectQuery{TypeID: mtr.AlertTypeID, Signer: "monitor2", Until: 1002}, 0},
+    {ObjectQuery{Signer: "monitor2"}, 1},
+  }
+  for _, testTable := range testTables{
+    page, err := QueryObjects(testTable.query)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if len(page.Objects) != testTable.expected || len(page.NextCursor) != 0 {
+      t.Errorf("Query %+v returned %v objects and cursor %q, want %v", testTable.query, len(page.Objects), page.NextCursor, testTable.expected)
+    }
+  }
+}
+
+func TestQueryObjectsPagination(t *testing.T){
+  storeTestObjects(t)
+
+  seen := make(map[string]bool)
+  q := ObjectQuery{Limit: 4}
+  pages := 0
+  last := ""
+  for {
+    page, err := QueryObjects(q)
+    if err != nil {
+      t.Fatal(err)
+    }
+    pages++
+    for _, data := range page.Objects {
+      key := objectKey(data)
+      if seen[key] {
+        t.Errorf("Object %v returned twice", key)
+      }
+      if key < last {
+        t.Errorf("Object %v returned after %v", key, last)
+      }
+      seen[key], last = true, key
+    }
+    if len(page.NextCursor) == 0 {
+      break
+    }
+    q.Cursor = page.NextCursor
+  }
+  if pages != 3 || len(seen) != 11 {
+    t.Errorf("Expected 11 objects over 3 pages, got %v over %v", len(seen), pages)
+  }
+  if _, err := QueryObjects(ObjectQuery{Cursor: "not a cursor!"}); err == nil {
+    t.Errorf("Expected an invalid cursor to be rejected")
+  }
+}
+
+func TestObjectHandler(t *testing.T){
+  storeTestObjects(t)
+
+  testTables := []struct {
+    query string
+    expected int
+  }{
+    {fmt.Sprintf("type=%s&signer=logA&timestamp=1003&version=1.0", mtr.STHTypeID), http.StatusOK},
+    {fmt.Sprintf("type=%s&signer=monitor2&subject=logA&timestamp=1002", mtr.AlertTypeID), http.StatusOK},
+    {fmt.Sprintf("type=%s&signer=logA&timestamp=999", mtr.STHTypeID), http.StatusNotFound},
+    {"signer=logA&timestamp=1003", http.StatusBadRequest},
+    {fmt.Sprintf("type=%s&signer=logA&timestamp=1003&version=x", mtr.STHTypeID), http.StatusBadRequest},
+  }
+  for _, testTable := range testTables{
+    recorder := httptest.NewRecorder()
+    ObjectHandler(recorder, httptest.NewRequest("GET", GossipPath+"?"+testTable.query, nil))
+    if recorder.Code != testTable.expected {
+      t.Errorf("Query %v returned %v, want %v", testTable.query, recorder.Code, testTable.expected)
+    }
+  }
+}
+
+func TestObjectsHandlerWithoutBlob(t *testing.T){
+  storeTestObjects(t)
+
+  recorder := httptest.NewRecorder()
+  ObjectsHandler(recorder, httptest.NewRequest("GET", "/ct/v1/objects?signer=logA&without_blob=true&limit=2", nil))
+  var page ObjectPage
+  if err := json.NewDecoder(recorder.Body).Decode(&page); err != nil {
+    t.Fatal(err)
+  }
+  if len(page.Objects) != 2 || page.Objects[0].Blob != nil || len(page.NextCursor) == 0 {
+    t.Errorf("Unexpected page %+v", page)
+  }
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/time/rate"
+	cto "github.com/n-ct/ct-gossiper"
+)
+
+//reasons a request can be rejected by admission control
+const (
+	RejectPeerRate = "peer_rate"
+	RejectSourceRate = "source_rate"
+	RejectInFlight = "in_flight"
+	RejectTooLarge = "too_large"
+)
+
+const (
+	limiterIdleTimeout = 10 * time.Minute //limiters unused for this long can be dropped
+	maxTrackedLimiters = 4096 //number of limiters kept, the least recently used are dropped first
+)
+
+var admission *AdmissionControl;
+
+// AdmissionControl decides if an incoming request is handled or rejected.
+// Each peer and each source IP has its own token bucket, and a fixed number of
+// slots limits how many handlers run at the same time.
+type AdmissionControl struct{
+	config cto.AdmissionConfig
+	inFlight chan struct{} //nil when the number of handlers is not limited
+
+	mu sync.Mutex
+	peerLimiters map[string]*limiterEntry
+	sourceLimiters map[string]*limiterEntry
+	rejected map[string]uint64 //[reason]count
+}
+
+type limiterEntry struct{
+	limiter *rate.Limiter
+	lastSeen time.Time
+}
+
+//NewAdmissionControl creates an AdmissionControl from the admission section of the configuration
+func NewAdmissionControl(config cto.AdmissionConfig) *AdmissionControl {
+	ac := &AdmissionControl{
+		config: config,
+		peerLimiters: make(map[string]*limiterEntry),
+		sourceLimiters: make(map[string]*limiterEntry),
+		rejected: make(map[string]uint64),
+	}
+	if config.Max_in_flight > 0 {
+		ac.inFlight = make(chan struct{}, config.Max_in_flight)
+	}
+	return ac
+}
+
+// SetConfig replaces the limits. Handlers already running keep the in-flight slot they hold,
+// and the token buckets start again if the rates changed.
+func (ac *AdmissionControl) SetConfig(config cto.AdmissionConfig){
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if config.Max_in_flight != ac.config.Max_in_flight {
+		ac.inFlight = nil
+		if config.Max_in_flight > 0 {
+			ac.inFlight = make(chan struct{}, config.Max_in_flight)
+		}
+	}
+	if config.Peer_rate != ac.config.Peer_rate || config.Peer_burst != ac.config.Peer_burst {
+		ac.peerLimiters = make(map[string]*limiterEntry)
+	}
+	if config.Source_rate != ac.config.Source_rate || config.Source_burst != ac.config.Source_burst {
+		ac.sourceLimiters = make(map[string]*limiterEntry)
+	}
+	ac.config = config
+}
+
+// AdmissionHandler wraps a handler with the admission checks.
+// Rate limited requests and requests over the in-flight cap are answered with 429,
+// bodies larger than max_request_bytes with 413.
+func AdmissionHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request){
+		ac := admission
+		if ac == nil {
+			next(w, req)
+			return
+		}
+
+		if reason := ac.allow(req); len(reason) != 0 {
+			ac.reject(req, reason)
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		ac.mu.Lock()
+		inFlight := ac.inFlight
+		max := ac.config.Max_request_bytes
+		ac.mu.Unlock()
+
+		if inFlight != nil {
+			select {
+			case inFlight <- struct{}{}:
+				defer func(){ <-inFlight }()
+			default:
+				ac.reject(req, RejectInFlight)
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		if max > 0 {
+			if req.ContentLength > max {
+				ac.reject(req, RejectTooLarge)
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			body, err := ioutil.ReadAll(io.LimitReader(req.Body, max+1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > max {
+				ac.reject(req, RejectTooLarge)
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		next(w, req)
+	}
+}
+
+// AdmissionStreamHandler wraps a handler serving a long-lived stream with the rate limits only.
+// A stream would hold an in-flight slot for as long as it stays open, so it does not take one.
+func AdmissionStreamHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request){
+		if ac := admission; ac != nil {
+			if reason := ac.allow(req); len(reason) != 0 {
+				ac.reject(req, reason)
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		next(w, req)
+	}
+}
+
+//allow takes a token from the peer and source buckets and returns the reason if one of them is empty
+func (ac *AdmissionControl) allow(req *http.Request) string {
+	now := time.Now()
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	if ac.config.Peer_rate > 0 {
+		if peerID := requestPeerID(req); len(peerID) != 0 {
+			limiter := getLimiter(ac.peerLimiters, peerID, ac.config.Peer_rate, ac.config.Peer_burst, now)
+			if !limiter.AllowN(now, 1) {
+				return RejectPeerRate
+			}
+		}
+	}
+	if ac.config.Source_rate > 0 {
+		limiter := getLimiter(ac.sourceLimiters, sourceIP(req), ac.config.Source_rate, ac.config.Source_burst, now)
+		if !limiter.AllowN(now, 1) {
+			return RejectSourceRate
+		}
+	}
+	return ""
+}
+
+//reject logs and counts a rejected request
+func (ac *AdmissionControl) reject(req *http.Request, reason string){
+	ac.mu.Lock()
+	ac.rejected[reason]++
+	ac.mu.Unlock()
+	glog.Infof("Rejected request from %v (%v): %v\n", req.RemoteAddr, req.Header.Get("requesterAddress"), reason)
+	record := newAuditRecord(req)
+	record.Outcome, record.Error = OutcomeRejected, reason
+	writeAudit(record)
+}
+
+//Rejected returns a copy of the number of rejected requests by reason
+func (ac *AdmissionControl) Rejected() map[string]uint64 {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	counts := make(map[string]uint64, len(ac.rejected))
+	for reason, count := range ac.rejected {
+		counts[reason] = count
+	}
+	return counts
+}
+
+//getLimiter returns the limiter stored under key, creating it if needed. At most maxTrackedLimiters
+//are kept, idle ones are dropped first and then the least recently seen. Must be called with ac.mu held
+func getLimiter(limiters map[string]*limiterEntry, key string, r float64, burst int, now time.Time) *rate.Limiter {
+	entry, ok := limiters[key]
+	if !ok {
+		if len(limiters) >= maxTrackedLimiters {
+			evictLimiters(limiters, now)
+		}
+		if burst <= 0 {
+			burst = 1
+		}
+		entry = &limiterEntry{limiter: rate.NewLimiter(rate.Limit(r), burst)}
+		limiters[key] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter
+}
+
+//evictLimiters drops the limiters not used for limiterIdleTimeout, or the least recently used one if
+//there are none. Must be called with ac.mu held
+func evictLimiters(limiters map[string]*limiterEntry, now time.Time){
+	oldest := ""
+	for key, entry := range limiters {
+		if now.Sub(entry.lastSeen) > limiterIdleTimeout {
+			delete(limiters, key)
+		} else if len(oldest) == 0 || entry.lastSeen.Before(limiters[oldest].lastSeen) {
+			oldest = key
+		}
+	}
+	if len(limiters) >= maxTrackedLimiters && len(oldest) != 0 {
+		delete(limiters, oldest)
+	}
+}
+
+// requestPeerID returns the MonitorID of the peer that sent the request, or an empty string if
+// the request did not come with a verified client certificate for the host of exactly one of our
+// peers. The requesterAddress header is not authenticated and is never used to identify a peer,
+// without mutual TLS every sender is identified by its source IP.
+func requestPeerID(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := req.TLS.VerifiedChains[0][0]
+	peerID := ""
+	for _, peer := range getPeers() {
+		u, err := url.Parse(peer.GossiperURL)
+		if err != nil || cert.VerifyHostname(u.Hostname()) != nil {
+			continue
+		}
+		if len(peerID) != 0 && peerID != peer.MonitorID { //the certificate does not tell the peers apart
+			return ""
+		}
+		peerID = peer.MonitorID
+	}
+	return peerID
+}
+
+//sourceIP returns the IP the request came from without the port
+func sourceIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
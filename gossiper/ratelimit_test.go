@@ -0,0 +1,157 @@
+package main
+
+import (
+  "bytes"
+  "crypto/tls"
+  "crypto/x509"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+
+  cto "github.com/n-ct/ct-gossiper"
+)
+
+func okHandler(w http.ResponseWriter, req *http.Request){
+  fmt.Fprintf(w, "ok")
+}
+
+func TestAdmissionHandler(t *testing.T){
+  defer func(){ admission = nil }()
+
+  testTables := []struct {
+    name string
+    config cto.AdmissionConfig
+    body string
+    requests int
+    expected []int
+  }{
+    {"unlimited", cto.AdmissionConfig{}, "{}", 3, []int{200, 200, 200}},
+    {"source rate", cto.AdmissionConfig{Source_rate: 0.001, Source_burst: 2}, "{}", 3, []int{200, 200, 429}},
+    {"too large", cto.AdmissionConfig{Max_request_bytes: 4}, "{\"TypeID\":\"STH\"}", 1, []int{413}},
+    {"small enough", cto.AdmissionConfig{Max_request_bytes: 4}, "{}", 1, []int{200}},
+  }
+
+  for _, testTable := range testTables{
+    admission = NewAdmissionControl(testTable.config)
+    handler := AdmissionHandler(okHandler)
+    for i := 0; i < testTable.requests; i++ {
+      recorder := httptest.NewRecorder()
+      req := httptest.NewRequest("POST", GossipPath, bytes.NewBufferString(testTable.body))
+      handler.ServeHTTP(recorder, req)
+      if recorder.Code != testTable.expected[i] {
+        t.Errorf("%s: request %d returned %v want %v", testTable.name, i, recorder.Code, testTable.expected[i])
+      }
+    }
+  }
+}
+
+func TestAdmissionInFlight(t *testing.T){
+  defer func(){ admission = nil }()
+  admission = NewAdmissionControl(cto.AdmissionConfig{Max_in_flight: 1})
+
+  release := make(chan struct{})
+  started := make(chan struct{})
+  blocking := AdmissionHandler(func(w http.ResponseWriter, req *http.Request){
+    close(started)
+    <-release
+  })
+  go blocking.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", GossipPath, nil))
+  <-started
+
+  recorder := httptest.NewRecorder()
+  AdmissionHandler(okHandler).ServeHTTP(recorder, httptest.NewRequest("POST", GossipPath, nil))
+  close(release)
+  if recorder.Code != http.StatusTooManyRequests {
+    t.Errorf("Handler returned %v want %v while another request was in flight", recorder.Code, http.StatusTooManyRequests)
+  }
+  if admission.Rejected()[RejectInFlight] != 1 {
+    t.Errorf("Expected one %s rejection, got %v", RejectInFlight, admission.Rejected())
+  }
+}
+
+func TestAdmissionStream(t *testing.T){
+  defer func(){ admission = nil }()
+  admission = NewAdmissionControl(cto.AdmissionConfig{Max_in_flight: 1, Source_rate: 0.001, Source_burst: 2})
+
+  //an open stream does not hold the only in-flight slot
+  release := make(chan struct{})
+  started := make(chan struct{})
+  stream := AdmissionStreamHandler(func(w http.ResponseWriter, req *http.Request){
+    close(started)
+    <-release
+  })
+  go stream.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", cto.FeedPath, nil))
+  <-started
+  recorder := httptest.NewRecorder()
+  AdmissionHandler(okHandler).ServeHTTP(recorder, httptest.NewRequest("GET", cto.ObjectsPath, nil))
+  close(release)
+  if recorder.Code != http.StatusOK {
+    t.Errorf("Handler returned %v want %v while a stream was open", recorder.Code, http.StatusOK)
+  }
+
+  //but it is rate limited like any other request
+  recorder = httptest.NewRecorder()
+  AdmissionStreamHandler(okHandler).ServeHTTP(recorder, httptest.NewRequest("GET", cto.FeedPath, nil))
+  if recorder.Code != http.StatusTooManyRequests {
+    t.Errorf("Stream returned %v want %v once the source rate was used", recorder.Code, http.StatusTooManyRequests)
+  }
+}
+
+func TestGetLimiterBounded(t *testing.T){
+  limiters := make(map[string]*limiterEntry)
+  now := time.Now()
+  getLimiter(limiters, "0", 1, 1, now)
+  for i := 1; i < maxTrackedLimiters; i++ {
+    getLimiter(limiters, fmt.Sprint(i), 1, 1, now.Add(time.Duration(i) * time.Millisecond))
+  }
+  //nothing is idle, the least recently used limiter makes room for the new one
+  getLimiter(limiters, "new", 1, 1, now.Add(time.Second))
+  if len(limiters) != maxTrackedLimiters {
+    t.Errorf("Expected %v limiters, got %v", maxTrackedLimiters, len(limiters))
+  }
+  if _, ok := limiters["0"]; ok {
+    t.Errorf("Expected the least recently used limiter to be dropped")
+  }
+
+  //idle limiters are all dropped at once
+  getLimiter(limiters, "later", 1, 1, now.Add(limiterIdleTimeout + time.Hour))
+  if len(limiters) != 1 {
+    t.Errorf("Expected the idle limiters to be dropped, %v left", len(limiters))
+  }
+}
+
+func TestRequestPeerID(t *testing.T){
+  mustGossiperSetup(t)
+  certFor := func(name string) *tls.ConnectionState {
+    cert, err := x509.ParseCertificate(testChain(t, name)[0])
+    if err != nil {
+      t.Fatal(err)
+    }
+    return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+  }
+
+  testTables := []struct {
+    name string
+    header string
+    tls *tls.ConnectionState
+    expected string
+  }{
+    {"no identity", "", nil, ""},
+    {"header of a peer only", "http://localhost:5500", nil, ""},
+    {"certificate of a peer", "", certFor("localhost"), "monitor2"},
+    {"certificate of another host", "http://localhost:5500", certFor("example.com"), ""},
+  }
+  for _, testTable := range testTables{
+    req := httptest.NewRequest("POST", GossipPath, nil)
+    req.Header.Set("requesterAddress", testTable.header)
+    req.TLS = testTable.tls
+    if peerID := requestPeerID(req); peerID != testTable.expected {
+      t.Errorf("%s: got peer %q want %q", testTable.name, peerID, testTable.expected)
+    }
+    if sender := requestSender(req); len(testTable.expected) == 0 && sender != sourceIP(req) {
+      t.Errorf("%s: expected the sender to be the source IP, got %q", testTable.name, sender)
+    }
+  }
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+	mtrList "github.com/n-ct/ct-monitor/entitylist"
+)
+
+var reloadLock sync.Mutex; //only one reload runs at a time
+
+// Reload reads the configuration, the monitor list and the log list again.
+// The three files are validated together and only swapped in if all of them are valid,
+// requests already being handled keep using the lists they started with.
+// Quarantined objects are validated again once the new lists are in place.
+func Reload() error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	config, err := cto.NewGossipConfig(configFile)
+	if err != nil {
+		return err
+	}
+	monitors, err := mtrList.NewMonitorList(monitorsFile)
+	if err != nil {
+		return err
+	}
+	logs, err := mtrList.NewLogList(logsFile)
+	if err != nil {
+		return err
+	}
+	if err := validateReload(getConfig(), config, monitors); err != nil {
+		return err
+	}
+	client, err := newPostClient(config)
+	if err != nil {
+		return err
+	}
+
+	listsLock.Lock()
+	gossipConfig = config
+	allMonitors = monitors
+	allLogs = logs
+	postClient = client
+	listsLock.Unlock()
+
+	if admission != nil {
+		admission.SetConfig(config.Admission)
+	}
+	if scores != nil {
+		scores.SetConfig(config.Peer_scoring)
+	}
+	if err := SetAuditLog(config.Audit); err != nil {
+		glog.Errorf("Keeping the current audit log, unable to open %v: %v\n", config.Audit.Path, err)
+	}
+	SetNotifiers(config.Notifiers)
+	reloadPeers(config, monitors)
+
+	glog.Infof("Reloaded configuration: %v monitors, %v logs, %v peers\n", countMonitors(monitors), countLogs(logs), len(getPeers()))
+	RevalidateQuarantine()
+	return nil
+}
+
+//validateReload checks that the new files can replace the current ones, reporting every problem found
+func validateReload(current *cto.GossipConfig, config *cto.GossipConfig, monitors *mtrList.MonitorList) error {
+	var problems []string
+	if current != nil && config.Monitor_id != current.Monitor_id {
+		problems = append(problems, fmt.Sprintf("monitor_id changed from %v to %v, restart the gossiper instead", current.Monitor_id, config.Monitor_id))
+	}
+	if monitors.FindMonitorByMonitorID(config.Monitor_id) == nil {
+		problems = append(problems, fmt.Sprintf("monitor_id %v is not in the monitor list", config.Monitor_id))
+	}
+	for _, monitorID := range config.Monitors_ids {
+		if monitors.FindMonitorByMonitorID(monitorID) == nil {
+			problems = append(problems, fmt.Sprintf("peer %v is not in the monitor list", monitorID))
+		}
+	}
+	if current != nil && config.Membership.Enabled != current.Membership.Enabled {
+		glog.Infoln("Enabling or disabling membership requires a restart, ignoring the change")
+	}
+	if current != nil && (config.Listen_address != current.Listen_address || config.TLS != current.TLS ||
+		config.Storage != current.Storage || config.Queues != current.Queues || config.Retention != current.Retention ||
+		config.Auditor != current.Auditor || !reflect.DeepEqual(config.Fetcher, current.Fetcher) ||
+		config.SCT_feedback.Enabled != current.SCT_feedback.Enabled || config.SCT_feedback.Audit_interval_ms != current.SCT_feedback.Audit_interval_ms ||
+		config.SCT_feedback.Timeout_ms != current.SCT_feedback.Timeout_ms || config.SCT_feedback.Priv_key != current.SCT_feedback.Priv_key || config.Witness != current.Witness) {
+		glog.Infoln("Changes to listen_address, tls, storage, queues, retention, auditor, fetcher, the sct_feedback audit or witness require a restart")
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("invalid reload: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// reloadPeers points the peers at the new monitor list and adds the peers from the new
+// configuration. Changes made through the admin API are kept: peers it added stay and peers
+// it removed are not added back, with membership they are members that stay alive or removed.
+func reloadPeers(config *cto.GossipConfig, monitors *mtrList.MonitorList){
+	if membership != nil {
+		for _, monitorID := range config.Monitors_ids {
+			membership.setState(monitorID, MemberAlive) //refused for members removed by the admin
+		}
+		membership.updatePeers()
+		return
+	}
+	peersLock.Lock()
+	defer peersLock.Unlock()
+	var newPeers []*mtrList.MonitorInfo
+	added := make(map[string]bool)
+	var addedIDs []string
+	for monitorID := range adminAdded {
+		addedIDs = append(addedIDs, monitorID)
+	}
+	sort.Strings(addedIDs)
+	monitorIDs := append(append([]string{}, config.Monitors_ids...), addedIDs...)
+	for _, monitorID := range monitorIDs {
+		info := monitors.FindMonitorByMonitorID(monitorID)
+		if info == nil || adminRemoved[monitorID] || added[monitorID] {
+			continue
+		}
+		added[monitorID] = true
+		newPeers = append(newPeers, info)
+	}
+	peers = newPeers
+}
+
+// WatchFiles reloads the gossiper when the configuration, monitor list or log list
+// changes on disk. The files are checked every interval until stop is closed.
+func WatchFiles(interval time.Duration, stop <-chan struct{}){
+	modTimes := fileModTimes()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			current := fileModTimes()
+			if current == modTimes {
+				continue
+			}
+			modTimes = current
+			glog.Infoln("Configuration files changed, reloading")
+			if err := Reload(); err != nil {
+				glog.Errorf("Reload failed: %v\n", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func fileModTimes() [3]time.Time {
+	var modTimes [3]time.Time
+	for i, filename := range []string{configFile, monitorsFile, logsFile} {
+		if info, err := os.Stat(filename); err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+//AdminReloadHandler reloads the configuration, monitor list and log list
+func AdminReloadHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := Reload(); err != nil {
+		glog.Errorf("Reload failed: %v\n", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]int{"monitors": countMonitors(getMonitors()), "logs": countLogs(getLogs()), "peers": len(getPeers()), "quarantined": quarantineSize()})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "path/filepath"
+  "testing"
+
+  mtr "github.com/n-ct/ct-monitor"
+)
+
+func copyFile(t *testing.T, src string, dst string){
+  data, err := ioutil.ReadFile(src)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func TestReloadReleasesQuarantine(t *testing.T){
+  dir := t.TempDir()
+  emptyLogs := filepath.Join(dir, "log_list.json")
+  if err := ioutil.WriteFile(emptyLogs, []byte(`{"operators": []}`), 0644); err != nil {
+    t.Fatal(err)
+  }
+  messages = nil
+  if err := GossiperSetup(configFilename, monitorFilename, emptyLogs); err != nil {
+    t.Fatal(err)
+  }
+  quarantine = make(map[string]*quarantinedObject)
+  defer func(){ quarantine = make(map[string]*quarantinedObject) }()
+
+  recorder := httptest.NewRecorder()
+  jsonStr, _ := json.Marshal(sthCTObject)
+  GossipHandler(recorder, httptest.NewRequest("POST", GossipPath, bytes.NewBuffer(jsonStr)))
+  if recorder.Code != http.StatusAccepted || recorder.Body.String() != "quarantined" {
+    t.Fatalf("Handler returned %v %q for an unknown log, want quarantined", recorder.Code, recorder.Body.String())
+  }
+  if quarantineSize() != 1 {
+    t.Fatalf("Expected 1 quarantined object, got %v", quarantineSize())
+  }
+
+  copyFile(t, logFilename, emptyLogs)
+  if err := Reload(); err != nil {
+    t.Fatal(err)
+  }
+  if quarantineSize() != 0 {
+    t.Errorf("Expected quarantine to be empty after reload, got %v", quarantineSize())
+  }
+  if _, ok := lookupEntry(messages, sthCTObject.Identifier()); !ok {
+    t.Errorf("Expected STH to be stored after its log became known")
+  }
+}
+
+func TestReloadRejectsInvalidFiles(t *testing.T){
+  dir := t.TempDir()
+  config := filepath.Join(dir, "config.json")
+  copyFile(t, configFilename, config)
+  if err := GossiperSetup(config, monitorFilename, logFilename); err != nil {
+    t.Fatal(err)
+  }
+
+  testTables := []struct {
+    config string
+  }{
+    {`{"monitor_ids": ["monitor2"], "monitor_id": "monitor2"}`},
+    {`{"monitor_ids": ["monitor3"], "monitor_id": "monitor1"}`},
+    {`not json`},
+  }
+  for _, testTable := range testTables{
+    if err := ioutil.WriteFile(config, []byte(testTable.config), 0644); err != nil {
+      t.Fatal(err)
+    }
+    if err := Reload(); err == nil {
+      t.Errorf("Expected reload of %s to fail", testTable.config)
+    }
+    if getConfig().Monitor_id != "monitor1" || len(getPeers()) != 1 {
+      t.Errorf("Failed reload of %s changed the running configuration", testTable.config)
+    }
+  }
+}
+
+func TestQuarantinePerSender(t *testing.T){
+  quarantine = make(map[string]*quarantinedObject)
+  defer func(){ quarantine = make(map[string]*quarantinedObject) }()
+  object := func(i int) *mtr.CTObject {
+    data := sthCTObject
+    data.Timestamp = uint64(int64(data.Timestamp) + int64(i))
+    return &data
+  }
+  for i := 0; i < maxQuarantinedPerSender + 10; i++ {
+    quarantineObject(object(i), "flooder", "")
+  }
+  quarantineObject(object(-1), "peer", "")
+
+  counts := make(map[string]int)
+  for _, q := range quarantine {
+    counts[q.sender]++
+  }
+  if counts["flooder"] != maxQuarantinedPerSender || counts["peer"] != 1 {
+    t.Errorf("Expected %v objects of the flooder and 1 of the peer, got %v", maxQuarantinedPerSender, counts)
+  }
+  if _, ok := quarantine[quarantineKey(object(-1))]; !ok {
+    t.Errorf("Expected the object of the peer to be kept")
+  }
+}
+
+func TestQuarantineReleaseConflict(t *testing.T){
+  mustGossiperSetup(t)
+  quarantine = make(map[string]*quarantinedObject)
+  defer func(){ quarantine = make(map[string]*quarantinedObject) }()
+  log := addTestLog(t)
+  stored := log.sth(t, timestamp, 10, 1)
+  storeEntry(messages, stored, stored.Identifier())
+  //quarantined while the log was unknown, conflicting with an STH stored since
+  conflicting := log.sth(t, timestamp, 10, 2)
+  quarantineObject(&conflicting, "peer", "")
+
+  RevalidateQuarantine()
+  if quarantineSize() != 0 {
+    t.Errorf("Expected the object to be released, %v left", quarantineSize())
+  }
+  if poms := storedObjects(mtr.ConflictingSTHPOMTypeID, log.id); len(poms) != 1 {
+    t.Errorf("Expected the conflict to produce a PoM, got %v", len(poms))
+  }
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/jsonclient"
+	"github.com/google/certificate-transparency-go/tls"
+	"github.com/google/certificate-transparency-go/x509"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+	mtrList "github.com/n-ct/ct-monitor/entitylist"
+	"github.com/n-ct/ct-monitor/signature"
+)
+
+//states of an SCT received through SCT feedback, also used as the result label of inclusion audits
+const (
+	SCTPending = "pending" //waiting for the MMD of the log to pass and for an STH issued after it
+	SCTIncluded = "included"
+	SCTNotIncluded = "not_included"
+)
+
+const defaultMMDSeconds = 24 * 3600 //MMD of the logs that have none in the log list
+
+var ErrInvalidSCT = errors.New("SCT signature does not verify")
+var ErrNotIncluded = errors.New("certificate not included")
+
+var inclusionAuditor *InclusionAuditor;
+
+// StoredSCT is an SCT received through SCT feedback with the chain it was issued for.
+type StoredSCT struct{
+	LogID string `json:"log_id"`
+	Timestamp uint64 `json:"timestamp"`
+	LeafHash []byte `json:"leaf_hash"` //hash of the Merkle tree leaf the SCT promises
+	Chain [][]byte `json:"x509_chain"`
+	SCT []byte `json:"sct_data"`
+	Received time.Time `json:"received"`
+	Status string `json:"status"`
+	TreeSize uint64 `json:"tree_size,omitempty"` //size of the tree the inclusion was checked in
+	Error string `json:"error,omitempty"` //why the inclusion could not be shown
+}
+
+func (s *StoredSCT) key() string {
+	return s.LogID + base64.StdEncoding.EncodeToString(s.LeafHash)
+}
+
+var scts = make(map[string]*StoredSCT); //[LogID+leaf hash]
+var sctsLock sync.Mutex;
+
+//storeSCT keeps sct unless it is already stored, dropping the oldest SCT when max are kept
+func storeSCT(sct *StoredSCT, max int){
+	sctsLock.Lock()
+	defer sctsLock.Unlock()
+	key := sct.key()
+	if _, ok := scts[key]; ok {
+		return
+	}
+	if max > 0 && len(scts) >= max {
+		var oldestKey string
+		var oldest time.Time
+		for k, s := range scts {
+			if len(oldestKey) == 0 || s.Received.Before(oldest) {
+				oldestKey, oldest = k, s.Received
+			}
+		}
+		delete(scts, oldestKey)
+	}
+	scts[key] = sct
+}
+
+//StoredSCTs returns a copy of the stored SCTs with the given status, or all of them if status is empty, oldest first
+func StoredSCTs(status string) []StoredSCT {
+	sctsLock.Lock()
+	defer sctsLock.Unlock()
+	stored := []StoredSCT{}
+	for _, sct := range scts {
+		if len(status) == 0 || sct.Status == status {
+			stored = append(stored, *sct)
+		}
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Received.Before(stored[j].Received) })
+	return stored
+}
+
+//setSCTStatus records the outcome of the inclusion audit of the SCT with key
+func setSCTStatus(key string, status string, treeSize uint64, err error){
+	sctsLock.Lock()
+	defer sctsLock.Unlock()
+	sct, ok := scts[key]
+	if !ok {
+		return
+	}
+	sct.Status, sct.TreeSize = status, treeSize
+	if err != nil {
+		sct.Error = err.Error()
+	}
+}
+
+// SCTFeedbackHandler is called on a Post request to /.well-known/ct-gossip/v1/sct-feedback.
+// Clients send the certificate chains they saw with their SCTs. The SCTs of known logs whose
+// signature verifies with the key in the log list are stored until their inclusion is checked.
+func SCTFeedbackHandler(w http.ResponseWriter, req *http.Request){
+	config := getConfig()
+	if config == nil || !config.SCT_feedback.Enabled {
+		http.Error(w, "sct feedback disabled", http.StatusNotFound)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sender := requestSender(req)
+	if !scores.Accepting(sender) {
+		glog.Infof("Refused SCT feedback from banned sender %v\n", sender)
+		http.Error(w, "peer banned", http.StatusForbidden)
+		return
+	}
+	var feedback cto.SCTFeedback
+	if err := json.NewDecoder(req.Body).Decode(&feedback); err != nil {
+		scores.Record(sender, PeerEventInvalid)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resp cto.SCTFeedbackResponse
+	for _, item := range feedback.Feedback {
+		for _, sctData := range item.SCTData {
+			sct, err := readSCT(item.X509Chain, sctData)
+			if err != nil {
+				glog.Infof("Rejected SCT feedback: %v\n", err)
+				sctFeedback.WithLabelValues("rejected").Inc()
+				resp.Rejected++
+				continue
+			}
+			storeSCT(sct, config.SCT_feedback.Max_scts)
+			sctFeedback.WithLabelValues("accepted").Inc()
+			resp.Accepted++
+		}
+	}
+	writeJSON(w, resp)
+}
+
+//readSCT parses an SCT and checks its signature over the chain with the key of its log
+func readSCT(rawChain [][]byte, sctData []byte) (*StoredSCT, error) {
+	if len(rawChain) == 0 {
+		return nil, fmt.Errorf("empty chain")
+	}
+	var chain []*x509.Certificate
+	for i, der := range rawChain {
+		cert, err := x509.ParseCertificate(der)
+		if x509.IsFatal(err) {
+			return nil, fmt.Errorf("chain[%d]: %v", i, err)
+		}
+		chain = append(chain, cert)
+	}
+	var sct ct.SignedCertificateTimestamp
+	if rest, err := tls.Unmarshal(sctData, &sct); err != nil {
+		return nil, fmt.Errorf("SCT: %v", err)
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("SCT: %d trailing bytes", len(rest))
+	}
+	logID := base64.StdEncoding.EncodeToString(sct.LogID.KeyID[:])
+	logger := getLogs().FindLogByLogID(logID)
+	if logger == nil {
+		return nil, fmt.Errorf("%w: log %v", ErrUnknownSigner, logID)
+	}
+	leaf, err := sctLeaf(chain, &sct, logger.Key)
+	if err != nil {
+		return nil, err
+	}
+	leafHash, err := ct.LeafHashForLeaf(leaf)
+	if err != nil {
+		return nil, err
+	}
+	return &StoredSCT{LogID: logID, Timestamp: sct.Timestamp, LeafHash: leafHash[:], Chain: rawChain, SCT: sctData, Received: time.Now(), Status: SCTPending}, nil
+}
+
+// sctLeaf returns the Merkle tree leaf the SCT was signed over. The SCT may have been issued for
+// the certificate itself, or for its precertificate when it is embedded or the chain is a precertificate chain.
+func sctLeaf(chain []*x509.Certificate, sct *ct.SignedCertificateTimestamp, key string) (*ct.MerkleTreeLeaf, error) {
+	pubKey, err := ct.PublicKeyFromB64(key)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := ct.NewSignatureVerifier(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	var leaves []*ct.MerkleTreeLeaf
+	if leaf, err := ct.MerkleTreeLeafFromChain(chain, ct.X509LogEntryType, sct.Timestamp); err == nil {
+		leaves = append(leaves, leaf)
+	}
+	if len(chain) > 1 {
+		if leaf, err := ct.MerkleTreeLeafForEmbeddedSCT(chain, sct.Timestamp); err == nil {
+			leaves = append(leaves, leaf)
+		}
+		if leaf, err := ct.MerkleTreeLeafFromChain(chain, ct.PrecertLogEntryType, sct.Timestamp); err == nil {
+			leaves = append(leaves, leaf)
+		}
+	}
+	for _, leaf := range leaves {
+		if verifier.VerifySCTSignature(*sct, ct.LogEntry{Leaf: *leaf}) == nil {
+			return leaf, nil
+		}
+	}
+	return nil, ErrInvalidSCT
+}
+
+//SCTsHandler returns the SCTs received through SCT feedback as JSON, filtered by the status query parameter
+func SCTsHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, StoredSCTs(req.URL.Query().Get("status")))
+}
+
+// InclusionAuditor checks that the logs included the certificates they issued the stored SCTs for.
+// Once the MMD of the log has passed after an SCT, the log is asked for the audit path of the
+// certificate in a stored STH issued after the MMD. A certificate the log cannot show is reported
+// to the notifiers as sct_not_included, and an alert about the log signed by our monitor is stored
+// and gossiped.
+type InclusionAuditor struct{
+	id string //our monitor ID, the signer of the alerts
+	signer *signature.Signer
+	interval time.Duration
+	timeout time.Duration
+	stop chan struct{}
+	running sync.WaitGroup
+}
+
+//NewInclusionAuditor creates the inclusion auditor of the monitor id from the sct_feedback section of the configuration, publicKey is the key of id in the monitor list
+func NewInclusionAuditor(config cto.SCTFeedbackConfig, id string, publicKey string) (*InclusionAuditor, error) {
+	signer, err := newMonitorSigner(config.Priv_key, id, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &InclusionAuditor{
+		id: id,
+		signer: signer,
+		interval: cto.Milliseconds(config.Audit_interval_ms),
+		timeout: cto.Milliseconds(config.Timeout_ms),
+		stop: make(chan struct{}),
+	}, nil
+}
+
+//Start audits the pending SCTs at each interval in the background
+func (a *InclusionAuditor) Start(){
+	a.running.Add(1)
+	go func(){
+		defer a.running.Done()
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				a.AuditInclusions(now)
+			case <-a.stop:
+				return
+			}
+		}
+	}()
+}
+
+//Stop ends the background audits and waits for the audit in progress, which ends after the current SCT
+func (a *InclusionAuditor) Stop(){
+	close(a.stop)
+	a.running.Wait()
+}
+
+// AuditInclusions checks every pending SCT whose MMD passed before now. An SCT stays pending
+// while no STH of its log issued after the MMD is stored, or while the log cannot be reached.
+func (a *InclusionAuditor) AuditInclusions(now time.Time){
+	nowMs := uint64(now.UnixNano() / int64(time.Millisecond))
+	for _, sct := range StoredSCTs(SCTPending) {
+		select {
+		case <-a.stop:
+			return
+		default:
+		}
+		info := getLogs().FindLogByLogID(sct.LogID)
+		if info == nil || len(info.URL) == 0 {
+			continue
+		}
+		deadline := sct.Timestamp + mmdOf(info)
+		if deadline > nowMs {
+			continue
+		}
+		storeLock.RLock()
+		sth := firstSTHAfter(sct.LogID, deadline)
+		storeLock.RUnlock()
+		if sth == nil {
+			continue
+		}
+
+		err := a.checkInclusion(info, &sct, sth)
+		switch {
+		case err == nil:
+			sctInclusionAudits.WithLabelValues(SCTIncluded).Inc()
+			setSCTStatus(sct.key(), SCTIncluded, sth.TreeHeadData.TreeSize, nil)
+		case errors.Is(err, ErrNotIncluded):
+			sctInclusionAudits.WithLabelValues(SCTNotIncluded).Inc()
+			glog.Infof("Log %v did not include the certificate of SCT %v within its MMD: %v\n", sct.LogID, sct.Timestamp, err)
+			setSCTStatus(sct.key(), SCTNotIncluded, sth.TreeHeadData.TreeSize, err)
+			sct.Status, sct.TreeSize, sct.Error = SCTNotIncluded, sth.TreeHeadData.TreeSize, err.Error()
+			NotifySCT(cto.EventSCTNotIncluded, &sct)
+			a.raiseAlert(&sct, deadline)
+		default:
+			sctInclusionAudits.WithLabelValues(AuditError).Inc()
+			glog.Errorf("Inclusion audit of SCT %v of log %v: %v\n", sct.Timestamp, sct.LogID, err)
+		}
+	}
+}
+
+// raiseAlert signs an alert about the log of sct, timestamped with the end of its MMD, then
+// stores and gossips it like an alert received from a peer. The answer of the log is not signed,
+// so the alert is a claim of our monitor, not a proof other monitors can check.
+func (a *InclusionAuditor) raiseAlert(sct *StoredSCT, deadline uint64){
+	tbs := mtr.AlertSignedFields{AlertType: cto.AlertSCTNotIncluded, Signer: a.id, Subject: sct.LogID, Timestamp: deadline}
+	sig, err := a.signer.CreateSignature(tls.SHA256, tbs)
+	if err != nil {
+		glog.Errorf("Failed to sign the alert about SCT %v of log %v: %v\n", sct.Timestamp, sct.LogID, err)
+		return
+	}
+	alert, err := mtr.ConstructCTObject(&mtr.Alert{TBS: tbs, Signature: *sig})
+	if err != nil {
+		glog.Errorf("Failed to construct the alert about SCT %v of log %v: %v\n", sct.Timestamp, sct.LogID, err)
+		return
+	}
+	alert.Subject = sct.LogID
+	identifierStr := cto.IdentifierToString(alert.Identifier())
+	if !storeEntry(alertsMap, *alert, alert.Identifier()) {
+		glog.Infof("%s Alert already stored\n", identifierStr)
+		return
+	}
+	glog.Infof("%s Stored alert, log %v did not include the certificate of SCT %v\n", identifierStr, sct.LogID, sct.Timestamp)
+	gossipNewData(alert, "")
+}
+
+// checkInclusion asks the log for the audit path of the SCT in the tree of sth and verifies it.
+// It returns ErrNotIncluded if the log does not know the certificate or its proof fails.
+func (a *InclusionAuditor) checkInclusion(info *mtrList.LogInfo, sct *StoredSCT, sth *mtr.SignedTreeHeadData) error {
+	client, err := mtr.NewLogClient(info)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+	params := map[string]string{
+		"hash": base64.StdEncoding.EncodeToString(sct.LeafHash),
+		"tree_size": strconv.FormatUint(sth.TreeHeadData.TreeSize, 10),
+	}
+	var resp ct.GetProofByHashResponse
+	if _, _, err := client.GetAndParse(ctx, ct.GetProofByHashPath, params, &resp); err != nil {
+		var rspErr jsonclient.RspError
+		if errors.As(err, &rspErr) && (rspErr.StatusCode == http.StatusBadRequest || rspErr.StatusCode == http.StatusNotFound) {
+			return fmt.Errorf("%w in the tree of size %v: %v", ErrNotIncluded, sth.TreeHeadData.TreeSize, err)
+		}
+		return err
+	}
+	if resp.LeafIndex < 0 {
+		return fmt.Errorf("%w: negative leaf index %v", ErrNotIncluded, resp.LeafIndex)
+	}
+	if err := VerifyInclusionProof(uint64(resp.LeafIndex), sth.TreeHeadData.TreeSize, sct.LeafHash, resp.AuditPath, sth.TreeHeadData.SHA256RootHash[:]); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotIncluded, err)
+	}
+	return nil
+}
+
+//mmdOf returns the MMD of the log in milliseconds
+func mmdOf(info *mtrList.LogInfo) uint64 {
+	if info.MMD > 0 {
+		return uint64(info.MMD) * 1000
+	}
+	return defaultMMDSeconds * 1000
+}
+
+//firstSTHAfter returns the stored STH of the log with the smallest tree issued at or after timestamp. Must be called with storeLock held
+func firstSTHAfter(logID string, timestamp uint64) *mtr.SignedTreeHeadData {
+	for _, sth := range storedSTHsBySize(logID) {
+		if sth.TreeHeadData.Timestamp >= timestamp {
+			return sth
+		}
+	}
+	return nil
+}
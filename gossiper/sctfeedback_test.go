@@ -0,0 +1,214 @@
+package main
+
+import (
+  "bytes"
+  "context"
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "encoding/base64"
+  "encoding/json"
+  "math/big"
+  "net/http"
+  "net/http/httptest"
+  "path/filepath"
+  "sync/atomic"
+  "testing"
+  "time"
+
+  ct "github.com/google/certificate-transparency-go"
+  tls "github.com/google/certificate-transparency-go/tls"
+  cto "github.com/n-ct/ct-gossiper"
+)
+
+//testChain returns a leaf certificate for name and the self-signed CA that issued it, DER encoded
+func testChain(t *testing.T, name string) [][]byte {
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    t.Fatal(err)
+  }
+  ca := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Test CA"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour), IsCA: true, BasicConstraintsValid: true}
+  caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &key.PublicKey, key)
+  if err != nil {
+    t.Fatal(err)
+  }
+  leaf := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: name}, DNSNames: []string{name}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+  leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, key)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return [][]byte{leafDER, caDER}
+}
+
+//sct returns the TLS encoded SCT of the log for the leaf certificate of chain and the hash of the leaf it promises
+func (k *testKey) sct(t *testing.T, chain [][]byte, timestamp uint64) ([]byte, []byte) {
+  var keyID ct.SHA256Hash
+  id, _ := base64.StdEncoding.DecodeString(k.id)
+  copy(keyID[:], id)
+  leaf := ct.CreateX509MerkleTreeLeaf(ct.ASN1Cert{Data: chain[0]}, timestamp)
+  sct := ct.SignedCertificateTimestamp{SCTVersion: ct.V1, LogID: ct.LogID{KeyID: keyID}, Timestamp: timestamp}
+  input, err := ct.SerializeSCTSignatureInput(sct, ct.LogEntry{Leaf: *leaf})
+  if err != nil {
+    t.Fatal(err)
+  }
+  sig, err := tls.CreateSignature(*k.signer.PrivKey.(*ecdsa.PrivateKey), tls.SHA256, input)
+  if err != nil {
+    t.Fatal(err)
+  }
+  sct.Signature = ct.DigitallySigned(sig)
+  data, err := tls.Marshal(sct)
+  if err != nil {
+    t.Fatal(err)
+  }
+  leafHash, err := ct.LeafHashForLeaf(leaf)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return data, leafHash[:]
+}
+
+//sendFeedback posts the feedback to SCTFeedbackHandler
+func sendFeedback(feedback cto.SCTFeedback) *httptest.ResponseRecorder {
+  body, _ := json.Marshal(feedback)
+  recorder := httptest.NewRecorder()
+  SCTFeedbackHandler(recorder, httptest.NewRequest("POST", cto.SCTFeedbackPath, bytes.NewBuffer(body)))
+  return recorder
+}
+
+func TestSCTFeedbackHandler(t *testing.T){
+  mustGossiperSetup(t)
+  scts = make(map[string]*StoredSCT)
+  log := addTestLog(t)
+  unknown := newTestKey(t)
+  chain := testChain(t, "example.com")
+  other := testChain(t, "example.org")
+  valid, _ := log.sct(t, chain, timestamp)
+
+  if code := sendFeedback(cto.SCTFeedback{}).Code; code != http.StatusNotFound {
+    t.Errorf("Expected SCT feedback to be disabled by default, got %v", code)
+  }
+  gossipConfig.SCT_feedback.Enabled = true
+
+  fromUnknown, _ := unknown.sct(t, chain, timestamp)
+  testTables := []struct {
+    name string
+    item cto.SCTFeedbackItem
+    accepted bool
+  }{
+    {"valid SCT", cto.SCTFeedbackItem{X509Chain: chain, SCTData: [][]byte{valid}}, true},
+    {"same SCT again", cto.SCTFeedbackItem{X509Chain: chain, SCTData: [][]byte{valid}}, true},
+    {"SCT of an unknown log", cto.SCTFeedbackItem{X509Chain: chain, SCTData: [][]byte{fromUnknown}}, false},
+    {"SCT of another certificate", cto.SCTFeedbackItem{X509Chain: other, SCTData: [][]byte{valid}}, false},
+    {"not an SCT", cto.SCTFeedbackItem{X509Chain: chain, SCTData: [][]byte{[]byte("sct")}}, false},
+    {"not a certificate", cto.SCTFeedbackItem{X509Chain: [][]byte{[]byte("cert")}, SCTData: [][]byte{valid}}, false},
+    {"empty chain", cto.SCTFeedbackItem{SCTData: [][]byte{valid}}, false},
+  }
+  for _, testTable := range testTables{
+    recorder := sendFeedback(cto.SCTFeedback{Feedback: []cto.SCTFeedbackItem{testTable.item}})
+    var resp cto.SCTFeedbackResponse
+    if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+      t.Fatalf("%v: %v", testTable.name, err)
+    }
+    if (resp.Accepted == 1) != testTable.accepted || resp.Accepted+resp.Rejected != 1 {
+      t.Errorf("%v: expected accepted %v, got %+v", testTable.name, testTable.accepted, resp)
+    }
+  }
+  if stored := StoredSCTs(SCTPending); len(stored) != 1 || stored[0].LogID != log.id || stored[0].Timestamp != timestamp {
+    t.Errorf("Expected the valid SCT to be stored once as pending, got %+v", stored)
+  }
+}
+
+func TestInclusionAuditor(t *testing.T){
+  mmd := uint64(24 * time.Hour / time.Millisecond)
+  now := time.Now()
+
+  testTables := []struct {
+    name string
+    leafIndex int //where the log put the certificate, -1 if it did not
+    forked bool //the log serves proofs from a tree other than the one of its STH
+    unavailable bool
+    sthTimestamp uint64
+    now time.Time
+    result string
+  }{
+    {"included", 5, false, false, timestamp + mmd, now, SCTIncluded},
+    {"not in the log", -1, false, false, timestamp + mmd, now, SCTNotIncluded},
+    {"proof from another tree", 5, true, false, timestamp + mmd, now, SCTNotIncluded},
+    {"log unavailable", 5, false, true, timestamp + mmd, now, SCTPending},
+    {"no STH issued after the MMD", 5, false, false, timestamp + mmd - 1, now, SCTPending},
+    {"MMD not passed", 5, false, false, timestamp + mmd, time.Unix(0, int64(timestamp) * int64(time.Millisecond)), SCTPending},
+  }
+  for _, testTable := range testTables{
+    mustGossiperSetup(t)
+    scts = make(map[string]*StoredSCT)
+    path := filepath.Join(t.TempDir(), "notifications.jsonl")
+    SetNotifiers([]cto.NotifierConfig{{Type: cto.NotifierJSONL, Path: path, Events: []string{cto.EventSCTNotIncluded}}})
+    log := addTestLog(t)
+    monitor := addTestMonitor(t)
+    chain := testChain(t, "example.com")
+    sctData, leafHash := log.sct(t, chain, timestamp)
+    sct, err := readSCT(chain, sctData)
+    if err != nil {
+      t.Fatalf("%v: %v", testTable.name, err)
+    }
+    storeSCT(sct, 10)
+
+    leaves := testLeaves(12)
+    if testTable.leafIndex >= 0 {
+      leaves[testTable.leafIndex] = leafHash
+    }
+    sth := mustConstruct(t, log.treeHead(t, testTable.sthTimestamp, leaves, 12))
+    storeEntry(messages, sth, sth.Identifier())
+    fake := &fakeLog{served: leaves}
+    if testTable.forked {
+      fake.served = append([][]byte{testLeaves(13)[12]}, leaves[1:]...)
+    }
+    if testTable.unavailable {
+      fake.fail = 1
+    }
+    server := httptest.NewServer(fake)
+    logInfoAt(t, log, server.URL)
+
+    a, err := NewInclusionAuditor(cto.SCTFeedbackConfig{Audit_interval_ms: 1000, Timeout_ms: 1000, Priv_key: monitor.privKey(t)}, monitor.id, monitor.key)
+    if err != nil {
+      t.Fatal(err)
+    }
+    a.AuditInclusions(testTable.now)
+    server.Close()
+    CloseNotifiers(context.Background())
+
+    stored := StoredSCTs("")
+    if len(stored) != 1 || stored[0].Status != testTable.result {
+      t.Errorf("%v: expected status %v, got %+v", testTable.name, testTable.result, stored)
+    }
+    if testTable.result == SCTPending && !testTable.unavailable && atomic.LoadInt32(&fake.requests) != 0 {
+      t.Errorf("%v: expected no request to the log", testTable.name)
+    }
+    if testTable.result == SCTNotIncluded {
+      var notification Notification
+      if err := json.Unmarshal(waitForFile(t, path, 1)[0], &notification); err != nil {
+        t.Fatal(err)
+      }
+      if notification.SCT == nil || notification.LogID != log.id || !bytes.Equal(notification.SCT.LeafHash, leafHash) {
+        t.Errorf("%v: unexpected notification %+v", testTable.name, notification)
+      }
+    }
+    alerts := alertsMap[log.id][monitor.id]
+    if testTable.result != SCTNotIncluded {
+      if len(alerts) != 0 {
+        t.Errorf("%v: expected no alert, got %v", testTable.name, alerts)
+      }
+      continue
+    }
+    if len(alerts[timestamp + mmd]) != 1 {
+      t.Errorf("%v: expected an alert about the log at the end of the MMD, got %v", testTable.name, alerts)
+    }
+    for _, alert := range alerts[timestamp + mmd] {
+      if err := ValidateSignature(alert); err != nil {
+        t.Errorf("%v: the alert does not validate: %v", testTable.name, err)
+      }
+    }
+  }
+}
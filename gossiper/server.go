@@ -9,30 +9,39 @@ import (
 	"os/signal"
 	"syscall"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"io/ioutil"
 	"strings"
 	"flag"
 	"time"
+	"errors"
+	"sync"
 
 	"github.com/golang/glog"
 	cto "github.com/n-ct/ct-gossiper"
 	mtr "github.com/n-ct/ct-monitor"
 	mtrList "github.com/n-ct/ct-monitor/entitylist"
-	signature "github.com/n-ct/ct-monitor/signature"
 	mtrUtils "github.com/n-ct/ct-monitor/utils"
-	tls "github.com/google/certificate-transparency-go/tls"
-	"github.com/n-ct/ct-certificate-authority/ca"
 )
 
 
 var peers []*mtrList.MonitorInfo;
+var peersLock sync.RWMutex;
 var messages cto.MessagesMap; //[TypeID][subjectOrSigner][Timestamp][Version]
 var alertsMap cto.MessagesMap;//[Subject][Signer][Timestamp][Version]
-var port string;
+var storeLock sync.RWMutex; //guards messages and alertsMap
+var listenAddress string;
 var myAddress string;
 var allMonitors *mtrList.MonitorList;
 var gossipConfig *cto.GossipConfig;
 var allLogs *mtrList.LogList;
+var listsLock sync.RWMutex; //guards gossipConfig, allLogs, allMonitors, postClient and myAddress, which can be replaced at runtime
+var configFile, monitorsFile, logsFile string;
+var postClient = &http.Client{Timeout: 10 * time.Second};
+
+var ErrDigestMismatch = errors.New("digest does not match blob")
+var ErrUnknownSigner = errors.New("signer not in the log or monitor list")
 
 
 func main() {
@@ -40,134 +49,334 @@ func main() {
 	done := make(chan os.Signal, 1); //create a channel to signify when server is shut down with ctrl+c
   signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM);//notify the channel when program terminated
 
+	hup := make(chan os.Signal, 1); //reload the configuration files on SIGHUP
+	signal.Notify(hup, syscall.SIGHUP);
 	go func() {
-		<-done
-		glog.Infoln("kill recived");
-		glog.Flush();
-		os.Exit(1);
-	}(); //when channel is notified print debug info, make sure all logs get written and exit
+		for range hup {
+			glog.Infoln("SIGHUP received, reloading");
+			if err := Reload(); err != nil {
+				glog.Errorf("Reload failed: %v\n", err);
+			}
+		}
+	}();
 
 	//Setting flags
   var configFilename = flag.String("config", "", "File containing gossiper configuration");
 	var monitorsFilename = flag.String("monitor_list", "", "File containing monitor-gossiper pairs");
 	var logsFilename = flag.String("log_list", "", "File containing the list of logs");
+	var watchInterval = flag.Duration("watch_interval", 0, "Reload when the configuration files change, checking at this interval (0 disables)");
+	var verifyAudit = flag.String("verify_audit", "", "Check the hash chain of this audit log and its rotated files with the audit.hmac_key of -config, then exit");
 
 	flag.Parse();
 
+	if len(*verifyAudit) != 0 {
+		if len(*configFilename) == 0 {
+			fmt.Fprintln(os.Stderr, "-verify_audit requires -config for the audit HMAC key");
+			os.Exit(1);
+		}
+		config, err := cto.NewGossipConfig(*configFilename);
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load %v: %v\n", *configFilename, err);
+			os.Exit(1);
+		}
+		count, err := VerifyAuditLog(*verifyAudit, config.Audit.Hmac_key);
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Audit log verification failed after %v records: %v\n", count, err);
+			os.Exit(1);
+		}
+		fmt.Printf("Verified %v audit records\n", count);
+		return
+	}
+
 	//if filenames are not defined, terminate
 	if len(*configFilename) == 0 || len(*monitorsFilename) == 0 || len(*logsFilename) == 0 {
     glog.Infoln("configuration files are required.")
     return
   }
 
-	GossiperSetup(*configFilename, *monitorsFilename, *logsFilename);
+	if err := GossiperSetup(*configFilename, *monitorsFilename, *logsFilename); err != nil {
+		glog.Errorf("Setup failed: %v\n", err);
+		glog.Flush();
+		os.Exit(1);
+	}
+	config := getConfig();
+	if len(config.Storage.Path) != 0 {
+		if err := LoadStore(config.Storage.Path); err != nil {
+			glog.Errorf("Unable to load store from %v: %v\n", config.Storage.Path, err);
+		}
+	}
+	maintainStore(config);
+	if len(config.Storage.Path) != 0 {
+		if err := LoadSpool(spoolPath(config)); err != nil {
+			glog.Errorf("Unable to load spooled objects: %v\n", err);
+		}
+	}
 
-	http.HandleFunc(cto.GossipPath, GossipHandler); // call GossipHandler on Post to /gossip
+	http.HandleFunc(cto.GossipPath, AdmissionHandler(GossipHandler)); // call GossipHandler on Post to /gossip
+	http.HandleFunc(cto.PeerScoresPath, AdminAuth(PeerScoresHandler));
+	http.HandleFunc(cto.MembershipJoinPath, AdmissionHandler(MembershipJoinHandler));
+	http.HandleFunc(cto.MembershipLeavePath, AdmissionHandler(MembershipLeaveHandler));
+	http.HandleFunc(cto.MembershipPingPath, AdmissionHandler(MembershipPingHandler));
+	http.HandleFunc(cto.MembershipPingReqPath, AdmissionHandler(MembershipPingReqHandler));
+	http.HandleFunc(cto.MembersPath, AdmissionHandler(MembersHandler));
+	http.HandleFunc(cto.AdminPeersPath, AdminAuth(AdminPeersHandler));
+	http.HandleFunc(cto.AdminPausePeerPath, AdminAuth(AdminPausePeerHandler));
+	http.HandleFunc(cto.AdminResumePeerPath, AdminAuth(AdminResumePeerHandler));
+	http.HandleFunc(cto.AdminReloadLogsPath, AdminAuth(AdminReloadLogsHandler));
+	http.HandleFunc(cto.AdminReloadMonitorsPath, AdminAuth(AdminReloadMonitorsHandler));
+	http.HandleFunc(cto.AdminReloadPath, AdminAuth(AdminReloadHandler));
+	http.Handle(cto.MetricsPath, MetricsHandler());
+	http.HandleFunc(cto.HealthzPath, HealthzHandler);
+	http.HandleFunc(cto.ReadyzPath, ReadyzHandler);
+	http.HandleFunc(cto.StatusPath, StatusHandler);
+	http.HandleFunc(cto.ObjectsPath, AdmissionHandler(ObjectsHandler));
+	http.HandleFunc(cto.ObjectPath, AdmissionHandler(ObjectHandler));
+	http.HandleFunc(cto.LogsPath, AdmissionHandler(LogsHandler));
+	http.HandleFunc(cto.FeedPath, AdmissionStreamHandler(FeedHandler));
+	http.HandleFunc(cto.ConflictsPath, AdmissionHandler(ConflictsHandler));
+	http.HandleFunc(cto.SubmitSTHPath, AdmissionHandler(SubmitSTHHandler));
+	http.HandleFunc(cto.PollinationPath, AdmissionHandler(PollinationHandler));
+	http.HandleFunc(cto.SCTFeedbackPath, AdmissionHandler(SCTFeedbackHandler));
+	http.HandleFunc(cto.SCTsPath, AdminAuth(SCTsHandler));
+	http.HandleFunc(cto.CosignaturesPath, AdmissionHandler(CosignaturesHandler));
+
+	if *watchInterval > 0 {
+		go WatchFiles(*watchInterval, nil);
+	}
+	go CheckMonitor(monitorCheckInterval, nil);
 
-	glog.Infof("Starting server on %v\n", port);
+	if membership != nil {
+		go membership.Start();
+	}
+	if auditor != nil {
+		auditor.Start();
+	}
+	if fetcher != nil {
+		fetcher.Start();
+	}
+	if inclusionAuditor != nil {
+		inclusionAuditor.Start();
+	}
 
-	err := http.ListenAndServe(fmt.Sprintf(":%v", port), nil); // start server
+	server, err := newServer(config, listenAddress, http.DefaultServeMux);
 	if err != nil {
-		fmt.Errorf("err: %v", err);
+		glog.Errorf("Unable to create server: %v\n", err);
+		glog.Flush();
+		os.Exit(1);
+	}
+	if len(config.Unix_socket) != 0 {
+		listener, err := listenUnix(config.Unix_socket);
+		if err != nil {
+			glog.Errorf("Unable to listen on %v: %v\n", config.Unix_socket, err);
+			glog.Flush();
+			os.Exit(1);
+		}
+		defer os.Remove(config.Unix_socket);
+		go func() {
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				glog.Errorf("err: %v", err);
+			}
+		}(); // plain HTTP, the socket is only reachable from this host
+	}
+	stopped := make(chan struct{});
+	go func() {
+		<-done
+		glog.Infoln("kill recived, shutting down");
+		go func() {
+			<-done
+			glog.Infoln("second kill recived, exiting now");
+			glog.Flush();
+			os.Exit(1);
+		}(); //a second signal skips the grace period
+		Shutdown(server, cto.Milliseconds(config.Timeouts.Shutdown_grace_ms));
+		close(stopped);
+	}(); //when channel is notified stop the server, drain the queues and save the store
+
+	glog.Infof("Starting server on %v, advertised as %v\n", listenAddress, getMyAddress());
+
+	if len(config.TLS.Cert_file) != 0 {
+		err = server.ListenAndServeTLS(config.TLS.Cert_file, config.TLS.Key_file); // start server
+	} else {
+		err = server.ListenAndServe(); // start server
+	}
+	if err != http.ErrServerClosed {
+		glog.Errorf("err: %v", err);
+		glog.Flush();
+		os.Exit(1);
 	}
+	<-stopped
+	glog.Infoln("Shutdown complete");
+	glog.Flush();
 }
 
 // GossipHandler is called on a Post request to /ct/v1/gossip.
 // It handles the logic of gossip within a network system
 func GossipHandler(w http.ResponseWriter, req *http.Request){
+	sender := requestSender(req)
+	record := newAuditRecord(req)
+	defer writeAudit(record)
+	if !scores.Accepting(sender) {
+		glog.Infof("Refused request from banned peer %v\n", sender)
+		record.observe("", OutcomeBanned)
+		http.Error(w, "peer banned", http.StatusForbidden)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		scores.Record(sender, PeerEventInvalid)
+		record.observe("", OutcomeInvalid)
+		record.Error = err.Error()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return;
+	}
+	bodyHash := sha256.Sum256(body)
+	record.RequestSHA256 = hex.EncodeToString(bodyHash[:])
 	data := mtr.CTObject{};
-	err := json.NewDecoder(req.Body).Decode(&data); // fill that struct using the JSON encoded struct send via the Post
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&data); // fill that struct using the JSON encoded struct send via the Post
 	if err != nil {
+		scores.Record(sender, PeerEventInvalid)
+		record.observe("", OutcomeInvalid)
+		record.Error = err.Error()
 		http.Error(w, err.Error(), http.StatusBadRequest) // if there is an eror report and abort
 		return;
 	}
 	requesterAddress := req.Header.Get("requesterAddress")
+	recordExchange(requestPeerID(req), nil)
+
+	status, response := ingestObject(record, &data, sender, requesterAddress)
+	writeResponse(w, status, response)
+}
+
+//writeResponse sends the outcome of ingestObject to the sender
+func writeResponse(w http.ResponseWriter, status int, response string){
+	if status >= http.StatusBadRequest {
+		http.Error(w, response, status)
+		return
+	}
+	w.WriteHeader(status)
+	fmt.Fprint(w, response)
+}
 
+// ingestObject validates, stores and gossips an object received from sender, or resolves its
+// conflict with the stored object of the same identifier. It fills in record and returns the
+// status and response to send back. Objects fetched by the gossiper itself come with no sender.
+func ingestObject(record *AuditRecord, data *mtr.CTObject, sender string, requesterAddress string) (int, string) {
+	normalizeAlert(data)
 	//Get data identifier and select map to use
 	identifier := data.Identifier();
 	identifierStr := cto.IdentifierToString(identifier)
+	record.TypeID, record.Identifier, record.Digest = data.TypeID, identifierStr, data.Digest
 
-	var workingMap cto.MessagesMap;
-	if data.TypeID == "Alert" {
-		workingMap = alertsMap;
-	} else {
-		workingMap = messages;
-	}
+	workingMap := workingMapFor(data);
 
 	glog.Infof("%s Received request\n", identifierStr)
-	if message, ok := workingMap[identifier.First][identifier.Second][identifier.Third][identifier.Fourth]; ok { // if I have the message already check for conflict
+	if message, ok := lookupEntry(workingMap, identifier); ok { // if I have the message already check for conflict
 		if bytes.Compare(data.Digest, message.Digest)==0 {
 			glog.Infof("%s Duplicate Item\n\n", identifierStr)
-			http.Error(w, "Duplicate item", http.StatusBadRequest); // if no conflic send back "duplicate item", and bad request status code to sender
-
-		} else {
-			if data.Blob == nil{ //If the message does not contain the blob
-				glog.Infof("%s blob-request sent\n", identifierStr)
-				fmt.Fprintf(w, "blob-request"); //respond with "blob-request"
-			} else {
-				if err := ValidateSignature(&data); err == nil {
-					glog.Infof("%s Misbehavior detected\n", identifierStr); // if conflict send a PoM to all peers.
-					PoM, err := mtr.CreateConflictingSTHPOM(&data, message)
-					if err == nil {
-						addEntry(messages, *PoM, PoM.Identifier()); // store PoM
-						glog.Infof("%s Stored PoM\n", identifierStr)
-						gossipPeers(PoM, requesterAddress)
-						gossipMonitor(PoM, requesterAddress)
-						glog.Infof("%s Finished gossiping PoM\n\n", identifierStr)
-
-					} else {
-						fmt.Errorf("Error creating ConflictingSTHPOM: %s\n", err) //error creating "ConflictingSTHPOM"
-					}
-				} else {
-					glog.Infof("%s invalid data: %v\n\n", identifierStr, err)
-					http.Error(w, "invalid data:", http.StatusBadRequest)
-				}
-			}
+			record.observe(data.TypeID, OutcomeDuplicate)
+			return http.StatusBadRequest, "Duplicate item" // if no conflic send back "duplicate item", and bad request status code to sender
 		}
-		} else { //message not in MessagesMap
-			if data.Blob == nil{ //If the message does not contain the blob
-				glog.Infof("%s blob-request sent\n", identifierStr)
-				fmt.Fprintf(w, "blob-request"); //respond with "blob-request"
-
-		} else {
-			if err := ValidateSignature(&data); err == nil {
-				fmt.Fprintf(w, "new data"); //respond with "new data"
-				addEntry(workingMap, data, identifier);// if message is new add it to messages map
-				glog.Infof("%s Stored new data\n", identifierStr)
-				gossipPeers(&data, requesterAddress)
-				gossipMonitor(&data, requesterAddress)
-				glog.Infof("%s Finished gossiping new data\n\n", identifierStr)
-
-			} else {
-				//invalid Signature
-				glog.Infof("%s invalid data %v\n\n", identifierStr, err)
-				http.Error(w, "invalid data", http.StatusBadRequest)
-			}
+		if data.Blob == nil{ //If the message does not contain the blob
+			glog.Infof("%s blob-request sent\n", identifierStr)
+			record.observe(data.TypeID, OutcomeBlobRequest)
+			return http.StatusOK, "blob-request" //respond with "blob-request"
+		}
+		err := ValidateSignature(data)
+		if err != nil {
+			glog.Infof("%s invalid data: %v\n\n", identifierStr, err)
+			record.Error = err.Error()
+			record.observe(data.TypeID, OutcomeInvalid)
+			scores.Record(sender, validationEvent(err))
+			return http.StatusBadRequest, "invalid data:"
+		}
+		return resolveConflict(record, message, data, sender, requesterAddress)
+	}
+
+	//message not in MessagesMap
+	if data.Blob == nil{ //If the message does not contain the blob
+		glog.Infof("%s blob-request sent\n", identifierStr)
+		record.observe(data.TypeID, OutcomeBlobRequest)
+		return http.StatusOK, "blob-request" //respond with "blob-request"
+	}
+	err := ValidateSignature(data)
+	if err == nil {
+		err = checkConsistency(data)
+	}
+	switch {
+	case err == nil:
+		if !storeEntry(workingMap, *data, identifier) { // if message is new add it to messages map
+			glog.Infof("%s Duplicate Item\n\n", identifierStr)
+			record.observe(data.TypeID, OutcomeDuplicate)
+			return http.StatusBadRequest, "Duplicate item"
 		}
+		record.observe(data.TypeID, OutcomeNew)
+		scores.Record(sender, PeerEventNewObject)
+		glog.Infof("%s Stored new data\n", identifierStr)
+		notifyStored(data)
+		record.Destinations = gossipNewData(data, requesterAddress)
+		checkStoredSTHs(data)
+		witnessNewSTH(data)
+		return http.StatusOK, "new data" //respond with "new data"
+
+	case errors.Is(err, ErrInconsistentProof):
+		//the sender may only relay what the log served, it is not scored and the log is asked for its own proof
+		glog.Infof("%s inconsistent proof: %v\n\n", identifierStr, err)
+		record.Error = err.Error()
+		record.observe(data.TypeID, OutcomeInvalid)
+		recheckPOC(data)
+		return http.StatusBadRequest, "inconsistent proof"
+
+	case errors.Is(err, ErrUnknownSigner):
+		glog.Infof("%s quarantined: %v\n\n", identifierStr, err)
+		record.Error = err.Error()
+		quarantineObject(data, sender, requesterAddress)
+		record.observe(data.TypeID, OutcomeQuarantined)
+		return http.StatusAccepted, "quarantined"
+
+	default:
+		//invalid Signature
+		glog.Infof("%s invalid data %v\n\n", identifierStr, err)
+		record.Error = err.Error()
+		record.observe(data.TypeID, OutcomeInvalid)
+		scores.Record(sender, validationEvent(err))
+		return http.StatusBadRequest, "invalid data"
 	}
 }
 
 // Post takes in an address as a string and a pointer to a CTObject struct
 // and makes a Post request to that address with the JSON encoded version of that struct
-func Post(address string, data *mtr.CTObject, withoutBlob bool){
+// It returns the error if the request could not be made
+func Post(address string, data *mtr.CTObject, withoutBlob bool) error {
 	var toSend *mtr.CTObject;
-	if withoutBlob && len(data.Blob) > cto.Threshold { //threshold  to be change after testing
+	threshold := cto.Threshold
+	if config := getConfig(); config != nil {
+		threshold = config.Blob_threshold
+	}
+	if withoutBlob && len(data.Blob) > threshold {
 		toSend = cto.CopyWithoutBlob(data);
 	} else {
 		toSend = data;
 	}
 	var jsonStr, _ = json.Marshal(toSend);
 
+	listsLock.RLock()
+	client, requester := postClient, myAddress
+	listsLock.RUnlock()
+
 	req, err := http.NewRequest("POST", address, bytes.NewBuffer(jsonStr)); //create a Post request
+	if err != nil {
+		glog.Errorf("Unable to create request: %s\n", err)
+		return err
+	}
 	req.Header.Set("X-Custom-Header", "myvalue");
 	req.Header.Set("Content-Type", "application/json"); //set message type to JSON
-	req.Header.Add("requesterAddress", myAddress);
+	req.Header.Add("requesterAddress", requester);
 
-	client := &http.Client{};
 	resp, err := client.Do(req); //make the request
 	if err != nil {
-		fmt.Errorf("Unable to make request: %s\n", err)
-		return
+		glog.Errorf("Unable to make request: %s\n", err)
+		return err
 	}
 
 	defer resp.Body.Close();
@@ -181,11 +390,50 @@ func Post(address string, data *mtr.CTObject, withoutBlob bool){
 
 	if strings.ToLower(sbody) == "blob-request" {
 		glog.Infof("Sending blob to peer: %v\n", address);
-		Post(address, data, false); // if the recipient sends back a blob request resend the message with the blob
+		return Post(address, data, false); // if the recipient sends back a blob request resend the message with the blob
+	}
+	return nil
+}
+
+//gossipNewData sends newly stored data to the peers and the monitor, returning the queues it was added to
+func gossipNewData(data *mtr.CTObject, requesterAddress string) []string {
+	identifierStr := cto.IdentifierToString(data.Identifier())
+	destinations := append(gossipPeers(data, requesterAddress), gossipMonitor(data, requesterAddress)...)
+	glog.Infof("%s Finished gossiping new data\n\n", identifierStr)
+	return destinations
+}
+
+//workingMapFor selects the map data is stored in
+func workingMapFor(data *mtr.CTObject) cto.MessagesMap {
+	if data.TypeID == mtr.AlertTypeID {
+		return alertsMap
+	}
+	return messages
+}
+
+//lookupEntry returns the entry stored in the selected map under identifier
+func lookupEntry(dataMap cto.MessagesMap, identifier mtr.ObjectIdentifier) (*mtr.CTObject, bool) {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+	message, ok := dataMap[identifier.First][identifier.Second][identifier.Third][identifier.Fourth]
+	return message, ok
+}
+
+//storeEntry adds data to the selected map unless an entry with the same identifier exists, returns true if it was added
+func storeEntry(dataMap cto.MessagesMap, data mtr.CTObject, identifier mtr.ObjectIdentifier) bool {
+	storeLock.Lock()
+	if _, ok := dataMap[identifier.First][identifier.Second][identifier.Third][identifier.Fourth]; ok {
+		storeLock.Unlock()
+		return false
 	}
+	addEntry(dataMap, data, identifier)
+	noteStored(&data)
+	storeLock.Unlock()
+	feed.Publish(&data)
+	return true
 }
 
-//addEntry adds a new entry to the selected map using the data identifier as keys
+//addEntry adds a new entry to the selected map using the data identifier as keys. Must be called with storeLock held
 func addEntry(dataMap cto.MessagesMap, data mtr.CTObject, identifier mtr.ObjectIdentifier){
 	if _, ok := dataMap[identifier.First]; !ok {
 		dataMap[identifier.First] = make(map[string]map[uint64]map[string] *mtr.CTObject);
@@ -199,156 +447,217 @@ func addEntry(dataMap cto.MessagesMap, data mtr.CTObject, identifier mtr.ObjectI
 	dataMap[identifier.First][identifier.Second][identifier.Third][identifier.Fourth] = &data;
 }
 
+//removeEntry removes the entry stored under identifier and the maps left empty. Must be called with storeLock held
+func removeEntry(dataMap cto.MessagesMap, identifier mtr.ObjectIdentifier){
+	byTimestamp := dataMap[identifier.First][identifier.Second]
+	delete(byTimestamp[identifier.Third], identifier.Fourth)
+	if len(byTimestamp[identifier.Third]) == 0 {
+		delete(byTimestamp, identifier.Third)
+	}
+	if len(byTimestamp) == 0 {
+		delete(dataMap[identifier.First], identifier.Second)
+	}
+	if len(dataMap[identifier.First]) == 0 {
+		delete(dataMap, identifier.First)
+	}
+}
+
 //GossiperSetup configures gossiper varialbes from json files
-func GossiperSetup(configFilename string, monitorsFilename string, logsFilename string){
+func GossiperSetup(configFilename string, monitorsFilename string, logsFilename string) error {
 	//create message maps
 	messages = make(cto.MessagesMap);
 	alertsMap = make(cto.MessagesMap);
+	configFile, monitorsFile, logsFile = configFilename, monitorsFilename, logsFilename;
 	//get gossiper configuration
-	gossipConfig = cto.NewGossipConfig(configFilename);
-
-	GetPeers(gossipConfig, monitorsFilename);
-	myAddress = allMonitors.FindMonitorByMonitorID(gossipConfig.Monitor_id).GossiperURL;
-	port = strings.Split(myAddress, ":")[2];
+	config, err := cto.NewGossipConfig(configFilename);
+	if err != nil {
+		return err
+	}
+	monitors, err := mtrList.NewMonitorList(monitorsFilename)
+	if err != nil {
+		return err
+	}
+	logs, err := mtrList.NewLogList(logsFilename); //get all logs
+	if err != nil {
+		return err
+	}
+	if err := validateReload(nil, config, monitors); err != nil {
+		return err
+	}
+	client, err := newPostClient(config)
+	if err != nil {
+		return err
+	}
+	advertised := config.Advertised_url
+	if len(advertised) == 0 {
+		advertised = monitors.FindMonitorByMonitorID(config.Monitor_id).GossiperURL;
+	}
+	listenAddress, err = listenAddressFor(config, advertised)
+	if err != nil {
+		return err
+	}
 
-	var err error
-	allLogs, err = mtrList.NewLogList(logsFilename); //get all logs
-	if err != nil{
-		panic(err)
+	listsLock.Lock()
+	gossipConfig = config
+	allMonitors = monitors
+	allLogs = logs
+	postClient = client
+	myAddress = advertised
+	listsLock.Unlock()
+	peersLock.Lock()
+	adminAdded, adminRemoved = make(map[string]bool), make(map[string]bool)
+	peersLock.Unlock()
+	GetPeers(config, monitors)
+	admission = NewAdmissionControl(config.Admission);
+	scores = NewPeerScores(config.Peer_scoring);
+	if err := SetAuditLog(config.Audit); err != nil {
+		return fmt.Errorf("audit log: %v", err)
+	}
+	SetNotifiers(config.Notifiers)
+	if config.Membership.Enabled {
+		membership, err = NewMembership(config.Membership, config.Monitor_id, monitors.FindMonitorByMonitorID(config.Monitor_id).MonitorKey, getPeers());
+		if err != nil {
+			return fmt.Errorf("membership: %v", err)
+		}
+	}
+	if config.Auditor.Enabled {
+		auditor = NewAuditor(config.Auditor);
+	}
+	if config.Fetcher.Enabled {
+		fetcher = NewFetcher(config.Fetcher);
+	}
+	if config.SCT_feedback.Enabled {
+		inclusionAuditor, err = NewInclusionAuditor(config.SCT_feedback, config.Monitor_id, monitors.FindMonitorByMonitorID(config.Monitor_id).MonitorKey);
+		if err != nil {
+			return fmt.Errorf("sct_feedback: %v", err)
+		}
+	}
+	if config.Witness.Enabled {
+		witness, err = NewWitness(config.Witness, config.Monitor_id, monitors.FindMonitorByMonitorID(config.Monitor_id).MonitorKey);
+		if err != nil {
+			return fmt.Errorf("witness: %v", err)
+		}
 	}
 	glog.Infoln("Setup completed")
+	return nil
 }
 
-//GetPeers get all monitors from file and populates the peers with the respective monitors
-func GetPeers(gossipConfig *cto.GossipConfig, monitorsFilename string)  {
-	var err error
-	allMonitors, err = mtrList.NewMonitorList(monitorsFilename)
-	if err != nil{
-		panic(err)
-	}
-
+//GetPeers populates the peers with the monitors of the configured monitor_ids
+func GetPeers(gossipConfig *cto.GossipConfig, monitors *mtrList.MonitorList)  {
+	var configPeers []*mtrList.MonitorInfo
 	for _, monitorId := range gossipConfig.Monitors_ids {
-		peers = append(peers, allMonitors.FindMonitorByMonitorID(monitorId));
+		peer := monitors.FindMonitorByMonitorID(monitorId)
+		if peer == nil {
+			glog.Errorf("Peer %v not found in monitor list\n", monitorId)
+			continue
+		}
+		configPeers = append(configPeers, peer);
 	}
+	setPeers(configPeers)
 }
 
-//gossipPeers sends new data to other gossip servers
-func gossipPeers(data *mtr.CTObject, requesterAddress string){
-	for _, peer := range peers{
+//getConfig returns the current gossiper configuration
+func getConfig() *cto.GossipConfig {
+	listsLock.RLock()
+	defer listsLock.RUnlock()
+	return gossipConfig
+}
+
+//getLogs returns the current log list
+func getLogs() *mtrList.LogList {
+	listsLock.RLock()
+	defer listsLock.RUnlock()
+	return allLogs
+}
+
+//setLogs replaces the log list
+func setLogs(logs *mtrList.LogList){
+	listsLock.Lock()
+	allLogs = logs
+	listsLock.Unlock()
+}
 
-		if requesterAddress != peer.GossiperURL{
+//getMonitors returns the current monitor list
+func getMonitors() *mtrList.MonitorList {
+	listsLock.RLock()
+	defer listsLock.RUnlock()
+	return allMonitors
+}
+
+//setMonitors replaces the monitor list
+func setMonitors(monitors *mtrList.MonitorList){
+	listsLock.Lock()
+	allMonitors = monitors
+	listsLock.Unlock()
+}
+
+//getPeers returns a copy of the current peers
+func getPeers() []*mtrList.MonitorInfo {
+	peersLock.RLock()
+	defer peersLock.RUnlock()
+	return append([]*mtrList.MonitorInfo(nil), peers...)
+}
+
+//setPeers replaces the current peers
+func setPeers(newPeers []*mtrList.MonitorInfo){
+	peersLock.Lock()
+	peers = newPeers
+	peersLock.Unlock()
+}
+
+//gossipPeers sends new data to other gossip servers
+func gossipPeers(data *mtr.CTObject, requesterAddress string) []string {
+	var destinations []string
+	for _, peer := range getPeers(){
+
+		if !sameURL(requesterAddress, peer.GossiperURL){
+			if isPaused(peer.MonitorID) {
+				glog.Infof("Gossip to peer %v is paused\n", peer.MonitorID);
+				continue
+			}
+			if !scores.Forwarding(peer.MonitorID) {
+				glog.Infof("Not gossiping to low score peer: %v\n", peer.MonitorID);
+				continue
+			}
 			glog.Infof("Gossiping info to peer: %v\n", peer.MonitorID);
-			Post(mtrUtils.CreateRequestURL(peer.GossiperURL, cto.GossipPath), data, true);
+			enqueue(peer.MonitorID, outboundItem{address: mtrUtils.CreateRequestURL(peer.GossiperURL, cto.GossipPath), data: data, withoutBlob: true});
+			destinations = append(destinations, peer.MonitorID)
 		}
 	}
+	return destinations
 }
 
 //gossipMonitor sends new data to the monitor
-func gossipMonitor(data *mtr.CTObject, requesterAddress string){
-	monitorUrl := allMonitors.FindMonitorByMonitorID(gossipConfig.Monitor_id).MonitorURL;
+func gossipMonitor(data *mtr.CTObject, requesterAddress string) []string {
+	monitorUrl := getMonitors().FindMonitorByMonitorID(getConfig().Monitor_id).MonitorURL;
 	glog.Infof("requester: %v\n", requesterAddress) //debug info
 	glog.Infof("monitor: %v\n", monitorUrl) //debug info
-	if requesterAddress == monitorUrl {
+	if sameURL(requesterAddress, monitorUrl) {
 		glog.Infoln("Request from monitor")
-		return
+		return nil
 	}
 	//Check if monitor is reachable
-	timeout := 1 * time.Second
-	_, err := net.DialTimeout("tcp", strings.SplitN(monitorUrl, "/", 3)[2], timeout)
+	timeout := cto.Milliseconds(getConfig().Timeouts.Monitor_dial_ms)
+	address, err := hostPort(monitorUrl)
 	if err != nil {
-		glog.Infoln("Monitor unreachable.")
-	} else {
-		Post(mtrUtils.CreateRequestURL(monitorUrl, mtr.NewInfoPath), data, false)
-	}
-}
-
-//ValidateSignature check if the received message has a valid signature
-func ValidateSignature(data *mtr.CTObject) error {
-	var signature_err error
-	var hash tls.HashAlgorithm
-
-	if data.Blob == nil{
-		return fmt.Errorf("Missing blob\n")
-	}
-
-	switch data.TypeID{
-	case mtr.STHTypeID:
-		logger := allLogs.FindLogByLogID(data.Signer)
-		sth, err := data.DeconstructSTH()
-		if err != nil{
-			return fmt.Errorf("Error deconstructing STH: %s\n", err)
-		}
-		signature_err = signature.VerifySignature(logger.Key, sth.TreeHeadData, sth.Signature) 
-		hash = sth.Signature.Algorithm.Hash
-
-	case mtr.AlertTypeID:
-		monitor := allMonitors.FindMonitorByMonitorID(data.Signer)
-		alert, err := data.DeconstructAlert();
-		if err != nil{
-			return fmt.Errorf("Error deconstructing Alert: %s\n", err)
-		}
-		signature_err = signature.VerifySignature(monitor.MonitorKey, alert.TBS, alert.Signature)
-		hash = alert.Signature.Algorithm.Hash
-
-	case mtr.STHPOCTypeID:
-		logger := allLogs.FindLogByLogID(data.Signer);
-		sth_poc, err := data.DeconstructSTH()
-		if err != nil{
-			return fmt.Errorf("Error deconstructing STH_POC: %s\n", err)
-		}
-		signature_err = signature.VerifySignature(logger.Key, sth_poc.TreeHeadData, sth_poc.Signature)
-		hash = sth_poc.Signature.Algorithm.Hash
-
-	case mtr.ConflictingSTHPOMTypeID:
-		con_sth_pom, err := data.DeconstructConflictingSTHPOM()//ConflictingSTHPOM
-		if err != nil{
-			return fmt.Errorf("Error deconstructing Conflicting STH: %s\n", err)
-		}
-		logger := allLogs.FindLogByLogID(con_sth_pom.STH1.LogID);
-		signature_err = signature.VerifySignature(logger.Key, con_sth_pom.STH1, con_sth_pom.STH1.Signature)
-		if signature_err != nil{
-			break
-		}
-		hash = con_sth_pom.STH1.Signature.Algorithm.Hash
-
-		logger = allLogs.FindLogByLogID(con_sth_pom.STH2.LogID);
-		signature_err = signature.VerifySignature(logger.Key, con_sth_pom.STH2, con_sth_pom.STH2.Signature)
-	
-	case mtr.SRDWithRevDataTypeID:
-		logger := allLogs.FindLogByLogID(data.Signer)
-		srd, err := data.DeconstructSRD()
-		if err != nil{
-			return fmt.Errorf("Error deconstructing SRD: %s\n", err)
-		}
-		signature_err = ca.VerifySRDSignature(srd, logger.Key)
-		hash = srd.Signature.Algorithm.Hash
-
-	default:
-		signature_err = fmt.Errorf("Unknown type %v\n", data.TypeID)
-		break
-	}
-
-	if signature_err != nil{
-		return fmt.Errorf("%v\n", signature_err)
+		glog.Errorf("Invalid monitor URL %v: %v\n", monitorUrl, err)
+		return nil
 	}
-
-	digest, _, err := signature.GenerateHash(hash, data.Blob)
-	if err != nil || !CompareDigest(digest, data.Digest){
-		return err
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		glog.Infoln("Monitor unreachable.")
+		recordExchange(monitorQueue, err)
+		return nil
 	}
-
-	return nil
+	conn.Close()
+	enqueue(monitorQueue, outboundItem{address: mtrUtils.CreateRequestURL(monitorUrl, mtr.NewInfoPath), data: data, withoutBlob: false})
+	return []string{monitorQueue}
 }
 
-//Compare calculated digest and digest received - may move to utils.go
-func CompareDigest(digest, dataDigest []byte) bool {
-	if digest == nil || dataDigest == nil{
-		return false
-	}
-	for i := range digest {
-		if digest[i] != dataDigest[i]{
-			return false
-		}
+//validationEvent maps a ValidateSignature error to the peer event it is scored as
+func validationEvent(err error) string {
+	if errors.Is(err, ErrDigestMismatch) {
+		return PeerEventDigestMismatch
 	}
-	return true //digest match
+	return PeerEventInvalid
 }
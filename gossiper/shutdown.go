@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var shuttingDown int32; //set once shutdown has started, checked with atomic
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) != 0
+}
+
+// Shutdown stops the gossiper within grace. It stops accepting requests and waits for the
+// handlers in flight, then for the background workers to finish what they started, so that
+// the objects they store are gossiped and saved, and lets the outbound queues drain. Objects
+// that could not be sent in time are spooled next to the store, and the store is saved, when
+// storage is configured.
+func Shutdown(server *http.Server, grace time.Duration){
+	atomic.StoreInt32(&shuttingDown, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	if membership != nil {
+		membership.Leave()
+	}
+	feed.Close()
+	if server != nil {
+		if err := server.Shutdown(ctx); err != nil {
+			glog.Errorf("Handlers did not finish before the grace period: %v\n", err)
+		}
+	}
+	stopWorkers(ctx)
+
+	leftover := DrainQueues(ctx)
+	CloseNotifiers(ctx)
+	CloseAuditLog()
+	config := getConfig()
+	if len(config.Storage.Path) == 0 {
+		if len(leftover) != 0 {
+			glog.Errorf("Dropping %v unsent objects, no storage path configured\n", len(leftover))
+		}
+		return
+	}
+	if len(leftover) != 0 {
+		if err := SaveSpool(spoolPath(config), leftover); err != nil {
+			glog.Errorf("Unable to spool %v unsent objects: %v\n", len(leftover), err)
+		} else {
+			glog.Infof("Spooled %v unsent objects to %v\n", len(leftover), spoolPath(config))
+		}
+	}
+	if err := SaveStore(config.Storage.Path); err != nil {
+		glog.Errorf("Unable to save store to %v: %v\n", config.Storage.Path, err)
+	}
+}
+
+//stopWorkers stops the auditors, the fetcher and the witness and waits for them until ctx is done
+func stopWorkers(ctx context.Context){
+	var stops []func()
+	if auditor != nil {
+		stops = append(stops, auditor.Stop)
+	}
+	if fetcher != nil {
+		stops = append(stops, fetcher.Stop)
+	}
+	if inclusionAuditor != nil {
+		stops = append(stops, inclusionAuditor.Stop)
+	}
+	if witness != nil {
+		stops = append(stops, witness.Stop)
+	}
+
+	var wg sync.WaitGroup
+	for _, stop := range stops {
+		wg.Add(1)
+		go func(stop func()){
+			defer wg.Done()
+			stop()
+		}(stop)
+	}
+	stopped := make(chan struct{})
+	go func(){
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		glog.Errorln("Background workers did not finish before the grace period")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "sync"
+  "sync/atomic"
+  "testing"
+  "time"
+
+  cto "github.com/n-ct/ct-gossiper"
+  mtr "github.com/n-ct/ct-monitor"
+)
+
+//resetQueues forgets the outbound queues so a test can shut down without affecting the others
+func resetQueues(){
+  outboundLock.Lock()
+  outboundQueues = make(map[string]*outboundQueue)
+  draining = make(chan struct{})
+  drainOnce = sync.Once{}
+  outboundLock.Unlock()
+  atomic.StoreInt32(&shuttingDown, 0)
+}
+
+func TestShutdownDrainsQueues(t *testing.T){
+  mustGossiperSetup(t)
+  resetQueues()
+  defer resetQueues()
+  getConfig().Storage.Path = filepath.Join(t.TempDir(), "store.json")
+  defer func(){ getConfig().Storage.Path = "" }()
+
+  var received int32
+  peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request){
+    atomic.AddInt32(&received, 1)
+    w.Write([]byte("new data"))
+  }))
+  defer peer.Close()
+  storeEntry(messages, sthCTObject, sthCTObject.Identifier())
+  for i := 0; i < 3; i++ {
+    enqueue("peerX", outboundItem{address: peer.URL, data: &sthCTObject})
+  }
+
+  Shutdown(nil, 5*time.Second)
+  if atomic.LoadInt32(&received) != 3 {
+    t.Errorf("Expected 3 objects to be sent before shutdown, got %v", received)
+  }
+  if !isShuttingDown() || ready() {
+    t.Errorf("Expected the gossiper to report not ready during shutdown")
+  }
+  if _, err := os.Stat(getConfig().Storage.Path); err != nil {
+    t.Errorf("Expected the store to be saved: %v", err)
+  }
+  if _, err := os.Stat(spoolPath(getConfig())); !os.IsNotExist(err) {
+    t.Errorf("Expected no spool when every object was sent, got %v", err)
+  }
+}
+
+func TestShutdownSpoolsUnsentObjects(t *testing.T){
+  mustGossiperSetup(t)
+  resetQueues()
+  defer resetQueues()
+  getConfig().Storage.Path = filepath.Join(t.TempDir(), "store.json")
+  defer func(){ getConfig().Storage.Path = "" }()
+
+  release := make(chan struct{})
+  peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request){
+    <-release
+  }))
+  defer peer.Close()
+  defer close(release)
+  for i := 0; i < 3; i++ {
+    enqueue("peerX", outboundItem{address: peer.URL, data: &sthCTObject})
+  }
+
+  Shutdown(nil, 100*time.Millisecond)
+  path := spoolPath(getConfig())
+  if _, err := os.Stat(path); err != nil {
+    t.Fatalf("Expected unsent objects to be spooled: %v", err)
+  }
+
+  byteData, _ := ioutil.ReadFile(path)
+  var spooled []spooledItem
+  if err := json.Unmarshal(byteData, &spooled); err != nil || len(spooled) == 0 {
+    t.Fatalf("Expected spooled objects in %v: %v", path, err)
+  }
+
+  resetQueues()
+  var received int32
+  restarted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request){
+    atomic.AddInt32(&received, 1)
+  }))
+  defer restarted.Close()
+  for i := range spooled {
+    spooled[i].Address = restarted.URL
+  }
+  if err := SaveSpool(path, spooled); err != nil {
+    t.Fatal(err)
+  }
+  if err := LoadSpool(path); err != nil {
+    t.Fatal(err)
+  }
+  for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&received) < int32(len(spooled)) && time.Now().Before(deadline); {
+    time.Sleep(10 * time.Millisecond)
+  }
+  if atomic.LoadInt32(&received) != int32(len(spooled)) {
+    t.Errorf("Expected %v spooled objects to be sent after loading, got %v", len(spooled), received)
+  }
+  if _, err := os.Stat(path); !os.IsNotExist(err) {
+    t.Errorf("Expected the spool to be removed after loading, got %v", err)
+  }
+}
+
+func TestShutdownWaitsForPolls(t *testing.T){
+  mustGossiperSetup(t)
+  resetQueues()
+  defer resetQueues()
+  getConfig().Storage.Path = filepath.Join(t.TempDir(), "store.json")
+  defer func(){ getConfig().Storage.Path = "" }()
+
+  log := addTestLog(t)
+  fake := &fakeLog{}
+  fake.setSTH(t, log.signedTreeHead(t, timestamp, 10, 1))
+  started := make(chan struct{})
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request){
+    close(started)
+    time.Sleep(100 * time.Millisecond)
+    fake.ServeHTTP(w, req)
+  }))
+  defer server.Close()
+  logInfoAt(t, log, server.URL)
+  onlyLog(t, log)
+  fetcher = NewFetcher(cto.FetcherConfig{Interval_ms: 1000, Timeout_ms: 5000, Max_backoff_ms: 8000})
+  defer func(){ fetcher = nil }()
+
+  fetcher.FetchDue(time.Now())
+  <-started
+  Shutdown(nil, 5*time.Second)
+
+  //the STH of the poll in flight was stored before the store was saved
+  messages = make(cto.MessagesMap)
+  if err := LoadStore(getConfig().Storage.Path); err != nil {
+    t.Fatal(err)
+  }
+  if len(storedObjects(mtr.STHTypeID, log.id)) == 0 {
+    t.Errorf("Expected the STH fetched during shutdown to be saved")
+  }
+}
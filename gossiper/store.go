@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+// storeSnapshot is the file format used to keep the stored objects between restarts.
+type storeSnapshot struct{
+	Messages []mtr.CTObject `json:"messages"`
+	Alerts []mtr.CTObject `json:"alerts"`
+}
+
+//collectEntries returns every object in the selected map. Must be called with storeLock held
+func collectEntries(dataMap cto.MessagesMap) []mtr.CTObject {
+	var entries []mtr.CTObject
+	for _, bySecond := range dataMap {
+		for _, byTimestamp := range bySecond {
+			for _, byVersion := range byTimestamp {
+				for _, data := range byVersion {
+					entries = append(entries, *data)
+				}
+			}
+		}
+	}
+	return entries
+}
+
+//SaveStore writes every stored object to path, replacing the file only once the new one is complete
+func SaveStore(path string) error {
+	storeLock.RLock()
+	snapshot := storeSnapshot{Messages: collectEntries(messages), Alerts: collectEntries(alertsMap)}
+	storeLock.RUnlock()
+
+	jsonStr, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, jsonStr, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+//LoadStore adds the objects saved in path to the maps, a missing file is not an error
+func LoadStore(path string) error {
+	byteData, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snapshot storeSnapshot
+	if err := json.Unmarshal(byteData, &snapshot); err != nil {
+		return err
+	}
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	for _, data := range snapshot.Messages {
+		addEntry(messages, data, data.Identifier())
+	}
+	for _, data := range snapshot.Alerts {
+		addEntry(alertsMap, data, data.Identifier())
+	}
+	glog.Infof("Loaded %v objects and %v alerts from %v\n", len(snapshot.Messages), len(snapshot.Alerts), path)
+	return nil
+}
+
+//PruneStore removes objects whose timestamp is older than maxAge and returns how many were removed
+func PruneStore(maxAge time.Duration) int {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	age := int64(maxAge / time.Millisecond)
+	if age >= now {
+		return 0
+	}
+	cutoff := uint64(now - age)
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	return pruneEntries(messages, cutoff) + pruneEntries(alertsMap, cutoff)
+}
+
+//pruneEntries removes the entries older than cutoff and the maps left empty. Must be called with storeLock held
+func pruneEntries(dataMap cto.MessagesMap, cutoff uint64) int {
+	removed := 0
+	for first, bySecond := range dataMap {
+		for second, byTimestamp := range bySecond {
+			for timestamp, byVersion := range byTimestamp {
+				if timestamp < cutoff {
+					removed += len(byVersion)
+					delete(byTimestamp, timestamp)
+				}
+			}
+			if len(byTimestamp) == 0 {
+				delete(bySecond, second)
+			}
+		}
+		if len(bySecond) == 0 {
+			delete(dataMap, first)
+		}
+	}
+	return removed
+}
+
+//maintainStore periodically saves the store and removes expired objects, as set in the configuration
+func maintainStore(config *cto.GossipConfig){
+	if len(config.Storage.Path) != 0 {
+		go func(){
+			for range time.Tick(time.Duration(config.Storage.Flush_interval_seconds) * time.Second) {
+				if err := SaveStore(config.Storage.Path); err != nil {
+					glog.Errorf("Unable to save store to %v: %v\n", config.Storage.Path, err)
+				}
+			}
+		}()
+	}
+	if config.Retention.Max_age_seconds > 0 {
+		maxAge := time.Duration(config.Retention.Max_age_seconds) * time.Second
+		go func(){
+			for range time.Tick(time.Duration(config.Retention.Prune_interval_seconds) * time.Second) {
+				if removed := PruneStore(maxAge); removed > 0 {
+					glog.Infof("Removed %v objects older than %v\n", removed, maxAge)
+				}
+			}
+		}()
+	}
+}
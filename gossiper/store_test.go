@@ -0,0 +1,44 @@
+package main
+
+import (
+  "path/filepath"
+  "testing"
+  "time"
+
+  cto "github.com/n-ct/ct-gossiper"
+)
+
+func TestSaveAndLoadStore(t *testing.T){
+  mustGossiperSetup(t)
+  storeEntry(messages, sthCTObject, sthCTObject.Identifier())
+
+  path := filepath.Join(t.TempDir(), "store.json")
+  if err := SaveStore(path); err != nil {
+    t.Fatal(err)
+  }
+  messages = make(cto.MessagesMap)
+  if err := LoadStore(path); err != nil {
+    t.Fatal(err)
+  }
+  if _, ok := lookupEntry(messages, sthCTObject.Identifier()); !ok {
+    t.Errorf("Expected STH to be loaded from %v", path)
+  }
+  if err := LoadStore(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+    t.Errorf("Expected a missing store to be ignored, got %v", err)
+  }
+}
+
+func TestPruneStore(t *testing.T){
+  mustGossiperSetup(t)
+  storeEntry(messages, sthCTObject, sthCTObject.Identifier())
+
+  if removed := PruneStore(100 * 365 * 24 * time.Hour); removed != 0 {
+    t.Errorf("Expected nothing to be pruned, removed %v", removed)
+  }
+  if removed := PruneStore(time.Hour); removed != 1 {
+    t.Errorf("Expected the STH to be pruned, removed %v", removed)
+  }
+  if len(messages) != 0 {
+    t.Errorf("Expected empty maps to be removed, got %v", messages)
+  }
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+)
+
+var ErrLogIDRequired = errors.New("log_id is required")
+
+// SubmitSTHHandler is called on a Post request to /ct/v1/submit-sth.
+// It builds the STH CTObject from a log ID and the get-sth response of that log, so that
+// clients do not have to compute the digest and blob themselves, and handles it like gossip.
+// It responds with the identifier of the object and the outcome, a duplicate is not an error.
+func SubmitSTHHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sender := requestSender(req)
+	record := newAuditRecord(req)
+	defer writeAudit(record)
+	if !scores.Accepting(sender) {
+		glog.Infof("Refused submission from banned peer %v\n", sender)
+		record.observe("", OutcomeBanned)
+		http.Error(w, "peer banned", http.StatusForbidden)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		record.observe("", OutcomeInvalid)
+		record.Error = err.Error()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bodyHash := sha256.Sum256(body)
+	record.RequestSHA256 = hex.EncodeToString(bodyHash[:])
+	var submission cto.STHSubmission
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&submission)
+	if err == nil && len(submission.LogID) == 0 {
+		err = ErrLogIDRequired
+	}
+	if err != nil {
+		scores.Record(sender, PeerEventInvalid)
+		record.observe("", OutcomeInvalid)
+		record.Error = err.Error()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := cto.STHFromGetSTH(submission.LogID, &submission.STH)
+	if err != nil {
+		scores.Record(sender, PeerEventInvalid)
+		record.observe("", OutcomeInvalid)
+		record.Error = err.Error()
+		http.Error(w, "invalid sth: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status, response := ingestObject(record, data, sender, "")
+	if record.Outcome == OutcomeDuplicate {
+		status = http.StatusOK
+	} else if status >= http.StatusBadRequest {
+		http.Error(w, response, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(cto.SubmissionResponse{
+		Identifier: record.Identifier,
+		TypeID: data.TypeID,
+		Subject: data.Subject,
+		Timestamp: data.Timestamp,
+		Outcome: record.Outcome,
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+
+  cto "github.com/n-ct/ct-gossiper"
+)
+
+func TestSubmitSTHHandler(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  other := newTestKey(t)
+  sth := log.signedTreeHead(t, timestamp, 10, 1)
+  expected := mustConstruct(t, sth)
+  badRoot := getSTHResponse(t, sth)
+  badRoot.SHA256RootHash = badRoot.SHA256RootHash[:16]
+
+  testTables := []struct {
+    name string
+    method string
+    body interface{}
+    status int
+    outcome string
+  }{
+    {"wrong method", "GET", nil, http.StatusMethodNotAllowed, ""},
+    {"not json", "POST", "not json", http.StatusBadRequest, ""},
+    {"no log ID", "POST", cto.STHSubmission{STH: getSTHResponse(t, sth)}, http.StatusBadRequest, ""},
+    {"short root hash", "POST", cto.STHSubmission{LogID: log.id, STH: badRoot}, http.StatusBadRequest, ""},
+    {"new STH", "POST", cto.STHSubmission{LogID: log.id, STH: getSTHResponse(t, sth)}, http.StatusOK, OutcomeNew},
+    {"same STH again", "POST", cto.STHSubmission{LogID: log.id, STH: getSTHResponse(t, sth)}, http.StatusOK, OutcomeDuplicate},
+    {"signed by another key", "POST", cto.STHSubmission{LogID: log.id, STH: getSTHResponse(t, other.signedTreeHead(t, timestamp+1, 10, 1))}, http.StatusBadRequest, ""},
+    {"unknown log", "POST", cto.STHSubmission{LogID: other.id, STH: getSTHResponse(t, other.signedTreeHead(t, timestamp, 10, 1))}, http.StatusAccepted, OutcomeQuarantined},
+  }
+  for _, testTable := range testTables{
+    var body []byte
+    if s, ok := testTable.body.(string); ok {
+      body = []byte(s)
+    } else if testTable.body != nil {
+      body, _ = json.Marshal(testTable.body)
+    }
+    recorder := httptest.NewRecorder()
+    SubmitSTHHandler(recorder, httptest.NewRequest(testTable.method, cto.SubmitSTHPath, bytes.NewBuffer(body)))
+    if recorder.Code != testTable.status {
+      t.Errorf("%v: expected status %v, got %v: %v", testTable.name, testTable.status, recorder.Code, recorder.Body.String())
+      continue
+    }
+    if len(testTable.outcome) == 0 {
+      continue
+    }
+    var resp cto.SubmissionResponse
+    if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+      t.Fatalf("%v: %v", testTable.name, err)
+    }
+    if resp.Outcome != testTable.outcome {
+      t.Errorf("%v: expected outcome %v, got %v", testTable.name, testTable.outcome, resp.Outcome)
+    }
+  }
+
+  //the object built by the gossiper is the one a monitor builds from the same STH
+  stored, ok := GetObject(expected.Identifier())
+  if !ok {
+    t.Fatalf("Expected the submitted STH to be stored as %v", cto.IdentifierToString(expected.Identifier()))
+  }
+  if !bytes.Equal(stored.Digest, expected.Digest) {
+    t.Errorf("Expected digest %x, got %x", expected.Digest, stored.Digest)
+  }
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	cto "github.com/n-ct/ct-gossiper"
+)
+
+//newPostClient returns the client used to send gossip, presenting our certificate to peers when TLS is configured
+func newPostClient(config *cto.GossipConfig) (*http.Client, error) {
+	client := &http.Client{Timeout: cto.Milliseconds(config.Timeouts.Peer_request_ms)}
+	if len(config.TLS.Cert_file) == 0 && len(config.TLS.Ca_file) == 0 {
+		return client, nil
+	}
+	tlsConfig := &tls.Config{}
+	if len(config.TLS.Cert_file) != 0 {
+		cert, err := tls.LoadX509KeyPair(config.TLS.Cert_file, config.TLS.Key_file)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if len(config.TLS.Ca_file) != 0 {
+		pool, err := loadCertPool(config.TLS.Ca_file)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client.Transport = transport
+	return client, nil
+}
+
+//newServer returns the http server for handler with the timeouts and TLS settings of config
+func newServer(config *cto.GossipConfig, address string, handler http.Handler) (*http.Server, error) {
+	server := &http.Server{
+		Addr: address,
+		Handler: handler,
+		ReadHeaderTimeout: cto.Milliseconds(config.Timeouts.Read_header_ms),
+		ReadTimeout: cto.Milliseconds(config.Timeouts.Read_ms),
+		WriteTimeout: cto.Milliseconds(config.Timeouts.Write_ms),
+		IdleTimeout: cto.Milliseconds(config.Timeouts.Idle_ms),
+	}
+	if len(config.TLS.Client_ca_file) != 0 {
+		pool, err := loadCertPool(config.TLS.Client_ca_file)
+		if err != nil {
+			return nil, err
+		}
+		server.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	}
+	return server, nil
+}
+
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	pemData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("no certificates found in %s", filename)
+	}
+	return pool, nil
+}
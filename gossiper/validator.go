@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	tls "github.com/google/certificate-transparency-go/tls"
+	"github.com/n-ct/ct-certificate-authority/ca"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+	signature "github.com/n-ct/ct-monitor/signature"
+)
+
+var ErrInconsistentObject = errors.New("object fields do not match its content")
+
+// Validator checks one type of CT object. Signature verifies the blob against the key of its
+// signer and returns the hash algorithm the digest is computed with. Digest compares the digest
+// of the blob with the one the object claims. Semantic checks that the fields of the object
+// agree with the signed content, so an object cannot be stored under another identifier.
+type Validator interface{
+	Signature(data *mtr.CTObject) (tls.HashAlgorithm, error)
+	Digest(data *mtr.CTObject, hash tls.HashAlgorithm) error
+	Semantic(data *mtr.CTObject) error
+}
+
+// ValidatorFuncs is a Validator made of functions. A nil DigestFunc uses CheckBlobDigest,
+// a nil SemanticFunc accepts every object.
+type ValidatorFuncs struct{
+	SignatureFunc func(data *mtr.CTObject) (tls.HashAlgorithm, error)
+	DigestFunc func(data *mtr.CTObject, hash tls.HashAlgorithm) error
+	SemanticFunc func(data *mtr.CTObject) error
+}
+
+func (v ValidatorFuncs) Signature(data *mtr.CTObject) (tls.HashAlgorithm, error) {
+	return v.SignatureFunc(data)
+}
+
+func (v ValidatorFuncs) Digest(data *mtr.CTObject, hash tls.HashAlgorithm) error {
+	if v.DigestFunc == nil {
+		return CheckBlobDigest(data, hash)
+	}
+	return v.DigestFunc(data, hash)
+}
+
+func (v ValidatorFuncs) Semantic(data *mtr.CTObject) error {
+	if v.SemanticFunc == nil {
+		return nil
+	}
+	return v.SemanticFunc(data)
+}
+
+var validators = make(map[string]Validator); //[TypeID]
+var validatorsLock sync.RWMutex;
+
+//RegisterValidator makes v the validator of typeID, registering a type twice is a programming error
+func RegisterValidator(typeID string, v Validator){
+	validatorsLock.Lock()
+	defer validatorsLock.Unlock()
+	if v == nil {
+		panic("gossiper: RegisterValidator with a nil validator for " + typeID)
+	}
+	if _, ok := validators[typeID]; ok {
+		panic("gossiper: RegisterValidator called twice for " + typeID)
+	}
+	validators[typeID] = v
+}
+
+//validatorFor returns the validator registered for typeID, nil if there is none
+func validatorFor(typeID string) Validator {
+	validatorsLock.RLock()
+	defer validatorsLock.RUnlock()
+	return validators[typeID]
+}
+
+func init(){
+	RegisterValidator(mtr.STHTypeID, ValidatorFuncs{SignatureFunc: verifySTHSignature, SemanticFunc: checkSTH})
+	RegisterValidator(mtr.STHPOCTypeID, ValidatorFuncs{SignatureFunc: verifySTHSignature, SemanticFunc: checkSTHPOC})
+	RegisterValidator(mtr.AlertTypeID, ValidatorFuncs{SignatureFunc: verifyAlertSignature, SemanticFunc: checkAlert})
+	RegisterValidator(mtr.ConflictingSTHPOMTypeID, ValidatorFuncs{SignatureFunc: verifyConflictingSTHPOMSignature, SemanticFunc: checkConflictingSTHPOM})
+	RegisterValidator(mtr.SRDWithRevDataTypeID, ValidatorFuncs{SignatureFunc: verifySRDSignature, SemanticFunc: checkSRD})
+	RegisterValidator(mtr.ConflictingSRDPOMTypeID, ValidatorFuncs{SignatureFunc: verifyConflictingSRDPOMSignature, SemanticFunc: checkConflictingSRDPOM})
+	RegisterValidator(cto.MonitorEquivocationPOMTypeID, ValidatorFuncs{SignatureFunc: verifyMonitorEquivocationPOMSignature, SemanticFunc: checkMonitorEquivocationPOM})
+	RegisterValidator(cto.STHCosignatureTypeID, ValidatorFuncs{SignatureFunc: verifySTHCosignatureSignature, SemanticFunc: checkSTHCosignature})
+}
+
+//ValidateSignature checks the received object with the validator of its type
+func ValidateSignature(data *mtr.CTObject) error {
+	if data.Blob == nil{
+		return fmt.Errorf("Missing blob\n")
+	}
+	defer func(start time.Time){
+		verifyDuration.WithLabelValues(typeLabel(data.TypeID)).Observe(time.Since(start).Seconds())
+	}(time.Now())
+
+	validator := validatorFor(data.TypeID)
+	if validator == nil {
+		return fmt.Errorf("Unknown type %v\n", data.TypeID)
+	}
+	hash, err := validator.Signature(data)
+	if err != nil {
+		return err
+	}
+	if err := validator.Digest(data, hash); err != nil {
+		return err
+	}
+	return validator.Semantic(data)
+}
+
+//CheckBlobDigest compares the digest of the blob computed with hash to the digest of the object
+func CheckBlobDigest(data *mtr.CTObject, hash tls.HashAlgorithm) error {
+	digest, _, err := signature.GenerateHash(hash, data.Blob)
+	if err != nil {
+		return err
+	}
+	if !CompareDigest(digest, data.Digest){
+		return ErrDigestMismatch
+	}
+	return nil
+}
+
+//Compare calculated digest and digest received
+func CompareDigest(digest, dataDigest []byte) bool {
+	if digest == nil || dataDigest == nil{
+		return false
+	}
+	return bytes.Equal(digest, dataDigest) //digest match
+}
+
+//verifyTreeHead checks the signature of an STH with the key of the log logID
+func verifyTreeHead(logID string, sth *mtr.SignedTreeHeadData) error {
+	logger := getLogs().FindLogByLogID(logID)
+	if logger == nil {
+		return fmt.Errorf("%w: log %v", ErrUnknownSigner, logID)
+	}
+	if err := signature.VerifySignature(logger.Key, sth.TreeHeadData, sth.Signature); err != nil {
+		return fmt.Errorf("%v\n", err)
+	}
+	return nil
+}
+
+//verifySTHSignature checks STH and STH_POC objects, whose signer is the log
+func verifySTHSignature(data *mtr.CTObject) (tls.HashAlgorithm, error) {
+	if getLogs().FindLogByLogID(data.Signer) == nil {
+		return 0, fmt.Errorf("%w: log %v", ErrUnknownSigner, data.Signer)
+	}
+	sth, err := data.DeconstructSTH()
+	if err != nil{
+		return 0, fmt.Errorf("Error deconstructing %v: %s\n", data.TypeID, err)
+	}
+	if err := verifyTreeHead(data.Signer, sth); err != nil {
+		return 0, err
+	}
+	return sth.Signature.Algorithm.Hash, nil
+}
+
+func checkSTH(data *mtr.CTObject) error {
+	sth, err := data.DeconstructSTH()
+	if err != nil{
+		return fmt.Errorf("Error deconstructing %v: %s\n", data.TypeID, err)
+	}
+	if sth.LogID != data.Signer {
+		return fmt.Errorf("%w: signer %v, STH of log %v", ErrInconsistentObject, data.Signer, sth.LogID)
+	}
+	if sth.TreeHeadData.Timestamp != data.Timestamp {
+		return fmt.Errorf("%w: timestamp %v, STH timestamp %v", ErrInconsistentObject, data.Timestamp, sth.TreeHeadData.Timestamp)
+	}
+	if len(data.Subject) != 0 { //the identifier would use the subject instead of the log
+		return fmt.Errorf("%w: subject %v set on an STH of log %v", ErrInconsistentObject, data.Subject, sth.LogID)
+	}
+	return nil
+}
+
+//checkSTHPOC checks the STH like checkSTH and that the consistency proof ends at it
+func checkSTHPOC(data *mtr.CTObject) error {
+	if err := checkSTH(data); err != nil {
+		return err
+	}
+	sth, _ := data.DeconstructSTH()
+	proof, err := data.DeconstructPOC()
+	if err != nil{
+		return fmt.Errorf("Error deconstructing %v: %s\n", data.TypeID, err)
+	}
+	return checkProofOf(sth, proof)
+}
+
+//checkProofOf checks that proof is a consistency proof of the log of sth that ends at the tree of sth
+func checkProofOf(sth *mtr.SignedTreeHeadData, proof *mtr.ConsistencyProofData) error {
+	if proof.LogID != sth.LogID {
+		return fmt.Errorf("%w: STH of log %v, proof of log %v", ErrInconsistentObject, sth.LogID, proof.LogID)
+	}
+	if proof.TreeSize2 != sth.TreeHeadData.TreeSize || proof.TreeSize1 > proof.TreeSize2 {
+		return fmt.Errorf("%w: proof from size %v to %v, STH of size %v", ErrInconsistentObject, proof.TreeSize1, proof.TreeSize2, sth.TreeHeadData.TreeSize)
+	}
+	return nil
+}
+
+func verifyAlertSignature(data *mtr.CTObject) (tls.HashAlgorithm, error) {
+	monitor := getMonitors().FindMonitorByMonitorID(data.Signer)
+	if monitor == nil {
+		return 0, fmt.Errorf("%w: monitor %v", ErrUnknownSigner, data.Signer)
+	}
+	alert, err := data.DeconstructAlert();
+	if err != nil{
+		return 0, fmt.Errorf("Error deconstructing Alert: %s\n", err)
+	}
+	if err := signature.VerifySignature(monitor.MonitorKey, alert.TBS, alert.Signature); err != nil {
+		return 0, fmt.Errorf("%v\n", err)
+	}
+	return alert.Signature.Algorithm.Hash, nil
+}
+
+func checkAlert(data *mtr.CTObject) error {
+	alert, err := data.DeconstructAlert();
+	if err != nil{
+		return fmt.Errorf("Error deconstructing Alert: %s\n", err)
+	}
+	if alert.TBS.Signer != data.Signer {
+		return fmt.Errorf("%w: signer %v, alert signed by %v", ErrInconsistentObject, data.Signer, alert.TBS.Signer)
+	}
+	if len(alert.TBS.Subject) == 0 {
+		return fmt.Errorf("%w: alert without a subject", ErrInconsistentObject)
+	}
+	//ct-monitor leaves the subject of the object empty, ingestObject fills it in from the alert
+	if len(data.Subject) != 0 && alert.TBS.Subject != data.Subject {
+		return fmt.Errorf("%w: subject %v, alert about %v", ErrInconsistentObject, data.Subject, alert.TBS.Subject)
+	}
+	if alert.TBS.Timestamp != data.Timestamp {
+		return fmt.Errorf("%w: timestamp %v, alert timestamp %v", ErrInconsistentObject, data.Timestamp, alert.TBS.Timestamp)
+	}
+	return nil
+}
+
+// normalizeAlert sets the subject of an alert built by ct-monitor, which leaves it empty, to the
+// subject of the signed alert. Alerts are stored under their subject, so that two alerts of a
+// monitor about different subjects do not share an identifier.
+func normalizeAlert(data *mtr.CTObject){
+	if data.TypeID != mtr.AlertTypeID || len(data.Subject) != 0 || data.Blob == nil {
+		return
+	}
+	if alert, err := data.DeconstructAlert(); err == nil {
+		data.Subject = alert.TBS.Subject
+	}
+}
+
+//verifyConflictingSTHPOMSignature checks both STHs of the PoM, the digest uses the hash of the first
+func verifyConflictingSTHPOMSignature(data *mtr.CTObject) (tls.HashAlgorithm, error) {
+	pom, err := data.DeconstructConflictingSTHPOM()
+	if err != nil{
+		return 0, fmt.Errorf("Error deconstructing Conflicting STH: %s\n", err)
+	}
+	if err := verifyTreeHead(pom.STH1.LogID, &pom.STH1); err != nil {
+		return 0, err
+	}
+	if err := verifyTreeHead(pom.STH2.LogID, &pom.STH2); err != nil {
+		return 0, err
+	}
+	return pom.STH1.Signature.Algorithm.Hash, nil
+}
+
+//checkConflictingSTHPOM checks that the PoM is about its subject and that the STHs really conflict
+func checkConflictingSTHPOM(data *mtr.CTObject) error {
+	pom, err := data.DeconstructConflictingSTHPOM()
+	if err != nil{
+		return fmt.Errorf("Error deconstructing Conflicting STH: %s\n", err)
+	}
+	if pom.STH1.LogID != data.Subject || pom.STH2.LogID != data.Subject {
+		return fmt.Errorf("%w: subject %v, STHs of logs %v and %v", ErrInconsistentObject, data.Subject, pom.STH1.LogID, pom.STH2.LogID)
+	}
+	if pom.STH1.TreeHeadData.Timestamp != data.Timestamp {
+		return fmt.Errorf("%w: timestamp %v, STH timestamp %v", ErrInconsistentObject, data.Timestamp, pom.STH1.TreeHeadData.Timestamp)
+	}
+	if pom.STH1.TreeHeadData == pom.STH2.TreeHeadData {
+		return fmt.Errorf("%w: the STHs sign the same tree head", ErrInconsistentObject)
+	}
+	return nil
+}
+
+func verifySRDSignature(data *mtr.CTObject) (tls.HashAlgorithm, error) {
+	logger := getLogs().FindLogByLogID(data.Signer)
+	if logger == nil {
+		return 0, fmt.Errorf("%w: log %v", ErrUnknownSigner, data.Signer)
+	}
+	srd, err := data.DeconstructSRD()
+	if err != nil{
+		return 0, fmt.Errorf("Error deconstructing SRD: %s\n", err)
+	}
+	if err := ca.VerifySRDSignature(srd, logger.Key); err != nil {
+		return 0, fmt.Errorf("%v\n", err)
+	}
+	return srd.Signature.Algorithm.Hash, nil
+}
+
+func checkSRD(data *mtr.CTObject) error {
+	srd, err := data.DeconstructSRD()
+	if err != nil{
+		return fmt.Errorf("Error deconstructing SRD: %s\n", err)
+	}
+	if srd.EntityID != data.Signer {
+		return fmt.Errorf("%w: signer %v, SRD of %v", ErrInconsistentObject, data.Signer, srd.EntityID)
+	}
+	if srd.RevDigest.Timestamp != data.Timestamp {
+		return fmt.Errorf("%w: timestamp %v, SRD timestamp %v", ErrInconsistentObject, data.Timestamp, srd.RevDigest.Timestamp)
+	}
+	if len(data.Subject) != 0 { //the identifier would use the subject instead of the entity
+		return fmt.Errorf("%w: subject %v set on an SRD of %v", ErrInconsistentObject, data.Subject, srd.EntityID)
+	}
+	return nil
+}
+
+//verifyConflictingSRDPOMSignature checks both SRDs of the PoM with the key of the entity that signed them
+func verifyConflictingSRDPOMSignature(data *mtr.CTObject) (tls.HashAlgorithm, error) {
+	pom, err := data.DeconstructConflictingSRDPOM()
+	if err != nil{
+		return 0, fmt.Errorf("Error deconstructing Conflicting SRD: %s\n", err)
+	}
+	for _, srd := range []*mtr.SignedRevocationDigest{&pom.SRD1, &pom.SRD2} {
+		logger := getLogs().FindLogByLogID(srd.EntityID)
+		if logger == nil {
+			return 0, fmt.Errorf("%w: log %v", ErrUnknownSigner, srd.EntityID)
+		}
+		if err := ca.VerifySRDSignature(srd, logger.Key); err != nil {
+			return 0, fmt.Errorf("%v\n", err)
+		}
+	}
+	return pom.SRD1.Signature.Algorithm.Hash, nil
+}
+
+//checkConflictingSRDPOM checks that the PoM is about its subject and that the SRDs really conflict
+func checkConflictingSRDPOM(data *mtr.CTObject) error {
+	pom, err := data.DeconstructConflictingSRDPOM()
+	if err != nil{
+		return fmt.Errorf("Error deconstructing Conflicting SRD: %s\n", err)
+	}
+	if pom.SRD1.EntityID != data.Subject || pom.SRD2.EntityID != data.Subject {
+		return fmt.Errorf("%w: subject %v, SRDs of %v and %v", ErrInconsistentObject, data.Subject, pom.SRD1.EntityID, pom.SRD2.EntityID)
+	}
+	digest1, digest2 := pom.SRD1.RevDigest, pom.SRD2.RevDigest
+	if digest1.Timestamp != data.Timestamp || digest2.Timestamp != data.Timestamp {
+		return fmt.Errorf("%w: timestamp %v, SRD timestamps %v and %v", ErrInconsistentObject, data.Timestamp, digest1.Timestamp, digest2.Timestamp)
+	}
+	if bytes.Equal(digest1.CRVHash, digest2.CRVHash) && bytes.Equal(digest1.CRVDeltaHash, digest2.CRVDeltaHash) {
+		return fmt.Errorf("%w: the SRDs sign the same revocation digest", ErrInconsistentObject)
+	}
+	return nil
+}
+
+//verifyMonitorEquivocationPOMSignature checks both alerts of the PoM with the key of the monitor it is about
+func verifyMonitorEquivocationPOMSignature(data *mtr.CTObject) (tls.HashAlgorithm, error) {
+	monitor := getMonitors().FindMonitorByMonitorID(data.Subject)
+	if monitor == nil {
+		return 0, fmt.Errorf("%w: monitor %v", ErrUnknownSigner, data.Subject)
+	}
+	pom, err := cto.DeconstructMonitorEquivocationPOM(data)
+	if err != nil{
+		return 0, fmt.Errorf("Error deconstructing Monitor Equivocation: %s\n", err)
+	}
+	for _, alert := range []*mtr.Alert{&pom.Alert1, &pom.Alert2} {
+		if err := signature.VerifySignature(monitor.MonitorKey, alert.TBS, alert.Signature); err != nil {
+			return 0, fmt.Errorf("%v\n", err)
+		}
+	}
+	return pom.Alert1.Signature.Algorithm.Hash, nil
+}
+
+//checkMonitorEquivocationPOM checks that both alerts are signed by the subject, share a subject and timestamp and differ
+func checkMonitorEquivocationPOM(data *mtr.CTObject) error {
+	pom, err := cto.DeconstructMonitorEquivocationPOM(data)
+	if err != nil{
+		return fmt.Errorf("Error deconstructing Monitor Equivocation: %s\n", err)
+	}
+	tbs1, tbs2 := pom.Alert1.TBS, pom.Alert2.TBS
+	if tbs1.Signer != data.Subject || tbs2.Signer != data.Subject {
+		return fmt.Errorf("%w: subject %v, alerts signed by %v and %v", ErrInconsistentObject, data.Subject, tbs1.Signer, tbs2.Signer)
+	}
+	if tbs1.Subject != tbs2.Subject {
+		return fmt.Errorf("%w: alerts about %v and %v", ErrInconsistentObject, tbs1.Subject, tbs2.Subject)
+	}
+	if tbs1.Timestamp != data.Timestamp || tbs2.Timestamp != data.Timestamp {
+		return fmt.Errorf("%w: timestamp %v, alert timestamps %v and %v", ErrInconsistentObject, data.Timestamp, tbs1.Timestamp, tbs2.Timestamp)
+	}
+	if tbs1 == tbs2 {
+		return fmt.Errorf("%w: the alerts sign the same fields", ErrInconsistentObject)
+	}
+	return nil
+}
+
+//verifySTHCosignatureSignature checks the cosignature with the key of the witness in the monitor list and the STH with the key of its log
+func verifySTHCosignatureSignature(data *mtr.CTObject) (tls.HashAlgorithm, error) {
+	cosig, err := cto.DeconstructSTHCosignature(data)
+	if err != nil{
+		return 0, fmt.Errorf("Error deconstructing STH Cosignature: %s\n", err)
+	}
+	monitor := getMonitors().FindMonitorByMonitorID(cosig.TBS.Witness)
+	if monitor == nil {
+		return 0, fmt.Errorf("%w: monitor %v", ErrUnknownSigner, cosig.TBS.Witness)
+	}
+	if err := signature.VerifySignature(monitor.MonitorKey, cosig.TBS, cosig.Signature); err != nil {
+		return 0, fmt.Errorf("%v\n", err)
+	}
+	if err := verifyTreeHead(cosig.TBS.STH.LogID, &cosig.TBS.STH); err != nil {
+		return 0, err
+	}
+	return cosig.Signature.Algorithm.Hash, nil
+}
+
+//checkSTHCosignature checks that the object is signed by the witness and identified by the log and timestamp of the cosigned STH
+func checkSTHCosignature(data *mtr.CTObject) error {
+	cosig, err := cto.DeconstructSTHCosignature(data)
+	if err != nil{
+		return fmt.Errorf("Error deconstructing STH Cosignature: %s\n", err)
+	}
+	tbs := cosig.TBS
+	if tbs.Witness != data.Signer {
+		return fmt.Errorf("%w: signer %v, cosigned by %v", ErrInconsistentObject, data.Signer, tbs.Witness)
+	}
+	if subject := cto.CosignatureSubject(tbs.STH.LogID, tbs.Witness); subject != data.Subject {
+		return fmt.Errorf("%w: subject %v, cosignature of log %v by %v", ErrInconsistentObject, data.Subject, tbs.STH.LogID, tbs.Witness)
+	}
+	if tbs.STH.TreeHeadData.Timestamp != data.Timestamp {
+		return fmt.Errorf("%w: timestamp %v, STH timestamp %v", ErrInconsistentObject, data.Timestamp, tbs.STH.TreeHeadData.Timestamp)
+	}
+	return nil
+}
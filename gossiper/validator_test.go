@@ -0,0 +1,325 @@
+package main
+
+import (
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/sha256"
+  "crypto/x509"
+  "encoding/base64"
+  "errors"
+  "strings"
+  "testing"
+
+  ct "github.com/google/certificate-transparency-go"
+  tls "github.com/google/certificate-transparency-go/tls"
+  cto "github.com/n-ct/ct-gossiper"
+  mtr "github.com/n-ct/ct-monitor"
+  mtrList "github.com/n-ct/ct-monitor/entitylist"
+  signature "github.com/n-ct/ct-monitor/signature"
+)
+
+//testKey is a log or monitor key created for a test
+type testKey struct{
+  id string
+  key string
+  signer *signature.Signer
+}
+
+func newTestKey(t *testing.T) *testKey {
+  privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    t.Fatal(err)
+  }
+  der, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+  if err != nil {
+    t.Fatal(err)
+  }
+  id := sha256.Sum256(der)
+  return &testKey{id: base64.StdEncoding.EncodeToString(id[:]), key: base64.StdEncoding.EncodeToString(der), signer: &signature.Signer{PrivKey: privKey}}
+}
+
+//addTestLog adds a log with a new key to the log list, mustGossiperSetup reloads the list
+func addTestLog(t *testing.T) *testKey {
+  k := newTestKey(t)
+  listsLock.Lock()
+  allLogs.Operators = append(allLogs.Operators, &mtrList.Operator{Name: "test", Logs: []*mtrList.LogInfo{{LogID: k.id, Key: k.key, URL: "http://localhost:6000/" + k.id}}})
+  listsLock.Unlock()
+  return k
+}
+
+//addTestMonitor adds a monitor with a new key to the monitor list
+func addTestMonitor(t *testing.T) *testKey {
+  k := newTestKey(t)
+  listsLock.Lock()
+  allMonitors.MonitorOperators = append(allMonitors.MonitorOperators, &mtrList.MonitorOperator{Name: "test", Monitors: []*mtrList.MonitorInfo{{MonitorID: k.id, MonitorKey: k.key}}})
+  listsLock.Unlock()
+  return k
+}
+
+//signedTreeHead returns an STH of the log for the tree, the root is filled with rootByte
+func (k *testKey) signedTreeHead(t *testing.T, timestamp uint64, treeSize uint64, rootByte byte) *mtr.SignedTreeHeadData {
+  var root ct.SHA256Hash
+  for i := range root {
+    root[i] = rootByte
+  }
+  return k.signTreeHead(t, ct.TreeHeadSignature{Version: ct.V1, SignatureType: ct.TreeHashSignatureType, Timestamp: timestamp, TreeSize: treeSize, SHA256RootHash: root})
+}
+
+func (k *testKey) signTreeHead(t *testing.T, treeHead ct.TreeHeadSignature) *mtr.SignedTreeHeadData {
+  sig, err := k.signer.CreateSignature(tls.SHA256, treeHead)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return &mtr.SignedTreeHeadData{LogID: k.id, TreeHeadData: treeHead, Signature: *sig}
+}
+
+//sth returns an STH CTObject of the log
+func (k *testKey) sth(t *testing.T, timestamp uint64, treeSize uint64, rootByte byte) mtr.CTObject {
+  return mustConstruct(t, k.signedTreeHead(t, timestamp, treeSize, rootByte))
+}
+
+//alert returns an alert of the monitor about subject
+func (k *testKey) alert(t *testing.T, alertType string, subject string, timestamp uint64) mtr.CTObject {
+  tbs := mtr.AlertSignedFields{AlertType: alertType, Signer: k.id, Subject: subject, Timestamp: timestamp}
+  sig, err := k.signer.CreateSignature(tls.SHA256, tbs)
+  if err != nil {
+    t.Fatal(err)
+  }
+  data := mustConstruct(t, &mtr.Alert{TBS: tbs, Signature: *sig})
+  data.Subject = subject
+  return data
+}
+
+//srd returns a signed revocation digest of the log for the revocation vector crv
+func (k *testKey) srd(t *testing.T, timestamp uint64, crv string) mtr.CTObject {
+  digest := mtr.RevocationDigest{Timestamp: timestamp, CRVHash: []byte(crv), CRVDeltaHash: []byte("delta")}
+  sig, err := k.signer.CreateSignature(tls.SHA256, digest)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return mustConstruct(t, &mtr.SRDWithRevData{RevData: mtr.RevocationData{EntityID: k.id, RevocationType: "Let's-Revoke", Timestamp: timestamp}, SRD: mtr.SignedRevocationDigest{EntityID: k.id, RevDigest: digest, Signature: *sig}})
+}
+
+func mustConstruct(t *testing.T, i interface{}) mtr.CTObject {
+  data, err := mtr.ConstructCTObject(i)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return *data
+}
+
+func mustPoM(t *testing.T, sth1 mtr.CTObject, sth2 mtr.CTObject) mtr.CTObject {
+  pom, err := mtr.CreateConflictingSTHPOM(&sth1, &sth2)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return *pom
+}
+
+//modified returns a copy of data changed by change
+func modified(data mtr.CTObject, change func(data *mtr.CTObject)) mtr.CTObject {
+  change(&data)
+  return data
+}
+
+type validatorTest struct {
+  name string
+  data mtr.CTObject
+  expected error //nil when the object is valid, otherwise an error it must wrap, or errAny
+}
+
+var errAny = errors.New("any error")
+
+func runValidatorTests(t *testing.T, tests []validatorTest){
+  for _, test := range tests {
+    err := ValidateSignature(&test.data)
+    switch {
+    case test.expected == nil && err != nil:
+      t.Errorf("%v: expected valid, got %v", test.name, err)
+    case test.expected == errAny && err == nil:
+      t.Errorf("%v: expected an error", test.name)
+    case test.expected != nil && test.expected != errAny && !errors.Is(err, test.expected):
+      t.Errorf("%v: expected %v, got %v", test.name, test.expected, err)
+    }
+  }
+}
+
+func TestSTHValidator(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  other := addTestLog(t)
+  sth := log.sth(t, timestamp, 10, 1)
+  otherBlob := other.sth(t, timestamp, 10, 1).Blob
+
+  runValidatorTests(t, []validatorTest{
+    {"valid", sth, nil},
+    {"fixture", sthCTObject, nil},
+    {"unknown log", modified(sth, func(d *mtr.CTObject){ d.Signer = "unknown" }), ErrUnknownSigner},
+    {"digest mismatch", modified(sth, func(d *mtr.CTObject){ d.Digest = sthDigest }), ErrDigestMismatch},
+    {"short digest", modified(sth, func(d *mtr.CTObject){ d.Digest = d.Digest[:4] }), ErrDigestMismatch},
+    {"bad signature", sthInvalidCTObject, errAny},
+    {"signed by another log", modified(sth, func(d *mtr.CTObject){ d.Blob = otherBlob }), errAny},
+    {"wrong timestamp", modified(sth, func(d *mtr.CTObject){ d.Timestamp++ }), ErrInconsistentObject},
+    {"conflicting STH under another subject", modified(log.sth(t, timestamp, 10, 2), func(d *mtr.CTObject){ d.Subject = "elsewhere" }), ErrInconsistentObject},
+    {"not an STH", modified(sth, func(d *mtr.CTObject){ d.Blob = []byte("not json") }), errAny},
+  })
+}
+
+func TestAlertValidator(t *testing.T){
+  mustGossiperSetup(t)
+  monitor := addTestMonitor(t)
+  alert := monitor.alert(t, "LoggerNonResponsive", loggerSigner, timestamp)
+  signed, err := alert.DeconstructAlert()
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  runValidatorTests(t, []validatorTest{
+    {"valid", alert, nil},
+    {"unknown monitor", modified(alert, func(d *mtr.CTObject){ d.Signer = "unknown" }), ErrUnknownSigner},
+    {"other subject", modified(alert, func(d *mtr.CTObject){ d.Subject = "another log" }), ErrInconsistentObject},
+    {"subject left to the signed alert, as ct-monitor builds it", mustConstruct(t, signed), nil},
+    {"alert without a subject", monitor.alert(t, "LoggerNonResponsive", "", timestamp), ErrInconsistentObject},
+    {"wrong timestamp", modified(alert, func(d *mtr.CTObject){ d.Timestamp = 1 }), ErrInconsistentObject},
+    {"digest mismatch", modified(alert, func(d *mtr.CTObject){ d.Digest = sthDigest }), ErrDigestMismatch},
+  })
+}
+
+func TestConflictingSTHPOMValidator(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  other := addTestLog(t)
+  sth1 := log.sth(t, timestamp, 10, 1)
+  sth2 := log.sth(t, timestamp, 10, 2)
+  resigned := log.sth(t, timestamp, 10, 1) //same tree head, different signature
+
+  runValidatorTests(t, []validatorTest{
+    {"valid", mustPoM(t, sth1, sth2), nil},
+    {"same tree head", mustPoM(t, sth1, resigned), ErrInconsistentObject},
+    {"different logs", mustPoM(t, sth1, other.sth(t, timestamp, 10, 2)), ErrInconsistentObject},
+    {"wrong subject", modified(mustPoM(t, sth1, sth2), func(d *mtr.CTObject){ d.Subject = other.id }), ErrInconsistentObject},
+    {"unknown log", mustPoM(t, sth1, newTestKey(t).sth(t, timestamp, 10, 2)), ErrUnknownSigner},
+  })
+}
+
+func TestSRDValidator(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  srd := log.srd(t, timestamp, "crv")
+
+  runValidatorTests(t, []validatorTest{
+    {"valid", srd, nil},
+    {"unknown log", modified(srd, func(d *mtr.CTObject){ d.Signer = "unknown" }), ErrUnknownSigner},
+    {"wrong timestamp", modified(srd, func(d *mtr.CTObject){ d.Timestamp++ }), ErrInconsistentObject},
+    {"conflicting SRD under another subject", modified(log.srd(t, timestamp, "other crv"), func(d *mtr.CTObject){ d.Subject = "elsewhere" }), ErrInconsistentObject},
+  })
+}
+
+func TestRegisterValidator(t *testing.T){
+  mustGossiperSetup(t)
+  const typeID = "TEST_OBJECT"
+  RegisterValidator(typeID, ValidatorFuncs{
+    SignatureFunc: func(data *mtr.CTObject) (tls.HashAlgorithm, error) { return tls.SHA256, nil },
+    SemanticFunc: func(data *mtr.CTObject) error {
+      if !strings.HasPrefix(string(data.Blob), "test") {
+        return ErrInconsistentObject
+      }
+      return nil
+    },
+  })
+  defer func(){
+    validatorsLock.Lock()
+    delete(validators, typeID)
+    validatorsLock.Unlock()
+  }()
+
+  digest := sha256.Sum256([]byte("test object"))
+  object := mtr.CTObject{TypeID: typeID, Version: version, Timestamp: timestamp, Signer: "tester", Digest: digest[:], Blob: []byte("test object")}
+  runValidatorTests(t, []validatorTest{
+    {"valid", object, nil},
+    {"semantic check", modified(object, func(d *mtr.CTObject){
+      d.Blob = []byte("other object")
+      otherDigest := sha256.Sum256(d.Blob)
+      d.Digest = otherDigest[:]
+    }), ErrInconsistentObject},
+    {"unregistered", modified(object, func(d *mtr.CTObject){ d.TypeID = "OTHER_OBJECT" }), errAny},
+  })
+  if typeLabel(typeID) != typeID {
+    t.Errorf("Expected a registered type to get its own metric label")
+  }
+
+  defer func(){
+    if recover() == nil {
+      t.Errorf("Expected registering %v twice to panic", typeID)
+    }
+  }()
+  RegisterValidator(typeID, ValidatorFuncs{})
+}
+
+func TestConflictingSRDPOMValidator(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  srd1 := log.srd(t, timestamp, "crv")
+  srdPoM := func(obj1 mtr.CTObject, obj2 mtr.CTObject) mtr.CTObject {
+    pom, err := mtr.CreateConflictingSRDPOM(&obj1, &obj2)
+    if err != nil {
+      t.Fatal(err)
+    }
+    return *pom
+  }
+
+  runValidatorTests(t, []validatorTest{
+    {"valid", srdPoM(srd1, log.srd(t, timestamp, "other crv")), nil},
+    {"same digest", srdPoM(srd1, log.srd(t, timestamp, "crv")), ErrInconsistentObject},
+    {"other timestamp", srdPoM(srd1, log.srd(t, timestamp+1, "other crv")), ErrInconsistentObject},
+    {"unknown log", srdPoM(srd1, newTestKey(t).srd(t, timestamp, "other crv")), ErrUnknownSigner},
+  })
+}
+
+func mustEquivocation(t *testing.T, alert1 mtr.CTObject, alert2 mtr.CTObject) mtr.CTObject {
+  pom, err := cto.CreateMonitorEquivocationPOM(&alert1, &alert2)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return *pom
+}
+
+//equivocation builds a MonitorEquivocationPOM of two alerts without the checks of CreateMonitorEquivocationPOM
+func equivocation(t *testing.T, obj1 mtr.CTObject, obj2 mtr.CTObject) mtr.CTObject {
+  alert1, err := obj1.DeconstructAlert()
+  if err != nil {
+    t.Fatal(err)
+  }
+  alert2, err := obj2.DeconstructAlert()
+  if err != nil {
+    t.Fatal(err)
+  }
+  blob, err := signature.SerializeData(cto.MonitorEquivocationPOM{Alert1: *alert1, Alert2: *alert2})
+  if err != nil {
+    t.Fatal(err)
+  }
+  digest, _, err := signature.GenerateHash(tls.SHA256, blob)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return mtr.CTObject{TypeID: cto.MonitorEquivocationPOMTypeID, Version: mtr.VersionData{Major: 1}, Timestamp: alert1.TBS.Timestamp, Subject: alert1.TBS.Signer, Digest: digest, Blob: blob}
+}
+
+func TestMonitorEquivocationPOMValidator(t *testing.T){
+  mustGossiperSetup(t)
+  monitor := addTestMonitor(t)
+  other := addTestMonitor(t)
+  unknown := newTestKey(t)
+  alert1 := monitor.alert(t, "LoggerNonResponsive", loggerSigner, timestamp)
+  alert2 := monitor.alert(t, "LoggerResponsive", loggerSigner, timestamp)
+  valid := mustEquivocation(t, alert1, alert2)
+
+  runValidatorTests(t, []validatorTest{
+    {"valid", valid, nil},
+    {"other subject", equivocation(t, alert1, monitor.alert(t, "LoggerResponsive", "another log", timestamp)), ErrInconsistentObject},
+    {"other timestamp", mustEquivocation(t, alert1, monitor.alert(t, "LoggerResponsive", loggerSigner, timestamp+1)), ErrInconsistentObject},
+    {"about another monitor", modified(valid, func(d *mtr.CTObject){ d.Subject = other.id }), errAny},
+    {"unknown monitor", mustEquivocation(t, unknown.alert(t, "LoggerNonResponsive", loggerSigner, timestamp), unknown.alert(t, "LoggerResponsive", loggerSigner, timestamp)), ErrUnknownSigner},
+  })
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	cto "github.com/n-ct/ct-gossiper"
+	mtr "github.com/n-ct/ct-monitor"
+	signature "github.com/n-ct/ct-monitor/signature"
+)
+
+//results of a cosigning attempt, used as the result label with AuditError
+const (
+	WitnessCosigned = "cosigned"
+	WitnessRefused = "refused"
+	WitnessDropped = "dropped" //the queue of the log was full
+)
+
+var witness *Witness;
+
+// Witness cosigns the STHs of the logs with the key of our monitor. Before cosigning an STH it
+// checks that the tree extends the tree of the last STH it cosigned for the log, with the proof
+// of an STH_POC or one requested from the log, and refuses it otherwise. STHs older than the last
+// cosigned one are skipped, so a witness never goes back. The last cosigned STH of each log is
+// kept in the state file of the witness rather than found among the stored cosignatures, which
+// are pruned like any object. Cosignatures are stored and gossiped like any new object, so that
+// clients can require the cosignatures of k of n witnesses.
+type Witness struct{
+	id string
+	signer *signature.Signer
+	timeout time.Duration
+	statePath string
+	queueSize int
+
+	mu sync.Mutex //guards last and the state file
+	last map[string]*mtr.SignedTreeHeadData //[LogID] last STH cosigned
+	queuesLock sync.Mutex //guards queues and stopped, STHs are only sent to a queue with it held
+	queues map[string]chan *mtr.CTObject //[LogID] STHs waiting to be cosigned, each has one worker
+	stopped bool
+	stop chan struct{}
+	running sync.WaitGroup //the workers of the queues
+}
+
+//NewWitness creates the witness id from the witness section of the configuration, publicKey is the key of id in the monitor list
+func NewWitness(config cto.WitnessConfig, id string, publicKey string) (*Witness, error) {
+	signer, err := newMonitorSigner(config.Priv_key, id, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	w := &Witness{
+		id: id,
+		signer: signer,
+		timeout: cto.Milliseconds(config.Timeout_ms),
+		statePath: config.State_path,
+		queueSize: config.Queue_size,
+		last: make(map[string]*mtr.SignedTreeHeadData),
+		queues: make(map[string]chan *mtr.CTObject),
+		stop: make(chan struct{}),
+	}
+	if err := w.loadState(); err != nil {
+		return nil, fmt.Errorf("state %v: %v", w.statePath, err)
+	}
+	return w, nil
+}
+
+//loadState reads the last cosigned STHs from the state file, a missing file is not an error
+func (w *Witness) loadState() error {
+	if len(w.statePath) == 0 {
+		return nil
+	}
+	byteData, err := ioutil.ReadFile(w.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(byteData, &w.last); err != nil {
+		return err
+	}
+	glog.Infof("Loaded the last cosigned STH of %v logs from %v\n", len(w.last), w.statePath)
+	return nil
+}
+
+//saveState writes the last cosigned STHs to the state file, replacing it only once the new one is complete. Must be called with mu held
+func (w *Witness) saveState() error {
+	if len(w.statePath) == 0 {
+		return nil
+	}
+	jsonStr, err := json.Marshal(w.last)
+	if err != nil {
+		return err
+	}
+	tmp := w.statePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, jsonStr, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.statePath)
+}
+
+//witnessNewSTH queues a newly stored STH or STH_POC for the witness, as a proof may have to be requested from the log
+func witnessNewSTH(data *mtr.CTObject){
+	if witness == nil || (data.TypeID != mtr.STHTypeID && data.TypeID != mtr.STHPOCTypeID) {
+		return
+	}
+	witness.enqueue(data)
+}
+
+// enqueue adds data to the queue of its log, returns false if the queue is full, the log is not in
+// the log list or the witness is stopped. STHs of a log are cosigned one at a time, in the order
+// they were stored, by a worker started with the queue, so there is at most one queue and one
+// worker per log of the list. A dropped STH is not lost for good, the next STH of the log is
+// checked against the same cosigned one.
+func (w *Witness) enqueue(data *mtr.CTObject) bool {
+	w.queuesLock.Lock()
+	defer w.queuesLock.Unlock()
+	if w.stopped {
+		return false
+	}
+	queue, ok := w.queues[data.Signer]
+	if !ok {
+		if getLogs().FindLogByLogID(data.Signer) == nil {
+			glog.Infof("%s Not cosigned, log %v is not in the log list\n", cto.IdentifierToString(data.Identifier()), data.Signer)
+			return false
+		}
+		queue = make(chan *mtr.CTObject, w.queueSize)
+		w.queues[data.Signer] = queue
+		w.running.Add(1)
+		go w.run(data.Signer, queue)
+	}
+	select {
+	case queue <- data:
+		return true
+	default:
+		witnessCosignatures.WithLabelValues(WitnessDropped).Inc()
+		glog.Errorf("Witness queue of log %v full, dropping %s\n", data.Signer, cto.IdentifierToString(data.Identifier()))
+		return false
+	}
+}
+
+//run cosigns the STHs of the queue of the log until the queue is empty or the witness is stopped, the queue is then removed
+func (w *Witness) run(logID string, queue chan *mtr.CTObject){
+	defer w.running.Done()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case data := <-queue:
+			if err := w.Cosign(data); err != nil {
+				glog.Infof("%s Not cosigned: %v\n", cto.IdentifierToString(data.Identifier()), err)
+			}
+		}
+		w.queuesLock.Lock()
+		if len(queue) == 0 {
+			delete(w.queues, logID)
+			w.queuesLock.Unlock()
+			return
+		}
+		w.queuesLock.Unlock()
+	}
+}
+
+// Stop refuses the STHs enqueued from now on and waits for the workers to finish the STH they are
+// cosigning. The STHs left in the queues are dropped, the last cosigned STHs are already saved.
+func (w *Witness) Stop(){
+	w.queuesLock.Lock()
+	if w.stopped {
+		w.queuesLock.Unlock()
+		return
+	}
+	w.stopped = true
+	close(w.stop)
+	w.queuesLock.Unlock()
+	w.running.Wait()
+}
+
+// Cosign stores and gossips the cosignature of the STH of data, an STH or STH_POC, when its tree
+// extends the tree of the last STH cosigned for the log. It returns an error wrapping
+// ErrProofFailed when it does not, or the error that prevented the check, in which case the
+// next STH of the log is checked against the same cosigned STH. The STHs of a log must be
+// cosigned one at a time, which the queue of the log ensures.
+func (w *Witness) Cosign(data *mtr.CTObject) error {
+	sth, err := data.DeconstructSTH()
+	if err != nil {
+		return err
+	}
+
+	if last := w.lastCosigned(sth.LogID); last != nil {
+		if sth.TreeHeadData.Timestamp <= last.TreeHeadData.Timestamp {
+			return nil
+		}
+		if err := w.checkExtends(last, sth, data); err != nil {
+			if errors.Is(err, ErrProofFailed) {
+				witnessCosignatures.WithLabelValues(WitnessRefused).Inc()
+			} else {
+				witnessCosignatures.WithLabelValues(AuditError).Inc()
+			}
+			return err
+		}
+	}
+
+	cosig, err := cto.CreateSTHCosignature(w.signer, w.id, sth, uint64(time.Now().UnixNano() / int64(time.Millisecond)))
+	if err != nil {
+		return err
+	}
+	//the state is saved before the cosignature is sent, so that a restart never goes back
+	if err := w.setLastCosigned(sth); err != nil {
+		return fmt.Errorf("saving the witness state: %v", err)
+	}
+	identifierStr := cto.IdentifierToString(cosig.Identifier())
+	if !storeEntry(messages, *cosig, cosig.Identifier()) {
+		glog.Infof("%s Cosignature already stored\n", identifierStr)
+		return nil
+	}
+	witnessCosignatures.WithLabelValues(WitnessCosigned).Inc()
+	glog.Infof("%s Cosigned STH of size %v\n", identifierStr, sth.TreeHeadData.TreeSize)
+	gossipNewData(cosig, "")
+	return nil
+}
+
+//lastCosigned returns the STH of the log with the latest timestamp that the witness cosigned, nil if there is none
+func (w *Witness) lastCosigned(logID string) *mtr.SignedTreeHeadData {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last[logID]
+}
+
+//setLastCosigned records sth as the last STH cosigned for its log and saves the state, which is left unchanged on error
+func (w *Witness) setLastCosigned(sth *mtr.SignedTreeHeadData) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	previous, ok := w.last[sth.LogID]
+	w.last[sth.LogID] = sth
+	if err := w.saveState(); err != nil {
+		if ok {
+			w.last[sth.LogID] = previous
+		} else {
+			delete(w.last, sth.LogID)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkExtends checks that the tree of sth extends the tree of last. Trees of the same size must
+// have the same root. Otherwise the proof of data is used when it is an STH_POC from the size of
+// last and verifies, else the proof is requested from the log and stored as an STH_POC, reported
+// locally if it fails. A proof that came from a peer is not signed by the log, so the witness
+// only refuses an STH on the answer of the log itself.
+func (w *Witness) checkExtends(last *mtr.SignedTreeHeadData, sth *mtr.SignedTreeHeadData, data *mtr.CTObject) error {
+	oldSize, newSize := last.TreeHeadData.TreeSize, sth.TreeHeadData.TreeSize
+	if oldSize == 0 || oldSize >= newSize { //no proof is needed, or none can exist
+		return VerifyConsistencyProof(oldSize, newSize, last.TreeHeadData.SHA256RootHash[:], sth.TreeHeadData.SHA256RootHash[:], nil)
+	}
+	if data.TypeID == mtr.STHPOCTypeID {
+		if poc, err := readPOC(data); err == nil && poc.proof.TreeSize1 == oldSize && verifyPOC(last, poc) == nil {
+			return nil
+		}
+	}
+
+	return proofFromLog(last, sth, w.timeout)
+}
+
+// CosignaturesHandler is called on a Get request to /ct/v1/cosignatures.
+// It returns the cosignatures of every witness, ours and the gossiped ones, for the STHs of the
+// log given by log_id, only for the STH with the given timestamp when it is set. They are sorted
+// by STH timestamp and witness.
+func CosignaturesHandler(w http.ResponseWriter, req *http.Request){
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	logID := req.URL.Query().Get("log_id")
+	if len(logID) == 0 {
+		http.Error(w, "log_id is required", http.StatusBadRequest)
+		return
+	}
+	timestamp, err := parseTimestamp(req, "timestamp")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := cto.CosignaturesResponse{Cosignatures: []cto.STHCosignature{}}
+	storeLock.RLock()
+	for subject, byTimestamp := range messages[cto.STHCosignatureTypeID] {
+		if !strings.HasPrefix(subject, cto.CosignatureSubject(logID, "")) {
+			continue
+		}
+		for sthTimestamp, byVersion := range byTimestamp {
+			if timestamp != 0 && sthTimestamp != timestamp {
+				continue
+			}
+			for _, data := range byVersion {
+				if cosig, err := cto.DeconstructSTHCosignature(data); err == nil {
+					resp.Cosignatures = append(resp.Cosignatures, *cosig)
+				}
+			}
+		}
+	}
+	storeLock.RUnlock()
+	sort.Slice(resp.Cosignatures, func(i, j int) bool {
+		a, b := resp.Cosignatures[i].TBS, resp.Cosignatures[j].TBS
+		if a.STH.TreeHeadData.Timestamp != b.STH.TreeHeadData.Timestamp {
+			return a.STH.TreeHeadData.Timestamp < b.STH.TreeHeadData.Timestamp
+		}
+		return a.Witness < b.Witness
+	})
+	writeJSON(w, resp)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "path/filepath"
+  "testing"
+  "time"
+
+  cto "github.com/n-ct/ct-gossiper"
+  mtr "github.com/n-ct/ct-monitor"
+)
+
+//newTestWitness returns the witness of the monitor of k, keeping its state in a temporary directory
+func newTestWitness(t *testing.T, k *testKey, config cto.WitnessConfig) *Witness {
+  config.Priv_key = k.privKey(t)
+  if len(config.State_path) == 0 {
+    config.State_path = filepath.Join(t.TempDir(), "witness.json")
+  }
+  config.Timeout_ms = 1000
+  w, err := NewWitness(config, k.id, k.key)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return w
+}
+
+//cosign returns the cosignature of sth by the monitor of k
+func (k *testKey) cosign(t *testing.T, sth *mtr.SignedTreeHeadData) mtr.CTObject {
+  data, err := cto.CreateSTHCosignature(k.signer, k.id, sth, timestamp)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return *data
+}
+
+func TestSTHCosignatureValidator(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  monitor := addTestMonitor(t)
+  other := addTestMonitor(t)
+  cosig := monitor.cosign(t, log.signedTreeHead(t, timestamp, 10, 1))
+
+  runValidatorTests(t, []validatorTest{
+    {"valid", cosig, nil},
+    {"unknown witness", newTestKey(t).cosign(t, log.signedTreeHead(t, timestamp, 10, 1)), ErrUnknownSigner},
+    {"unknown log", monitor.cosign(t, newTestKey(t).signedTreeHead(t, timestamp, 10, 1)), ErrUnknownSigner},
+    {"other signer", modified(cosig, func(d *mtr.CTObject){ d.Signer = other.id }), ErrInconsistentObject},
+    {"other subject", modified(cosig, func(d *mtr.CTObject){ d.Subject = log.id }), ErrInconsistentObject},
+    {"wrong timestamp", modified(cosig, func(d *mtr.CTObject){ d.Timestamp++ }), ErrInconsistentObject},
+    {"digest mismatch", modified(cosig, func(d *mtr.CTObject){ d.Digest = sthDigest }), ErrDigestMismatch},
+  })
+}
+
+func TestNewWitness(t *testing.T){
+  monitor := newTestKey(t)
+  config := cto.WitnessConfig{Enabled: true, Priv_key: monitor.privKey(t), Timeout_ms: 1000}
+  if _, err := NewWitness(config, monitor.id, monitor.key); err != nil {
+    t.Errorf("Expected the witness to be created: %v", err)
+  }
+  if _, err := NewWitness(config, monitor.id, newTestKey(t).key); err == nil {
+    t.Errorf("Expected a key that is not the one of the monitor list to be rejected")
+  }
+}
+
+func TestWitnessCosign(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  monitor := addTestMonitor(t)
+  w := newTestWitness(t, monitor, cto.WitnessConfig{})
+  leaves := testLeaves(16)
+  forked := testLeaves(16)
+  forked[5] = forked[6]
+  sth := func(i int, size int) mtr.CTObject {
+    return mustConstruct(t, log.treeHead(t, timestamp+uint64(i), leaves, size))
+  }
+
+  testTables := []struct {
+    name string
+    data mtr.CTObject
+    served [][]byte //tree the log builds proofs from, nil when it is unavailable
+    cosigned bool
+    refused bool
+  }{
+    {"first STH", sth(0, 3), leaves, true, false},
+    {"same tree", sth(1, 3), leaves, true, false},
+    {"larger tree, proof from the log", sth(2, 7), leaves, true, false},
+    {"STH_POC with a bad proof", log.sthPOC(t, timestamp+3, leaves, 7, 11, consistencyProof(7, forked[:11])), nil, false, false},
+    {"STH_POC from the cosigned size", log.sthPOC(t, timestamp+3, leaves, 7, 12, consistencyProof(7, leaves[:12])), nil, true, false},
+    {"older STH", sth(1, 3), nil, false, false},
+    {"smaller tree", sth(4, 10), nil, false, true},
+    {"same size, other root", mustConstruct(t, log.treeHead(t, timestamp+5, forked, 12)), nil, false, true},
+    {"log unavailable", sth(6, 16), nil, false, false},
+    {"log proves a fork", sth(7, 16), forked, false, true},
+    {"log is back", sth(8, 16), leaves, true, false},
+  }
+  for _, testTable := range testTables{
+    var handler http.Handler = http.NotFoundHandler()
+    if testTable.served != nil {
+      handler = &fakeLog{served: testTable.served}
+    }
+    server := httptest.NewServer(handler)
+    logInfoAt(t, log, server.URL)
+    storeEntry(messages, testTable.data, testTable.data.Identifier())
+
+    err := w.Cosign(&testTable.data)
+    server.Close()
+    if refused := errors.Is(err, ErrProofFailed); refused != testTable.refused {
+      t.Errorf("%v: expected refused %v, got %v", testTable.name, testTable.refused, err)
+    }
+    last := w.lastCosigned(log.id)
+    if cosigned := last != nil && last.TreeHeadData.Timestamp == testTable.data.Timestamp; cosigned != testTable.cosigned {
+      t.Errorf("%v: expected cosigned %v, got %v", testTable.name, testTable.cosigned, err)
+    }
+  }
+
+  cosigs := storedObjects(cto.STHCosignatureTypeID, cto.CosignatureSubject(log.id, monitor.id))
+  if len(cosigs) != 5 {
+    t.Errorf("Expected 5 cosignatures, got %v", len(cosigs))
+  }
+  for _, cosig := range cosigs {
+    if err := ValidateSignature(cosig); err != nil {
+      t.Errorf("The cosignature does not validate: %v", err)
+    }
+  }
+  if reports := storedObjects(cto.InconsistentSTHPOMTypeID, log.id); len(reports) != 1 {
+    t.Errorf("Expected only the forked proof of the log to be reported, got %v", len(reports))
+  }
+}
+
+func TestWitnessState(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  monitor := addTestMonitor(t)
+  config := cto.WitnessConfig{State_path: filepath.Join(t.TempDir(), "witness.json")}
+  leaves := testLeaves(8)
+  server := httptest.NewServer(&fakeLog{served: leaves})
+  defer server.Close()
+  logInfoAt(t, log, server.URL)
+
+  sth := mustConstruct(t, log.treeHead(t, timestamp, leaves, 8))
+  if err := newTestWitness(t, monitor, config).Cosign(&sth); err != nil {
+    t.Fatal(err)
+  }
+  //the cosignature is pruned, the witness restarted from its state still refuses a smaller tree
+  PruneStore(time.Nanosecond)
+  w := newTestWitness(t, monitor, config)
+  if last := w.lastCosigned(log.id); last == nil || last.TreeHeadData.Timestamp != timestamp {
+    t.Fatalf("Expected the last cosigned STH to be kept, got %v", last)
+  }
+  smaller := mustConstruct(t, log.treeHead(t, timestamp+1, leaves, 4))
+  if err := w.Cosign(&smaller); !errors.Is(err, ErrProofFailed) {
+    t.Errorf("Expected a smaller tree to be refused, got %v", err)
+  }
+
+  if err := ioutil.WriteFile(config.State_path, []byte("not json"), 0600); err != nil {
+    t.Fatal(err)
+  }
+  config.Priv_key = monitor.privKey(t)
+  if _, err := NewWitness(config, monitor.id, monitor.key); err == nil {
+    t.Errorf("Expected a corrupt state file to be rejected")
+  }
+}
+
+func TestWitnessQueue(t *testing.T){
+  mustGossiperSetup(t)
+  log := addTestLog(t)
+  monitor := addTestMonitor(t)
+  leaves := testLeaves(8)
+  sth := mustConstruct(t, log.treeHead(t, timestamp, leaves, 8))
+
+  //the queue has no worker yet, it is run below once the queue is full
+  w := newTestWitness(t, monitor, cto.WitnessConfig{Queue_size: 1})
+  queue := make(chan *mtr.CTObject, 1)
+  w.queues[log.id] = queue
+  if !w.enqueue(&sth) || w.enqueue(&sth) {
+    t.Errorf("Expected only queue_size STHs to be queued")
+  }
+  w.running.Add(1)
+  w.run(log.id, queue)
+  if last := w.lastCosigned(log.id); last == nil || last.TreeHeadData.Timestamp != timestamp {
+    t.Errorf("Expected the queued STH to be cosigned, got %v", last)
+  }
+  if len(w.queues) != 0 {
+    t.Errorf("Expected the empty queue to be removed, got %v queues", len(w.queues))
+  }
+
+  unknown := newTestKey(t)
+  other := mustConstruct(t, unknown.treeHead(t, timestamp, leaves, 8))
+  if w.enqueue(&other) || len(w.queues) != 0 {
+    t.Errorf("Expected no queue for a log that is not in the log list")
+  }
+
+  newer := mustConstruct(t, log.treeHead(t, timestamp+1, leaves, 8))
+  if !w.enqueue(&newer) {
+    t.Errorf("Expected the STH to be queued")
+  }
+  w.Stop()
+  if w.enqueue(&newer) {
+    t.Errorf("Expected no STH to be queued once the witness is stopped")
+  }
+}
+
+func TestCosignaturesHandler(t *testing.T){
+  mustGossiperSetup(t)
+  logA := addTestLog(t)
+  logB := addTestLog(t)
+  witnessA := addTestMonitor(t)
+  witnessB := addTestMonitor(t)
+  for _, cosig := range []mtr.CTObject{
+    witnessA.cosign(t, logA.signedTreeHead(t, timestamp, 10, 1)),
+    witnessB.cosign(t, logA.signedTreeHead(t, timestamp, 10, 1)),
+    witnessA.cosign(t, logA.signedTreeHead(t, timestamp+1, 12, 2)),
+    witnessA.cosign(t, logB.signedTreeHead(t, timestamp, 10, 1)),
+  }{
+    storeEntry(messages, cosig, cosig.Identifier())
+  }
+
+  testTables := []struct {
+    query string
+    status int
+    cosignatures int
+  }{
+    {"", http.StatusBadRequest, 0},
+    {"log_id=" + url.QueryEscape(logA.id), http.StatusOK, 3},
+    {fmt.Sprintf("log_id=%s&timestamp=%d", url.QueryEscape(logA.id), timestamp), http.StatusOK, 2},
+    {fmt.Sprintf("log_id=%s&timestamp=%d", url.QueryEscape(logB.id), timestamp+1), http.StatusOK, 0},
+    {"log_id=" + url.QueryEscape(logA.id) + "&timestamp=now", http.StatusBadRequest, 0},
+  }
+  for _, testTable := range testTables{
+    recorder := httptest.NewRecorder()
+    CosignaturesHandler(recorder, httptest.NewRequest("GET", cto.CosignaturesPath + "?" + testTable.query, nil))
+    if recorder.Code != testTable.status {
+      t.Errorf("%q: expected status %v, got %v", testTable.query, testTable.status, recorder.Code)
+      continue
+    }
+    if recorder.Code != http.StatusOK {
+      continue
+    }
+    var resp cto.CosignaturesResponse
+    if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+      t.Fatal(err)
+    }
+    if len(resp.Cosignatures) != testTable.cosignatures {
+      t.Errorf("%q: expected %v cosignatures, got %v", testTable.query, testTable.cosignatures, len(resp.Cosignatures))
+    }
+  }
+}
@@ -18,7 +18,7 @@ const(
 	logID = "sh4FzIuizYogTodm+Su5iiUgZ2va+nDnsklTLe+LkF4="
 )
 var sthType = "STH"
-var sthVersion = mtr.VersionData{1,0,0}
+var sthVersion = mtr.VersionData{Major: 1, Minor: 0, Release: 0}
 var sthTimestamp uint64 =  1615091086773
 var sthSigner = "sh4FzIuizYogTodm+Su5iiUgZ2va+nDnsklTLe+LkF4="
 var sthSubject = ""
@@ -36,7 +36,7 @@ func main(){
 
 	var jsonStr []byte;
 
-	sthCTObject := mtr.CTObject{sthType, sthVersion, sthTimestamp, sthSigner, sthSubject, sthDigest, sthBlob}
+	sthCTObject := mtr.CTObject{TypeID: sthType, Version: sthVersion, Timestamp: sthTimestamp, Signer: sthSigner, Subject: sthSubject, Digest: sthDigest, Blob: sthBlob}
 	jsonStr, _ = json.Marshal(sthCTObject); //create a JSON string from CTObject struct
 
 	req, err := http.NewRequest("POST", fmt.Sprintf("http://localhost:%v/ct/v1/gossip", os.Args[1]), bytes.NewBuffer(jsonStr)); //create a post request
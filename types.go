@@ -1,17 +1,129 @@
 package CTObject
 
 import (
-	"fmt"
 	"encoding/json"
-	mtrUtils "github.com/n-ct/ct-monitor/utils"
+	"fmt"
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/tls"
 	mtr "github.com/n-ct/ct-monitor"
+	"github.com/n-ct/ct-monitor/signature"
 )
 
 const(
 	GossipPath = "/ct/v1/gossip"
-	Threshold = 1000000
+	PeerScoresPath = "/ct/v1/peer-scores"
+	MembershipJoinPath = "/ct/v1/membership/join"
+	MembershipLeavePath = "/ct/v1/membership/leave"
+	MembershipPingPath = "/ct/v1/membership/ping"
+	MembershipPingReqPath = "/ct/v1/membership/ping-req"
+	MembersPath = "/ct/v1/membership/members"
+	AdminPeersPath = "/ct/v1/admin/peers"
+	AdminPausePeerPath = "/ct/v1/admin/peers/pause"
+	AdminResumePeerPath = "/ct/v1/admin/peers/resume"
+	AdminReloadLogsPath = "/ct/v1/admin/reload-logs"
+	AdminReloadMonitorsPath = "/ct/v1/admin/reload-monitors"
+	AdminReloadPath = "/ct/v1/admin/reload"
+	MetricsPath = "/metrics"
+	HealthzPath = "/healthz"
+	ReadyzPath = "/readyz"
+	StatusPath = "/status"
+	ObjectsPath = "/ct/v1/objects"
+	ObjectPath = "/ct/v1/object"
+	LogsPath = "/ct/v1/logs"
+	FeedPath = "/ct/v1/feed"
+	ConflictsPath = "/ct/v1/conflicts"
+	SubmitSTHPath = "/ct/v1/submit-sth"
+	PollinationPath = "/.well-known/ct-gossip/v1/sth-pollination"
+	SCTFeedbackPath = "/.well-known/ct-gossip/v1/sct-feedback"
+	SCTsPath = "/ct/v1/scts"
+	CosignaturesPath = "/ct/v1/cosignatures"
+	Threshold = 1000000 //default blob size above which gossip is first sent without the blob
 )
 
+//TypeID of the proof that a monitor signed two different alerts with the same subject and timestamp
+const MonitorEquivocationPOMTypeID = "POM_MONITOR_EQUIVOCATION"
+
+type MonitorEquivocationPOM struct {
+	Alert1 mtr.Alert
+	Alert2 mtr.Alert
+}
+
+//TypeID of the local record that a log answered with a consistency proof that does not link an older STH of the log to the new one, it is never gossiped
+const InconsistentSTHPOMTypeID = "POM_INCONSISTENT_STH"
+
+type InconsistentSTHPOM struct {
+	OldSTH mtr.SignedTreeHeadData
+	NewSTH mtr.SignedTreeHeadWithConsistencyProof
+}
+
+//AlertType of the alert a monitor signs when a log did not include, within its MMD, a certificate it issued an SCT for.
+//Subject is the log ID and Timestamp the end of the MMD of the SCT
+const AlertSCTNotIncluded = "SCT_NOT_INCLUDED"
+
+//TypeID of the signature of a witness over an STH it checked to be consistent with the last STH of the log it cosigned
+const STHCosignatureTypeID = "STH_COSIGNATURE"
+
+//fields signed by a witness, Witness is its monitor ID and Timestamp the time it cosigned
+type CosignedTreeHead struct {
+	Witness string
+	Timestamp uint64
+	STH mtr.SignedTreeHeadData
+}
+
+type STHCosignature struct {
+	TBS CosignedTreeHead
+	Signature ct.DigitallySigned
+}
+
+//response of CosignaturesPath, the cosignatures of every witness for the STHs of a log
+type CosignaturesResponse struct {
+	Cosignatures []STHCosignature `json:"cosignatures"`
+}
+
+//body of a request to SubmitSTHPath, STH is the get-sth response of the log as defined in RFC 6962 section 4.3
+type STHSubmission struct {
+	LogID string `json:"log_id"`
+	STH ct.GetSTHResponse `json:"sth"`
+}
+
+//response to a submission, Identifier can be used to look the object up and Outcome is what the gossiper did with it
+type SubmissionResponse struct {
+	Identifier string `json:"identifier"`
+	TypeID string `json:"type_id"`
+	Subject string `json:"subject"`
+	Timestamp uint64 `json:"timestamp"`
+	Outcome string `json:"outcome"`
+}
+
+//an STH as exchanged in STH pollination: the get-sth response of the log with its version and LogID
+type PollinationSTH struct {
+	Version ct.Version `json:"sth_version"`
+	LogID string `json:"log_id"`
+	ct.GetSTHResponse
+}
+
+//body of a request to and response from PollinationPath
+type Pollination struct {
+	STHs []PollinationSTH `json:"sths"`
+}
+
+//a certificate chain, leaf first, with the SCTs a client received for it
+type SCTFeedbackItem struct {
+	X509Chain [][]byte `json:"x509_chain"` //DER certificates
+	SCTData [][]byte `json:"sct_data"` //TLS encoded SignedCertificateTimestamps
+}
+
+//body of a request to SCTFeedbackPath
+type SCTFeedback struct {
+	Feedback []SCTFeedbackItem `json:"sct_feedback"`
+}
+
+//response to SCT feedback, SCTs of unknown logs or with an invalid signature are rejected
+type SCTFeedbackResponse struct {
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+}
+
 type MessagesMap map[string]map[string]map[uint64]map[string] *mtr.CTObject;
 
 
@@ -34,24 +146,185 @@ func ToDebugString(data mtr.CTObject) (string){
 }
 
 func IdentifierToString(id mtr.ObjectIdentifier) string {
-	return fmt.Sprintf("[%s:%s:%s:%s]", id.First, id.Second, id.Third, id.Fourth)
+	return fmt.Sprintf("[%s:%s:%d:%s]", id.First, id.Second, id.Third, id.Fourth)
 }
 
-//structs for JSON files
-type GossipConfig struct{
-	Monitors_ids []string `json:"monitor_ids"`
-	Monitor_id string `json:"monitor_id"`
+// STHFromGetSTH builds the STH CTObject of a log from its get-sth response,
+// the same object a monitor builds when it polls the log.
+func STHFromGetSTH(logID string, resp *ct.GetSTHResponse) (*mtr.CTObject, error) {
+	sth, err := resp.ToSignedTreeHead()
+	if err != nil {
+		return nil, err
+	}
+	sthData := &mtr.SignedTreeHeadData{
+		LogID: logID,
+		TreeHeadData: ct.TreeHeadSignature{
+			Version: ct.V1,
+			SignatureType: ct.TreeHashSignatureType,
+			Timestamp: sth.Timestamp,
+			TreeSize: sth.TreeSize,
+			SHA256RootHash: sth.SHA256RootHash,
+		},
+		Signature: sth.TreeHeadSignature,
+	}
+	return mtr.ConstructCTObject(sthData)
+}
+
+//NewPollinationSTH returns the STH in the form it is exchanged in STH pollination
+func NewPollinationSTH(sth *mtr.SignedTreeHeadData) (PollinationSTH, error) {
+	sig, err := tls.Marshal(sth.Signature)
+	if err != nil {
+		return PollinationSTH{}, err
+	}
+	return PollinationSTH{
+		Version: sth.TreeHeadData.Version,
+		LogID: sth.LogID,
+		GetSTHResponse: ct.GetSTHResponse{
+			TreeSize: sth.TreeHeadData.TreeSize,
+			Timestamp: sth.TreeHeadData.Timestamp,
+			SHA256RootHash: sth.TreeHeadData.SHA256RootHash[:],
+			TreeHeadSignature: sig,
+		},
+	}, nil
 }
 
-func NewGossipConfig (filename string) (*GossipConfig) {
-	var gossipConfig GossipConfig;
-	byteData, err := mtrUtils.FiletoBytes(filename)
+// CreateMonitorEquivocationPOM builds the proof of misbehavior for two alerts of the same monitor
+// that share a subject and timestamp but differ in their signed fields. The subject of the PoM is the monitor.
+func CreateMonitorEquivocationPOM(obj1 *mtr.CTObject, obj2 *mtr.CTObject) (*mtr.CTObject, error) {
+	if obj1.TypeID != mtr.AlertTypeID || obj2.TypeID != mtr.AlertTypeID {
+		return nil, fmt.Errorf("Not valid Alert CTObjects")
+	}
+	alert1, err := obj1.DeconstructAlert()
+	if err != nil {
+		return nil, fmt.Errorf("error creating MonitorEquivocationPOM: %w", err)
+	}
+	alert2, err := obj2.DeconstructAlert()
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("error creating MonitorEquivocationPOM: %w", err)
 	}
-	if err := json.Unmarshal(byteData, &gossipConfig); err != nil {
-		fmt.Errorf("Failed to parse gossip configuration: %v", err);
-		return nil;
+	if alert1.TBS == alert2.TBS {
+		return nil, fmt.Errorf("Alerts are not conflicting. Error creating PoM")
+	}
+	if alert1.TBS.Signer != alert2.TBS.Signer {
+		return nil, fmt.Errorf("Alerts are signed by different monitors. Error creating PoM")
+	}
+	if alert1.TBS.Subject != alert2.TBS.Subject {
+		return nil, fmt.Errorf("Alerts are about different subjects. Error creating PoM")
+	}
+
+	blob, err := signature.SerializeData(MonitorEquivocationPOM{*alert1, *alert2})
+	if err != nil {
+		return nil, fmt.Errorf("error constructing MonitorEquivocationPOM serializing data: %w", err)
+	}
+	digest, _, err := signature.GenerateHash(alert1.Signature.Algorithm.Hash, blob)
+	if err != nil {
+		return nil, fmt.Errorf("error constructing MonitorEquivocationPOM generating hash: %w", err)
+	}
+	return &mtr.CTObject{
+		TypeID: MonitorEquivocationPOMTypeID,
+		Version: mtr.VersionData{Major: 1, Minor: 0, Release: 0},
+		Timestamp: alert1.TBS.Timestamp,
+		Subject: alert1.TBS.Signer,
+		Digest: digest,
+		Blob: blob,
+	}, nil
+}
+
+//DeconstructMonitorEquivocationPOM returns the alerts held by a MonitorEquivocationPOM CTObject
+func DeconstructMonitorEquivocationPOM(data *mtr.CTObject) (*MonitorEquivocationPOM, error) {
+	var pom MonitorEquivocationPOM
+	if err := json.Unmarshal(data.Blob, &pom); err != nil {
+		return nil, fmt.Errorf("error deconstructing MonitorEquivocationPOM from %s CTObject: %w", data.TypeID, err)
+	}
+	return &pom, nil
+}
+
+// CreateInconsistentSTHPOM builds the record of an STH_POC whose consistency proof fails to verify
+// from oldSTH, an STH of the same log for the first tree size of the proof. The proof is not signed
+// by the log, so the record only convinces the gossiper that requested the proof from the log
+// itself and is not transferable. The subject is the log and the timestamp the one of the new STH.
+func CreateInconsistentSTHPOM(oldSTH *mtr.SignedTreeHeadData, poc *mtr.CTObject) (*mtr.CTObject, error) {
+	if poc.TypeID != mtr.STHPOCTypeID {
+		return nil, fmt.Errorf("Not a valid STH_POC CTObject")
+	}
+	newSTH, err := poc.DeconstructSTH()
+	if err != nil {
+		return nil, fmt.Errorf("error creating InconsistentSTHPOM: %w", err)
+	}
+	proof, err := poc.DeconstructPOC()
+	if err != nil {
+		return nil, fmt.Errorf("error creating InconsistentSTHPOM: %w", err)
+	}
+	if oldSTH.LogID != newSTH.LogID || oldSTH.TreeHeadData.TreeSize != proof.TreeSize1 {
+		return nil, fmt.Errorf("STH of %v for size %v does not match the proof of %v from size %v. Error creating PoM", oldSTH.LogID, oldSTH.TreeHeadData.TreeSize, newSTH.LogID, proof.TreeSize1)
+	}
+
+	blob, err := signature.SerializeData(InconsistentSTHPOM{*oldSTH, mtr.SignedTreeHeadWithConsistencyProof{SignedTreeHead: *newSTH, ConsistencyProof: *proof}})
+	if err != nil {
+		return nil, fmt.Errorf("error constructing InconsistentSTHPOM serializing data: %w", err)
+	}
+	digest, _, err := signature.GenerateHash(newSTH.Signature.Algorithm.Hash, blob)
+	if err != nil {
+		return nil, fmt.Errorf("error constructing InconsistentSTHPOM generating hash: %w", err)
+	}
+	return &mtr.CTObject{
+		TypeID: InconsistentSTHPOMTypeID,
+		Version: mtr.VersionData{Major: 1, Minor: 0, Release: 0},
+		Timestamp: newSTH.TreeHeadData.Timestamp,
+		Subject: newSTH.LogID,
+		Digest: digest,
+		Blob: blob,
+	}, nil
+}
+
+//DeconstructInconsistentSTHPOM returns the STHs and proof held by an InconsistentSTHPOM CTObject
+func DeconstructInconsistentSTHPOM(data *mtr.CTObject) (*InconsistentSTHPOM, error) {
+	var pom InconsistentSTHPOM
+	if err := json.Unmarshal(data.Blob, &pom); err != nil {
+		return nil, fmt.Errorf("error deconstructing InconsistentSTHPOM from %s CTObject: %w", data.TypeID, err)
+	}
+	return &pom, nil
+}
+
+// CosignatureSubject is the subject of the cosignatures of witness for the log, so that the
+// cosignatures of different witnesses for the same STH have different identifiers.
+// ':' is not used by base64, the encoding of log and monitor IDs.
+func CosignatureSubject(logID string, witness string) string {
+	return logID + ":" + witness
+}
+
+// CreateSTHCosignature signs sth as witness at timestamp, in milliseconds. The timestamp of the
+// CTObject is the one of the STH and its subject is given by CosignatureSubject.
+func CreateSTHCosignature(signer *signature.Signer, witness string, sth *mtr.SignedTreeHeadData, timestamp uint64) (*mtr.CTObject, error) {
+	tbs := CosignedTreeHead{Witness: witness, Timestamp: timestamp, STH: *sth}
+	sig, err := signer.CreateSignature(tls.SHA256, tbs)
+	if err != nil {
+		return nil, fmt.Errorf("error creating STHCosignature: %w", err)
+	}
+	blob, err := signature.SerializeData(STHCosignature{tbs, *sig})
+	if err != nil {
+		return nil, fmt.Errorf("error constructing STHCosignature serializing data: %w", err)
+	}
+	digest, _, err := signature.GenerateHash(sig.Algorithm.Hash, blob)
+	if err != nil {
+		return nil, fmt.Errorf("error constructing STHCosignature generating hash: %w", err)
+	}
+	return &mtr.CTObject{
+		TypeID: STHCosignatureTypeID,
+		Version: mtr.VersionData{Major: 1, Minor: 0, Release: 0},
+		Timestamp: sth.TreeHeadData.Timestamp,
+		Signer: witness,
+		Subject: CosignatureSubject(sth.LogID, witness),
+		Digest: digest,
+		Blob: blob,
+	}, nil
+}
+
+//DeconstructSTHCosignature returns the cosignature held by an STHCosignature CTObject
+func DeconstructSTHCosignature(data *mtr.CTObject) (*STHCosignature, error) {
+	var cosig STHCosignature
+	if err := json.Unmarshal(data.Blob, &cosig); err != nil {
+		return nil, fmt.Errorf("error deconstructing STHCosignature from %s CTObject: %w", data.TypeID, err)
 	}
-	return &gossipConfig;
+	return &cosig, nil
 }